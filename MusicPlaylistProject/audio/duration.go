@@ -0,0 +1,82 @@
+// Package audio reads stream information straight from audio file headers
+package audio
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+var ErrUnknownFormat = errors.New("unknown audio format")
+
+// Duration opens the file at path and probes its playing time.
+func Duration(path string) (time.Duration, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return ProbeDuration(file)
+}
+
+// ProbeDuration sniffs the container format of r and returns the playing time
+// reported by its headers, walking the stream where the headers are not enough.
+// It reads from the start of r wherever r was left, such as after reading
+// tags.
+func ProbeDuration(r io.ReadSeeker) (time.Duration, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	header := make([]byte, 12)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+	header = header[:n]
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		return flacDuration(r)
+	case bytes.HasPrefix(header, []byte("RIFF")):
+		return wavDuration(r)
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return oggDuration(r)
+	case len(header) >= 8 && string(header[4:8]) == "ftyp":
+		return mp4Duration(r)
+	case bytes.HasPrefix(header, []byte("ID3")):
+		// FLAC files occasionally carry a leading ID3v2 tag as well
+		size, err := id3v2Size(r)
+		if err != nil {
+			return 0, err
+		}
+		magic := make([]byte, 4)
+		if _, err := r.Seek(size, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(r, magic); err == nil && string(magic) == "fLaC" {
+			return flacDurationAt(r, size)
+		}
+		return mp3Duration(r)
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		return mp3Duration(r)
+	}
+
+	return 0, ErrUnknownFormat
+}
+
+func samplesToDuration(samples uint64, sampleRate uint32) time.Duration {
+	if sampleRate == 0 {
+		return 0
+	}
+	seconds := samples / uint64(sampleRate)
+	remainder := samples % uint64(sampleRate)
+	return time.Duration(seconds)*time.Second +
+		time.Duration(remainder)*time.Second/time.Duration(sampleRate)
+}
@@ -0,0 +1,46 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var errNoStreamInfo = errors.New("FLAC stream has no STREAMINFO block")
+
+func flacDuration(r io.ReadSeeker) (time.Duration, error) {
+	return flacDurationAt(r, 0)
+}
+
+// flacDurationAt reads the STREAMINFO block of a FLAC stream whose "fLaC" marker
+// starts at offset. STREAMINFO is required to be the first metadata block.
+func flacDurationAt(r io.ReadSeeker, offset int64) (time.Duration, error) {
+	if _, err := r.Seek(offset+4, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	if header[0]&0x7F != 0 {
+		return 0, errNoStreamInfo
+	}
+
+	info := make([]byte, 34)
+	if _, err := io.ReadFull(r, info); err != nil {
+		return 0, err
+	}
+
+	// bytes 10..17: 20 bits sample rate, 3 bits channels, 5 bits bits per
+	// sample, 36 bits total samples
+	packed := binary.BigEndian.Uint64(info[10:18])
+	sampleRate := uint32(packed >> 44)
+	totalSamples := packed & 0xFFFFFFFFF
+
+	if sampleRate == 0 {
+		return 0, errNoStreamInfo
+	}
+	return samplesToDuration(totalSamples, sampleRate), nil
+}
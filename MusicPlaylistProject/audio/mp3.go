@@ -0,0 +1,289 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	mpeg25 = 0
+	mpeg2  = 2
+	mpeg1  = 3
+
+	layer3 = 1
+	layer2 = 2
+	layer1 = 3
+)
+
+var mp3Bitrates = map[[2]int][16]int{
+	{mpeg1, layer1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+	{mpeg1, layer2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+	{mpeg1, layer3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	{mpeg2, layer1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+	{mpeg2, layer2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	{mpeg2, layer3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+}
+
+var mp3SampleRates = map[int][3]uint32{
+	mpeg1:  {44100, 48000, 32000},
+	mpeg2:  {22050, 24000, 16000},
+	mpeg25: {11025, 12000, 8000},
+}
+
+type mp3Frame struct {
+	version    int
+	layer      int
+	bitrate    int // bits per second
+	sampleRate uint32
+	padding    int
+	mono       bool
+}
+
+var errNoFrame = errors.New("no MPEG audio frame found")
+
+func parseMP3Header(b []byte) (mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+
+	version := int(b[1]>>3) & 0x3
+	layer := int(b[1]>>1) & 0x3
+	bitrateIndex := int(b[2] >> 4)
+	sampleRateIndex := int(b[2]>>2) & 0x3
+	if version == 1 || layer == 0 || bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return mp3Frame{}, false
+	}
+
+	tableVersion := version
+	if tableVersion == mpeg25 {
+		tableVersion = mpeg2
+	}
+
+	return mp3Frame{
+		version:    version,
+		layer:      layer,
+		bitrate:    mp3Bitrates[[2]int{tableVersion, layer}][bitrateIndex] * 1000,
+		sampleRate: mp3SampleRates[version][sampleRateIndex],
+		padding:    int(b[2]>>1) & 0x1,
+		mono:       b[3]>>6 == 3,
+	}, true
+}
+
+func (f mp3Frame) samples() int {
+	switch {
+	case f.layer == layer1:
+		return 384
+	case f.layer == layer3 && f.version != mpeg1:
+		return 576
+	}
+	return 1152
+}
+
+func (f mp3Frame) length() int {
+	rate := int(f.sampleRate)
+	switch {
+	case f.layer == layer1:
+		return (12*f.bitrate/rate + f.padding) * 4
+	case f.layer == layer3 && f.version != mpeg1:
+		return 72*f.bitrate/rate + f.padding
+	}
+	return 144*f.bitrate/rate + f.padding
+}
+
+// xingOffset is where a Xing/Info header starts, right after the side information.
+func (f mp3Frame) xingOffset() int {
+	switch {
+	case f.version == mpeg1 && f.mono:
+		return 4 + 17
+	case f.version == mpeg1:
+		return 4 + 32
+	case f.mono:
+		return 4 + 9
+	}
+	return 4 + 17
+}
+
+// id3v2Size returns the number of bytes taken by a leading ID3v2 tag, or 0.
+func id3v2Size(r io.ReadSeeker) (int64, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, nil
+	}
+	if string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+
+	size := int64(header[6]&0x7F)<<21 | int64(header[7]&0x7F)<<14 |
+		int64(header[8]&0x7F)<<7 | int64(header[9]&0x7F)
+	size += 10
+	if header[5]&0x10 != 0 {
+		size += 10 // footer present
+	}
+	return size, nil
+}
+
+// trailingTagSize returns the number of bytes taken by ID3v1 and APEv2 tags at
+// the end of a stream of the given size.
+func trailingTagSize(r io.ReadSeeker, size int64) (int64, error) {
+	var trailing int64
+
+	if size >= 128 {
+		buf := make([]byte, 3)
+		if _, err := r.Seek(size-128, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(r, buf); err == nil && string(buf) == "TAG" {
+			trailing += 128
+		}
+	}
+
+	if size-trailing >= 32 {
+		footer := make([]byte, 32)
+		if _, err := r.Seek(size-trailing-32, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(r, footer); err == nil && string(footer[:8]) == "APETAGEX" {
+			trailing += int64(binary.LittleEndian.Uint32(footer[12:16]))
+			if binary.LittleEndian.Uint32(footer[20:24])&(1<<31) != 0 {
+				trailing += 32 // header present
+			}
+		}
+	}
+
+	if trailing > size {
+		return 0, nil
+	}
+	return trailing, nil
+}
+
+// findFirstFrame scans forward from offset for a frame header that is followed
+// by a second valid header, which rules out most false syncs inside junk data.
+func findFirstFrame(r io.ReadSeeker, offset, end int64) (int64, mp3Frame, error) {
+	const window = 64 * 1024
+
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return 0, mp3Frame{}, err
+	}
+
+	buf := make([]byte, window)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, mp3Frame{}, err
+	}
+	buf = buf[:n]
+
+	for i := 0; i+4 <= len(buf) && offset+int64(i) < end; i++ {
+		frame, ok := parseMP3Header(buf[i:])
+		if !ok {
+			continue
+		}
+		next := i + frame.length()
+		if next+4 <= len(buf) {
+			if _, ok := parseMP3Header(buf[next:]); !ok {
+				continue
+			}
+		}
+		return offset + int64(i), frame, nil
+	}
+
+	return 0, mp3Frame{}, errNoFrame
+}
+
+func mp3Duration(r io.ReadSeeker) (time.Duration, error) {
+	start, err := id3v2Size(r)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	trailing, err := trailingTagSize(r, size)
+	if err != nil {
+		return 0, err
+	}
+	end := size - trailing
+
+	offset, first, err := findFirstFrame(r, start, end)
+	if err != nil {
+		return 0, err
+	}
+
+	if frames, ok, err := vbrFrameCount(r, offset, first); err != nil {
+		return 0, err
+	} else if ok {
+		return samplesToDuration(uint64(frames)*uint64(first.samples()), first.sampleRate), nil
+	}
+
+	return walkMP3Frames(r, offset, end, first.sampleRate)
+}
+
+// vbrFrameCount reads the frame count from a Xing/Info or VBRI header stored in
+// the first frame, if the encoder wrote one.
+func vbrFrameCount(r io.ReadSeeker, offset int64, frame mp3Frame) (uint32, bool, error) {
+	buf := make([]byte, frame.length())
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return 0, false, err
+	}
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, false, err
+	}
+	buf = buf[:n]
+
+	if x := frame.xingOffset(); x+12 <= len(buf) {
+		tag := string(buf[x : x+4])
+		if tag == "Xing" || tag == "Info" {
+			flags := binary.BigEndian.Uint32(buf[x+4 : x+8])
+			if flags&0x1 != 0 {
+				return binary.BigEndian.Uint32(buf[x+8 : x+12]), true, nil
+			}
+		}
+	}
+
+	const vbri = 4 + 32
+	if vbri+18 <= len(buf) && string(buf[vbri:vbri+4]) == "VBRI" {
+		return binary.BigEndian.Uint32(buf[vbri+14 : vbri+18]), true, nil
+	}
+
+	return 0, false, nil
+}
+
+// walkMP3Frames sums the samples of every frame between offset and end. It is
+// used for streams without a VBR header, whether they are CBR or VBR.
+func walkMP3Frames(r io.ReadSeeker, offset, end int64, sampleRate uint32) (time.Duration, error) {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	reader := bufio.NewReaderSize(io.LimitReader(r, end-offset), 64*1024)
+	header := make([]byte, 4)
+	var samples uint64
+
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			break
+		}
+		frame, ok := parseMP3Header(header)
+		if !ok || frame.sampleRate != sampleRate {
+			break
+		}
+		if _, err := reader.Discard(frame.length() - 4); err != nil {
+			break
+		}
+		samples += uint64(frame.samples())
+	}
+
+	if samples == 0 {
+		return 0, errNoFrame
+	}
+	return samplesToDuration(samples, sampleRate), nil
+}
@@ -0,0 +1,147 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var errNoMovieHeader = errors.New("MP4 file has no mvhd or mdhd atom")
+
+type mp4Atom struct {
+	kind   string
+	offset int64 // start of the payload
+	size   int64 // payload size
+}
+
+// mp4Atoms lists the atoms found between start and end.
+func mp4Atoms(r io.ReadSeeker, start, end int64) ([]mp4Atom, error) {
+	var atoms []mp4Atom
+	header := make([]byte, 8)
+
+	for pos := start; pos+8 <= end; {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		headerSize := int64(8)
+		switch size {
+		case 0:
+			size = end - pos
+		case 1:
+			large := make([]byte, 8)
+			if _, err := io.ReadFull(r, large); err != nil {
+				return nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(large))
+			headerSize = 16
+		}
+		if size < headerSize || pos+size > end {
+			break
+		}
+
+		atoms = append(atoms, mp4Atom{
+			kind:   string(header[4:8]),
+			offset: pos + headerSize,
+			size:   size - headerSize,
+		})
+		pos += size
+	}
+
+	return atoms, nil
+}
+
+func findMP4Atom(atoms []mp4Atom, kind string) (mp4Atom, bool) {
+	for _, atom := range atoms {
+		if atom.kind == kind {
+			return atom, true
+		}
+	}
+	return mp4Atom{}, false
+}
+
+// mp4Duration prefers the movie header and falls back to the media header of
+// the first track when the movie duration is missing.
+func mp4Duration(r io.ReadSeeker) (time.Duration, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	top, err := mp4Atoms(r, 0, size)
+	if err != nil {
+		return 0, err
+	}
+	moov, ok := findMP4Atom(top, "moov")
+	if !ok {
+		return 0, errNoMovieHeader
+	}
+
+	children, err := mp4Atoms(r, moov.offset, moov.offset+moov.size)
+	if err != nil {
+		return 0, err
+	}
+
+	if mvhd, ok := findMP4Atom(children, "mvhd"); ok {
+		if d, err := readMP4Header(r, mvhd); err == nil && d > 0 {
+			return d, nil
+		}
+	}
+
+	for _, trak := range children {
+		if trak.kind != "trak" {
+			continue
+		}
+		trakChildren, err := mp4Atoms(r, trak.offset, trak.offset+trak.size)
+		if err != nil {
+			return 0, err
+		}
+		mdia, ok := findMP4Atom(trakChildren, "mdia")
+		if !ok {
+			continue
+		}
+		mdiaChildren, err := mp4Atoms(r, mdia.offset, mdia.offset+mdia.size)
+		if err != nil {
+			return 0, err
+		}
+		if mdhd, ok := findMP4Atom(mdiaChildren, "mdhd"); ok {
+			return readMP4Header(r, mdhd)
+		}
+	}
+
+	return 0, errNoMovieHeader
+}
+
+// readMP4Header decodes the timescale and duration shared by mvhd and mdhd.
+func readMP4Header(r io.ReadSeeker, atom mp4Atom) (time.Duration, error) {
+	if _, err := r.Seek(atom.offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 32)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return 0, err
+	}
+	buf = buf[:n]
+
+	var timescale uint32
+	var duration uint64
+	switch {
+	case len(buf) >= 32 && buf[0] == 1:
+		timescale = binary.BigEndian.Uint32(buf[20:24])
+		duration = binary.BigEndian.Uint64(buf[24:32])
+	case len(buf) >= 20 && buf[0] == 0:
+		timescale = binary.BigEndian.Uint32(buf[12:16])
+		duration = uint64(binary.BigEndian.Uint32(buf[16:20]))
+	default:
+		return 0, errNoMovieHeader
+	}
+
+	return samplesToDuration(duration, timescale), nil
+}
@@ -0,0 +1,121 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+const (
+	oggHeaderSize = 27
+	opusRate      = 48000
+)
+
+var errBadOgg = errors.New("unsupported Ogg stream")
+
+type oggPage struct {
+	granule  int64
+	serial   uint32
+	segments []byte
+}
+
+func readOggPage(r io.Reader) (oggPage, []byte, error) {
+	header := make([]byte, oggHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return oggPage{}, nil, err
+	}
+	if string(header[:4]) != "OggS" {
+		return oggPage{}, nil, errBadOgg
+	}
+
+	page := oggPage{
+		granule:  int64(binary.LittleEndian.Uint64(header[6:14])),
+		serial:   binary.LittleEndian.Uint32(header[14:18]),
+		segments: make([]byte, header[26]),
+	}
+	if _, err := io.ReadFull(r, page.segments); err != nil {
+		return oggPage{}, nil, err
+	}
+
+	var bodySize int
+	for _, s := range page.segments {
+		bodySize += int(s)
+	}
+	body := make([]byte, bodySize)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return oggPage{}, nil, err
+	}
+
+	return page, body, nil
+}
+
+// oggDuration reads the codec identification header from the first page and
+// divides the granule position of the last page of the same stream by the rate.
+func oggDuration(r io.ReadSeeker) (time.Duration, error) {
+	first, body, err := readOggPage(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var sampleRate uint32
+	var preSkip int64
+	switch {
+	case len(body) >= 16 && string(body[:7]) == "\x01vorbis":
+		sampleRate = binary.LittleEndian.Uint32(body[12:16])
+	case len(body) >= 12 && string(body[:8]) == "OpusHead":
+		// Opus granule positions always count 48 kHz samples
+		sampleRate = opusRate
+		preSkip = int64(binary.LittleEndian.Uint16(body[10:12]))
+	default:
+		return 0, errBadOgg
+	}
+
+	granule, err := lastGranule(r, first.serial)
+	if err != nil {
+		return 0, err
+	}
+
+	granule -= preSkip
+	if granule < 0 {
+		granule = 0
+	}
+	return samplesToDuration(uint64(granule), sampleRate), nil
+}
+
+// lastGranule searches backwards from the end of the file for the last page of
+// the given logical stream that carries a granule position.
+func lastGranule(r io.ReadSeeker, serial uint32) (int64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+
+	for window := int64(64 * 1024); ; window *= 4 {
+		if window > size {
+			window = size
+		}
+		if _, err := r.Seek(size-window, io.SeekStart); err != nil {
+			return 0, err
+		}
+		buf := make([]byte, window)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return 0, err
+		}
+
+		for i := bytes.LastIndex(buf, []byte("OggS")); i >= 0; i = bytes.LastIndex(buf[:i], []byte("OggS")) {
+			if i+oggHeaderSize > len(buf) {
+				continue
+			}
+			granule := int64(binary.LittleEndian.Uint64(buf[i+6 : i+14]))
+			if binary.LittleEndian.Uint32(buf[i+14:i+18]) == serial && granule != -1 {
+				return granule, nil
+			}
+		}
+
+		if window == size {
+			return 0, errBadOgg
+		}
+	}
+}
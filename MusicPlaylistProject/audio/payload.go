@@ -0,0 +1,180 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrNoPayload is returned for files that hold no audio data, such as a file
+// with only a tag or one truncated before its first frame. Hashing these would
+// make every such file look like the same recording.
+var ErrNoPayload = errors.New("no audio data")
+
+// CopyPayload writes the audio data of r to w, leaving out tag blocks, so two
+// files that differ only in their metadata produce the same bytes. It returns
+// ErrNoPayload when there is no audio data to write.
+func CopyPayload(w io.Writer, r io.ReadSeeker) error {
+	counter := &countingWriter{w: w}
+	if err := copyPayload(counter, r); err != nil {
+		return err
+	}
+	if counter.n == 0 {
+		return ErrNoPayload
+	}
+	return nil
+}
+
+func copyPayload(w io.Writer, r io.ReadSeeker) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	header := make([]byte, 12)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	header = header[:n]
+
+	switch {
+	case bytes.HasPrefix(header, []byte("RIFF")):
+		return copyWAVPayload(w, r)
+	case bytes.HasPrefix(header, []byte("OggS")):
+		return copyOggPayload(w, r)
+	case len(header) >= 8 && string(header[4:8]) == "ftyp":
+		return copyMP4Payload(w, r)
+	}
+
+	start, err := id3v2Size(r)
+	if err != nil {
+		return err
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err == nil && string(magic) == "fLaC" {
+		start, err = flacAudioOffset(r, start)
+		if err != nil {
+			return err
+		}
+	}
+
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	trailing, err := trailingTagSize(r, size)
+	if err != nil {
+		return err
+	}
+	if start >= size-trailing {
+		return nil
+	}
+
+	return copyRange(w, r, start, size-trailing-start)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func copyRange(w io.Writer, r io.ReadSeeker, offset, length int64) error {
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.CopyN(w, r, length)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
+
+// flacAudioOffset skips every metadata block of a FLAC stream starting at offset.
+func flacAudioOffset(r io.ReadSeeker, offset int64) (int64, error) {
+	pos := offset + 4
+	header := make([]byte, 4)
+	for {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return 0, err
+		}
+		if _, err := io.ReadFull(r, header); err != nil {
+			return 0, err
+		}
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		pos += 4 + length
+		if header[0]&0x80 != 0 {
+			return pos, nil
+		}
+	}
+}
+
+func copyWAVPayload(w io.Writer, r io.ReadSeeker) error {
+	chunk := make([]byte, 8)
+	for pos := int64(12); ; {
+		if _, err := r.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			return errBadWAV
+		}
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		if string(chunk[:4]) == "data" {
+			return copyRange(w, r, pos+8, size)
+		}
+		pos += 8 + size + size%2
+	}
+}
+
+// copyOggPayload copies the packet data of audio pages only. Header pages have
+// a granule position of zero, and page headers are skipped because retagging
+// renumbers the pages that follow the comment header.
+func copyOggPayload(w io.Writer, r io.ReadSeeker) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	for {
+		page, body, err := readOggPage(r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if page.granule == 0 {
+			continue
+		}
+		if _, err := w.Write(body); err != nil {
+			return err
+		}
+	}
+}
+
+// copyMP4Payload copies the media data atoms, the metadata lives under moov.
+func copyMP4Payload(w io.Writer, r io.ReadSeeker) error {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	atoms, err := mp4Atoms(r, 0, size)
+	if err != nil {
+		return err
+	}
+	for _, atom := range atoms {
+		if atom.kind != "mdat" {
+			continue
+		}
+		if err := copyRange(w, r, atom.offset, atom.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+)
+
+var errBadWAV = errors.New("invalid RIFF/WAVE file")
+
+func wavDuration(r io.ReadSeeker) (time.Duration, error) {
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return 0, errBadWAV
+	}
+
+	var byteRate uint32
+	var dataSize int64 = -1
+	chunk := make([]byte, 8)
+
+	for byteRate == 0 || dataSize < 0 {
+		if _, err := io.ReadFull(r, chunk); err != nil {
+			break
+		}
+		id := string(chunk[:4])
+		size := int64(binary.LittleEndian.Uint32(chunk[4:8]))
+
+		switch id {
+		case "fmt ":
+			format := make([]byte, 16)
+			if _, err := io.ReadFull(r, format); err != nil {
+				return 0, err
+			}
+			byteRate = binary.LittleEndian.Uint32(format[8:12])
+			size -= 16
+		case "data":
+			dataSize = size
+		}
+
+		// chunks are padded to an even number of bytes
+		if _, err := r.Seek(size+size%2, io.SeekCurrent); err != nil {
+			return 0, err
+		}
+	}
+
+	if byteRate == 0 || dataSize < 0 {
+		return 0, errBadWAV
+	}
+
+	return time.Duration(dataSize) * time.Second / time.Duration(byteRate), nil
+}
@@ -3,11 +3,16 @@ package cli
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"musicplaylist/manager"
 	"musicplaylist/models"
+	"musicplaylist/playlistio"
 	"musicplaylist/scanner"
+	"musicplaylist/search"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +21,13 @@ import (
 type CLI struct {
 	manager *manager.PlaylistManager
 	scanner *bufio.Scanner
+
+	// fuzzySearch lets searches match words with typos
+	fuzzySearch bool
+
+	// lastConflict is the conflict last reported while refreshing, so it is
+	// only reported once
+	lastConflict string
 }
 
 func CreateCLI(mgr *manager.PlaylistManager) *CLI {
@@ -30,6 +42,7 @@ func (c *CLI) Run() {
 	fmt.Println()
 
 	for {
+		c.refresh()
 		c.showMainMenu()
 		choice := c.readInput("Enter your choice: ")
 
@@ -54,6 +67,44 @@ func (c *CLI) Run() {
 			c.deletePlaylist()
 		case "10":
 			c.showStatistics()
+		case "11":
+			c.editSong()
+		case "12":
+			c.deleteSongFromLibrary()
+		case "13":
+			c.findDuplicates()
+		case "14":
+			c.rescanFolder()
+		case "15":
+			c.createSmartPlaylist()
+		case "16":
+			c.toggleFuzzySearch()
+		case "17":
+			c.exportPlaylist()
+		case "18":
+			c.importPlaylist()
+		case "19":
+			c.importITunesLibrary()
+		case "20":
+			c.exportTable()
+		case "21":
+			c.importTable()
+		case "22":
+			c.exportBundle()
+		case "23":
+			c.restoreBackup()
+		case "24":
+			c.showHistory()
+		case "25":
+			c.undo()
+		case "26":
+			c.redo()
+		case "27":
+			c.playlistRevisions()
+		case "28":
+			c.reorderPlaylist()
+		case "29":
+			c.sortPlaylist()
 		case "0":
 			c.exit()
 			return
@@ -76,9 +127,36 @@ func (c *CLI) showMainMenu() {
 	fmt.Println("8. Shuffle Playlist")
 	fmt.Println("9. Delete Playlist")
 	fmt.Println("10. Show Statistics")
+	fmt.Println("11. Edit Song Metadata")
+	fmt.Println("12. Delete Song from Library")
+	fmt.Println("13. Find Duplicate Songs")
+	fmt.Println("14. Rescan Folder")
+	fmt.Println("15. Create Smart Playlist")
+	fmt.Printf("16. Toggle Fuzzy Search (currently %s)\n", onOff(c.fuzzySearch))
+	fmt.Println("17. Export Playlist (M3U, XSPF or PLS)")
+	fmt.Println("18. Import Playlist (M3U, XSPF or PLS)")
+	fmt.Println("19. Import iTunes Library")
+	fmt.Println("20. Export Songs to CSV/TSV")
+	fmt.Println("21. Import Song Edits from CSV/TSV")
+	fmt.Println("22. Copy Playlist to a Device Folder or Zip")
+	fmt.Println("23. Restore a Backup")
+	fmt.Println("24. Show Change History")
+	undo, redo := c.manager.UndoState()
+	fmt.Printf("25. Undo%s\n", describeStep(undo))
+	fmt.Printf("26. Redo%s\n", describeStep(redo))
+	fmt.Println("27. Playlist Revisions")
+	fmt.Println("28. Reorder Playlist Songs")
+	fmt.Println("29. Sort Playlist")
 	fmt.Println("0. Exit")
 }
 
+func describeStep(description string) string {
+	if description == "" {
+		return ""
+	}
+	return " (" + description + ")"
+}
+
 func (c *CLI) readInput(prompt string) string {
 	fmt.Print(prompt)
 	c.scanner.Scan()
@@ -99,6 +177,116 @@ func (c *CLI) createPlaylist() {
 	fmt.Printf("Created playlist: %s\n", playlist.ToString())
 }
 
+func (c *CLI) createSmartPlaylist() {
+	fmt.Println("\n CREATE SMART PLAYLIST")
+	name := c.readInput("Playlist name: ")
+	if name == "" {
+		fmt.Println("Playlist name cannot be empty.")
+		return
+	}
+	description := c.readInput("Description: ")
+
+	fmt.Println("\nRULES (leave blank to skip)")
+	var rules []*models.Rule
+	if genre := c.readInput("Genre is: "); genre != "" {
+		rules = append(rules, &models.Rule{Op: models.RuleGenreEquals, Value: genre})
+	}
+	if genre := c.readInput("Genre is not: "); genre != "" {
+		rules = append(rules, &models.Rule{Op: models.RuleNot, Rules: []*models.Rule{
+			{Op: models.RuleGenreEquals, Value: genre},
+		}})
+	}
+
+	yearFrom, hasFrom, err := c.readOptionalInt("Year from: ")
+	if err != nil {
+		fmt.Println("Invalid year.")
+		return
+	}
+	yearTo, hasTo, err := c.readOptionalInt("Year to: ")
+	if err != nil {
+		fmt.Println("Invalid year.")
+		return
+	}
+	if hasFrom || hasTo {
+		if !hasTo {
+			yearTo = 9999
+		}
+		rules = append(rules, &models.Rule{Op: models.RuleYearBetween, Min: yearFrom, Max: yearTo})
+	}
+
+	if artists := c.readInput("Artists (comma separated): "); artists != "" {
+		var values []string
+		for _, artist := range strings.Split(artists, ",") {
+			if artist = strings.TrimSpace(artist); artist != "" {
+				values = append(values, artist)
+			}
+		}
+		rules = append(rules, &models.Rule{Op: models.RuleArtistIn, Values: values})
+	}
+
+	minutes, ok, err := c.readOptionalInt("Shorter than (minutes): ")
+	if err != nil {
+		fmt.Println("Invalid number of minutes.")
+		return
+	}
+	if ok {
+		rules = append(rules, &models.Rule{Op: models.RuleDurationUnder, Duration: time.Duration(minutes) * time.Minute})
+	}
+
+	days, ok, err := c.readOptionalInt("Added within (days): ")
+	if err != nil {
+		fmt.Println("Invalid number of days.")
+		return
+	}
+	if ok {
+		rules = append(rules, &models.Rule{Op: models.RuleAddedWithinDays, Days: days})
+	}
+
+	if len(rules) == 0 {
+		fmt.Println("A smart playlist needs at least one rule.")
+		return
+	}
+
+	match := &models.Rule{Op: models.RuleAnd, Rules: rules}
+	if strings.ToLower(c.readInput("Match all or any of the rules? (all/any): ")) == "any" {
+		match.Op = models.RuleOr
+	}
+	criteria := &models.SmartCriteria{Match: match}
+
+	if criteria.Limit, _, err = c.readOptionalInt("Limit to this many songs: "); err != nil {
+		fmt.Println("Invalid limit.")
+		return
+	}
+	if strings.ToLower(c.readInput("Random order? (y/n): ")) == "y" {
+		criteria.Random = true
+	} else {
+		criteria.SortBy = c.readInput(fmt.Sprintf("Sort by (%s): ", strings.Join(models.SortFields, "/")))
+		if criteria.SortBy != "" {
+			criteria.Descending = strings.ToLower(c.readInput("Descending? (y/n): ")) == "y"
+		}
+	}
+
+	playlist, err := c.manager.CreateSmartPlaylist(name, description, criteria)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	fmt.Printf("Created playlist: %s\n", playlist.ToString())
+}
+
+// readOptionalInt reads a number, reporting false when the input was left blank.
+func (c *CLI) readOptionalInt(prompt string) (int, bool, error) {
+	input := c.readInput(prompt)
+	if input == "" {
+		return 0, false, nil
+	}
+	value, err := strconv.Atoi(input)
+	if err != nil {
+		return 0, false, err
+	}
+	return value, true, nil
+}
+
 func (c *CLI) listPlaylists() {
 	playlists := c.manager.ListPlaylists()
 
@@ -164,16 +352,14 @@ func (c *CLI) addFolderToPlaylist() {
 
 	fmt.Println("\nSCAN FOLDER")
 	path := c.readInput("Enter the path of the folder you want to scan: ")
-	songs, err := scanner.ScanMusicFolder(path)
+	diff, err := c.manager.PlanRescan(path, playlist.ID)
 
 	if err != nil {
 		fmt.Printf("Error scanning foler: %v\n", err)
 		return
 	}
 
-	playlist.AddSongs(songs)
-
-	fmt.Printf("Added %d songs \n", len(songs))
+	c.applyRescan(diff)
 }
 
 func (c *CLI) addSongToPlaylist() {
@@ -194,21 +380,30 @@ func (c *CLI) addSongToPlaylist() {
 
 	fmt.Println("\nADD SONG")
 	path := c.readInput("Enter the path of the file: ")
-	durationString := c.readInput("Enter the duration in seconds: ")
-	duration, err := parseDuration(durationString)
-	if err != nil {
-		duration = time.Duration(180) * time.Second
-	}
-	song, err := models.NewSongFromPath(path, duration)
+	song, err := scanner.ScanFile(path)
 
 	if err != nil {
 		fmt.Printf("Error adding song: %v\n", err)
+		return
+	}
 
-	} else {
-		playlist.AddSong(song)
-
-		fmt.Printf("Added song: %s\n", song.ToString())
+	position, set, err := c.readOptionalInt(fmt.Sprintf("Position to insert at, 1-%d (Enter for the end): ", len(playlist.Songs)+1))
+	if err != nil {
+		fmt.Println("Invalid number.")
+		return
+	}
+	if set {
+		added, err := c.manager.InsertSongsIntoPlaylist(playlist.ID, position-1, []*models.Song{song})
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		song = added[0]
+	} else if song, err = c.manager.AddSongToPlaylist(playlist.ID, song); err != nil {
+		fmt.Printf("%v\n", err)
+		return
 	}
+	fmt.Printf("Added song: %s\n", song.ToString())
 
 }
 
@@ -239,23 +434,39 @@ func (c *CLI) removeSongFromPlaylist() {
 	}
 
 	songID := c.readInput("\nEnter song ID to remove: ")
-	if playlist.RemoveSong(songID) {
-		fmt.Println("Song removed successfully.")
-	} else {
-		fmt.Println("Song not found.")
+	if err := c.manager.RemoveSongFromPlaylist(playlist.ID, songID); err != nil {
+		fmt.Printf("%v\n", err)
+		return
 	}
+	fmt.Println("Song removed successfully.")
 }
 
 func (c *CLI) searchSongs() {
-	query := c.readInput("\nEnter search query: ")
+	fmt.Println("\nSearch terms can name a field and be combined, e.g.")
+	fmt.Println(`  artist:"Miles Davis" year:1955..1965 -genre:live duration:>5m`)
+	fmt.Println("Fields: title, artist, album, genre, path, year, duration. Use OR, - and ( ).")
+	query := c.readInput("Enter search query: ")
 	if query == "" {
 		return
 	}
 
-	results := c.manager.SearchSongs(query)
+	results, err := c.manager.SearchSongs(query, c.fuzzySearch)
+	var parseErr *search.ParseError
+	if errors.As(err, &parseErr) {
+		// Point at the problem under the query as it was typed
+		fmt.Printf("  %s\n  %s^\n", query, strings.Repeat(" ", parseErr.Pos-1))
+		fmt.Printf("Invalid query: %v\n", err)
+		return
+	} else if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
 
 	if len(results) == 0 {
 		fmt.Println("No songs found matching your query.")
+		if !c.fuzzySearch {
+			fmt.Println("Turn on fuzzy search (option 16) to allow for typos.")
+		}
 		return
 	}
 
@@ -265,6 +476,18 @@ func (c *CLI) searchSongs() {
 	}
 }
 
+func (c *CLI) toggleFuzzySearch() {
+	c.fuzzySearch = !c.fuzzySearch
+	fmt.Printf("Fuzzy search is now %s.\n", onOff(c.fuzzySearch))
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
+
 func (c *CLI) shufflePlaylist() {
 	playlists := c.manager.ListPlaylists()
 	if len(playlists) == 0 {
@@ -286,10 +509,163 @@ func (c *CLI) shufflePlaylist() {
 		return
 	}
 
-	playlist.Shuffle()
+	if _, err := c.manager.ShufflePlaylist(playlist.ID); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
 	fmt.Println("Playlist shuffled successfully.")
 }
 
+// reorderPlaylist moves songs around a playlist. Positions are shown and
+// read counting from 1.
+func (c *CLI) reorderPlaylist() {
+	playlists := c.manager.ListPlaylists()
+	if len(playlists) == 0 {
+		fmt.Println("\nNo playlists available.")
+		return
+	}
+
+	c.listPlaylists()
+	playlistID := c.readInput("\nEnter playlist ID: ")
+
+	playlist, err := c.manager.GetPlaylist(playlistID)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	if len(playlist.Songs) < 2 {
+		fmt.Println("Playlist needs at least two songs to reorder.")
+		return
+	}
+
+	fmt.Println("\nSONGS IN PLAYLIST:")
+	for i, song := range playlist.Songs {
+		fmt.Printf("%d. %s\n", i+1, song.ToString())
+	}
+
+	fmt.Println("\n1. Move songs to a position")
+	fmt.Println("2. Move a song up or down")
+	fmt.Println("3. Swap two songs")
+	switch c.readInput("Enter your choice: ") {
+	case "1":
+		positions, err := parsePositions(c.readInput("Songs to move (numbers separated by commas): "))
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		to, err := strconv.Atoi(c.readInput("Position for the first of them: "))
+		if err != nil {
+			fmt.Println("Invalid number.")
+			return
+		}
+		if _, err := c.manager.MoveSongs(playlist.ID, positions, to-1); err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+	case "2":
+		position, err := strconv.Atoi(c.readInput("Song to move: "))
+		if err != nil {
+			fmt.Println("Invalid number.")
+			return
+		}
+		offset, err := strconv.Atoi(c.readInput("Places to move it (negative moves it up): "))
+		if err != nil {
+			fmt.Println("Invalid number.")
+			return
+		}
+		if _, err := c.manager.MoveSongBy(playlist.ID, position-1, offset); err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+	case "3":
+		positions, err := parsePositions(c.readInput("Songs to swap (two numbers separated by a comma): "))
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		if len(positions) != 2 {
+			fmt.Println("Enter exactly two songs.")
+			return
+		}
+		if _, err := c.manager.SwapSongs(playlist.ID, positions[0], positions[1]); err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+	default:
+		fmt.Println("Invalid Option")
+		return
+	}
+	fmt.Println("Playlist reordered successfully.")
+}
+
+func (c *CLI) sortPlaylist() {
+	playlists := c.manager.ListPlaylists()
+	if len(playlists) == 0 {
+		fmt.Println("\nNo playlists available.")
+		return
+	}
+
+	c.listPlaylists()
+	playlistID := c.readInput("\nEnter playlist ID: ")
+
+	playlist, err := c.manager.GetPlaylist(playlistID)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	fmt.Printf("\nFields: %s\n", strings.Join(models.SortFields, ", "))
+	keys, err := models.ParseSortKeys(c.readInput("Sort by (comma-separated, - before a field for descending, e.g. artist,-year): "))
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	songs, err := c.manager.SortedSongs(playlist.ID, keys)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	fmt.Println("\nSORTED SONGS:")
+	for i, song := range songs {
+		fmt.Printf("%d. %s\n", i+1, song.ToString())
+	}
+
+	if playlist.IsSmart() {
+		return
+	}
+	if strings.ToLower(c.readInput("\nKeep the playlist in this order? (y/n): ")) != "y" {
+		fmt.Println("Playlist left as it was.")
+		return
+	}
+	if _, err := c.manager.SortPlaylist(playlist.ID, keys); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	fmt.Println("Playlist sorted successfully.")
+}
+
+// parsePositions reads a list of song numbers counting from 1, like "2, 5,
+// 7", as positions counting from 0.
+func parsePositions(input string) ([]int, error) {
+	positions := make([]int, 0)
+	for _, field := range strings.Split(input, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid song number %q", field)
+		}
+		positions = append(positions, n-1)
+	}
+	if len(positions) == 0 {
+		return nil, errors.New("no songs given")
+	}
+	return positions, nil
+}
+
 func (c *CLI) deletePlaylist() {
 	playlists := c.manager.ListPlaylists()
 	if len(playlists) == 0 {
@@ -332,32 +708,694 @@ func (c *CLI) showStatistics() {
 	}
 }
 
-func (c *CLI) exit() {
-	fmt.Println("\nSaving data...")
-	err := c.manager.Save()
+func (c *CLI) listLibrary() bool {
+	songs := c.manager.ListLibrary()
+	if len(songs) == 0 {
+		fmt.Println("\nLibrary is empty.")
+		return false
+	}
+
+	fmt.Println("\nLIBRARY:")
+	for i, song := range songs {
+		fmt.Printf("%d. %s\n", i+1, song.ToString())
+	}
+	return true
+}
+
+func (c *CLI) editSong() {
+	if !c.listLibrary() {
+		return
+	}
+
+	songID := c.readInput("\nEnter song ID to edit: ")
+	song, err := c.manager.GetSong(songID)
 	if err != nil {
-		fmt.Printf("Error saving data: %v\n", err)
+		fmt.Printf("%v\n", err)
+		return
 	}
-	fmt.Println("Exiting.")
+
+	fmt.Println("\nEDIT SONG (leave blank to keep the current value)")
+	title := c.readInput(fmt.Sprintf("Title [%s]: ", song.Title))
+	artist := c.readInput(fmt.Sprintf("Artist [%s]: ", song.Artist))
+	album := c.readInput(fmt.Sprintf("Album [%s]: ", song.Album))
+	genre := c.readInput(fmt.Sprintf("Genre [%s]: ", song.Genre))
+	yearString := c.readInput(fmt.Sprintf("Year [%d]: ", song.Year))
+
+	year := song.Year
+	if yearString != "" {
+		year, err = strconv.Atoi(yearString)
+		if err != nil {
+			fmt.Println("Invalid year.")
+			return
+		}
+	}
+
+	song, err = c.manager.UpdateSong(songID, func(s *models.Song) {
+		s.Title = keepIfEmpty(title, s.Title)
+		s.Artist = keepIfEmpty(artist, s.Artist)
+		s.Album = keepIfEmpty(album, s.Album)
+		s.Genre = keepIfEmpty(genre, s.Genre)
+		s.Year = year
+	})
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	fmt.Printf("Updated song: %s\n", song.ToString())
+}
+
+func (c *CLI) deleteSongFromLibrary() {
+	if !c.listLibrary() {
+		return
+	}
+
+	songID := c.readInput("\nEnter song ID to delete: ")
+
+	confirm := c.readInput("This removes the song from every playlist. Are you sure? (yes/no): ")
+	if strings.ToLower(confirm) != "yes" {
+		fmt.Println("Deletion cancelled.")
+		return
+	}
+
+	if err := c.manager.DeleteSong(songID); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	fmt.Println("Song deleted successfully.")
 }
 
-func parseDuration(s string) (time.Duration, error) {
-	parts := strings.Split(s, ":")
-	if len(parts) != 2 {
-		return 0, fmt.Errorf("invalid format, use MM:SS")
+func (c *CLI) findDuplicates() {
+	c.listPlaylists()
+	playlistID := c.readInput("\nEnter playlist ID (leave blank for the whole library): ")
+
+	groups, err := c.manager.FindDuplicates(playlistID)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	if len(groups) == 0 {
+		fmt.Println("No duplicates found.")
+		return
+	}
+
+	fmt.Printf("\nFound %d duplicate group(s):\n", len(groups))
+	for i, group := range groups {
+		fmt.Printf("%d. Same %s:\n", i+1, group.Kind)
+		for j, song := range group.Songs {
+			if group.Positions != nil {
+				fmt.Printf("   #%d %s\n", group.Positions[j]+1, song.ToString())
+			} else {
+				fmt.Printf("   %s\n", song.ToString())
+			}
+		}
+	}
+
+	confirm := c.readInput("\nRemove duplicates, keeping the first of each group? (yes/no): ")
+	if strings.ToLower(confirm) != "yes" {
+		return
 	}
 
-	minutes, err := strconv.Atoi(parts[0])
+	var removed int
+	if playlistID == "" {
+		removed, err = c.manager.DedupeLibrary(manager.AllDuplicateKinds)
+	} else {
+		removed, err = c.manager.DedupePlaylist(playlistID, manager.AllDuplicateKinds)
+	}
 	if err != nil {
-		return 0, err
+		fmt.Printf("%v\n", err)
+		return
+	}
+	fmt.Printf("Removed %d duplicate(s).\n", removed)
+}
+
+func (c *CLI) rescanFolder() {
+	roots := c.manager.ListScanRoots()
+	if len(roots) == 0 {
+		fmt.Println("\nNo folders have been scanned yet.")
+		return
+	}
+
+	fmt.Println("\nSCANNED FOLDERS:")
+	for i, root := range roots {
+		fmt.Printf("%d. %s (%d files, last scanned %s)\n", i+1, root.Path,
+			len(root.Files), root.LastScanned.Format("2006-01-02 15:04"))
 	}
 
-	seconds, err := strconv.Atoi(parts[1])
+	choice := c.readInput("\nEnter folder number: ")
+	index, err := strconv.Atoi(choice)
+	if err != nil || index < 1 || index > len(roots) {
+		fmt.Println("Invalid folder.")
+		return
+	}
+
+	diff, err := c.manager.PlanRescan(roots[index-1].Path, "")
 	if err != nil {
-		return 0, err
+		fmt.Printf("Error scanning folder: %v\n", err)
+		return
+	}
+
+	c.applyRescan(diff)
+}
+
+// applyRescan shows what a rescan would change and applies it once confirmed.
+func (c *CLI) applyRescan(diff *scanner.RescanDiff) {
+	fmt.Printf("\n%s: %s\n", diff.Root, diff.Summary())
+	if diff.Empty() {
+		fmt.Println("Nothing to update.")
+		return
+	}
+
+	printChanges("Added", diff.Added)
+	printChanges("Changed", diff.Changed)
+	printChanges("Moved", diff.Moved)
+	printChanges("Removed", diff.Removed)
+
+	confirm := c.readInput("\nApply these changes? (yes/no): ")
+	if strings.ToLower(confirm) != "yes" {
+		fmt.Println("Rescan cancelled.")
+		return
 	}
 
-	return time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second, nil
+	if err := c.manager.ApplyRescan(diff); err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	fmt.Println("Changes applied.")
+}
+
+func printChanges(label string, changes []scanner.FileChange) {
+	for _, change := range changes {
+		if change.OldPath != "" {
+			fmt.Printf("  %s: %s -> %s\n", label, change.OldPath, change.Path)
+		} else {
+			fmt.Printf("  %s: %s\n", label, change.Path)
+		}
+	}
+}
+
+func (c *CLI) exportPlaylist() {
+	playlists := c.manager.ListPlaylists()
+	if len(playlists) == 0 {
+		fmt.Println("\nNo playlists found.")
+		return
+	}
+
+	c.listPlaylists()
+	playlistID := c.readInput("\nEnter playlist ID: ")
+	playlist, err := c.manager.GetPlaylist(playlistID)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	path := c.readInput("Save as (.m3u8, .m3u for older players, .xspf or .pls): ")
+	if path == "" {
+		fmt.Println("File path cannot be empty.")
+		return
+	}
+	format, err := playlistio.FormatForPath(path)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	opts := playlistio.Options{BaseDir: filepath.Dir(path)}
+	if strings.ToLower(c.readInput("Write paths relative to the playlist file? (y/n): ")) == "y" {
+		opts.Paths = playlistio.RelativePaths
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	err = playlistio.Write(file, format, playlist, opts)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		fmt.Printf("Export failed: %v\n", err)
+		return
+	}
+	fmt.Printf("Exported %d songs to %s\n", len(playlist.Songs), path)
+}
+
+func (c *CLI) importPlaylist() {
+	path := c.readInput("\nPlaylist file to import (.m3u, .m3u8, .xspf or .pls): ")
+	format, err := playlistio.FormatForPath(path)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	defer file.Close()
+
+	result, err := playlistio.Import(file, format, filepath.Dir(path), scanner.ScanFile)
+	if err != nil {
+		fmt.Printf("Import failed: %v\n", err)
+		return
+	}
+
+	for _, entry := range result.Unresolved {
+		fmt.Printf("Line %d: could not load %s: %s\n", entry.Line, entry.Path, entry.Reason)
+	}
+	if len(result.Songs) == 0 {
+		fmt.Println("No songs could be loaded from the playlist.")
+		return
+	}
+
+	name := result.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	name = keepIfEmpty(c.readInput(fmt.Sprintf("Playlist name [%s]: ", name)), name)
+
+	playlist := c.manager.ImportPlaylist(name, "Imported from "+filepath.Base(path), result.Songs)
+	fmt.Printf("Imported playlist: %s\n", playlist.ToString())
+	if len(result.Unresolved) > 0 {
+		fmt.Printf("%d entries could not be loaded.\n", len(result.Unresolved))
+	}
+}
+
+func (c *CLI) importITunesLibrary() {
+	path := c.readInput("\nPath to Library.xml (File > Library > Export Library in iTunes or Music): ")
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	lib, err := playlistio.ReadITunesLibrary(file)
+	file.Close()
+	if err != nil {
+		fmt.Printf("Could not read library: %v\n", err)
+		return
+	}
+
+	dryRun := strings.ToLower(c.readInput("Dry run, only show what would be imported? (y/n): ")) == "y"
+	if dryRun {
+		fmt.Printf("\nWould import %d songs and %d playlists:\n", len(lib.Songs), len(lib.Playlists))
+		for _, playlist := range lib.Playlists {
+			fmt.Printf("  %s (%d songs)\n", playlist.Name, len(playlist.Songs))
+		}
+	}
+
+	if len(lib.Missing) > 0 {
+		fmt.Printf("\n%d songs are no longer where iTunes had them:\n", len(lib.Missing))
+		for _, song := range lib.Missing {
+			fmt.Printf("  %s\n", song.FilePath)
+		}
+	}
+	if len(lib.Skipped) > 0 {
+		fmt.Printf("\n%d tracks have no local file and are skipped:\n", len(lib.Skipped))
+		for _, name := range lib.Skipped {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+	if dryRun {
+		return
+	}
+
+	added := c.manager.ImportSongs(lib.Songs)
+	for _, playlist := range lib.Playlists {
+		c.manager.ImportPlaylist(playlist.Name, playlist.Description, playlist.Songs)
+	}
+	fmt.Printf("\nImported %d new songs and %d playlists.\n", added, len(lib.Playlists))
+}
+
+func (c *CLI) exportTable() {
+	c.listPlaylists()
+	playlistID := c.readInput("\nEnter playlist ID, or leave empty for the whole library: ")
+
+	var rows []playlistio.TableRow
+	if playlistID == "" {
+		rows = c.manager.LibraryTable()
+	} else {
+		var err error
+		if rows, err = c.manager.PlaylistTable(playlistID); err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+	}
+
+	fmt.Printf("Columns: %s\n", strings.Join(playlistio.TableColumns, ", "))
+	columns, err := playlistio.ParseColumns(c.readInput(fmt.Sprintf("Columns to export [%s]: ", strings.Join(playlistio.DefaultTableColumns, ","))))
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	path := c.readInput("Save as (.csv or .tsv): ")
+	delimiter, err := playlistio.TableDelimiter(filepath.Ext(path))
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	err = playlistio.WriteTable(file, rows, columns, delimiter)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		fmt.Printf("Export failed: %v\n", err)
+		return
+	}
+	fmt.Printf("Exported %d rows to %s\n", len(rows), path)
+}
+
+func (c *CLI) importTable() {
+	path := c.readInput("\nTable to import (.csv or .tsv, with an id column): ")
+	delimiter, err := playlistio.TableDelimiter(filepath.Ext(path))
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	edits, rowErrors, err := playlistio.ReadTable(file, delimiter)
+	file.Close()
+	if err != nil {
+		fmt.Printf("Import failed: %v\n", err)
+		return
+	}
+
+	valid, unknown := c.manager.ApplySongEdits(edits, true)
+	rowErrors = append(rowErrors, unknown...)
+	if len(rowErrors) > 0 {
+		sort.Slice(rowErrors, func(i, j int) bool { return rowErrors[i].Line < rowErrors[j].Line })
+		fmt.Printf("\n%d rows have problems and will be skipped:\n", len(rowErrors))
+		for _, rowErr := range rowErrors {
+			fmt.Printf("  %v\n", rowErr)
+		}
+	}
+	if valid == 0 {
+		fmt.Println("No songs to update.")
+		return
+	}
+	if len(rowErrors) > 0 && strings.ToLower(c.readInput(fmt.Sprintf("Update the other %d songs? (y/n): ", valid))) != "y" {
+		fmt.Println("Import cancelled.")
+		return
+	}
+
+	updated, _ := c.manager.ApplySongEdits(edits, false)
+	fmt.Printf("Updated %d songs.\n", updated)
+}
+
+func (c *CLI) exportBundle() {
+	playlists := c.manager.ListPlaylists()
+	if len(playlists) == 0 {
+		fmt.Println("\nNo playlists found.")
+		return
+	}
+
+	c.listPlaylists()
+	playlist, err := c.manager.GetPlaylist(c.readInput("\nEnter playlist ID: "))
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	target := c.readInput("Copy to (a folder, such as the device, or a .zip file): ")
+	if target == "" {
+		fmt.Println("Target cannot be empty.")
+		return
+	}
+	opts := playlistio.BundleOptions{
+		Template: keepIfEmpty(c.readInput(fmt.Sprintf("File names [%s]: ", playlistio.DefaultBundleTemplate)), playlistio.DefaultBundleTemplate),
+	}
+	budget, limited, err := c.readOptionalInt("Stop after how many MB? (empty for no limit): ")
+	if err != nil {
+		fmt.Println("Invalid size.")
+		return
+	}
+	if limited {
+		opts.MaxBytes = int64(budget) << 20
+	}
+	if !strings.EqualFold(filepath.Ext(target), ".zip") {
+		opts.Hardlink = strings.ToLower(c.readInput("Hardlink instead of copying where possible? (y/n): ")) == "y"
+	}
+	opts.Progress = func(p playlistio.BundleProgress) {
+		if p.File == "" {
+			fmt.Printf("[%d/%d] skipped %s\n", p.Done, p.Total, p.Song.FilePath)
+			return
+		}
+		fmt.Printf("[%d/%d] %s (%.1f MB so far)\n", p.Done, p.Total, p.File, float64(p.Bytes)/(1<<20))
+	}
+
+	result, err := playlistio.ExportBundle(playlist, target, opts)
+	if err != nil {
+		fmt.Printf("Export failed: %v\n", err)
+		return
+	}
+
+	fmt.Printf("\nCopied %d songs (%.1f MB) with playlist %s\n", len(result.Files), float64(result.Bytes)/(1<<20), result.PlaylistFile)
+	if result.Left > 0 {
+		fmt.Printf("%d songs did not fit in %d MB.\n", result.Left, budget)
+	}
+	for _, skipped := range result.Skipped {
+		fmt.Printf("Song %d could not be copied: %s\n", skipped.Line, skipped.Reason)
+	}
+}
+
+func (c *CLI) restoreBackup() {
+	backups, err := c.manager.ListBackups()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	if len(backups) == 0 {
+		fmt.Println("\nNo backups yet.")
+		return
+	}
+
+	fmt.Println("\nBACKUPS:")
+	for i, backup := range backups {
+		fmt.Printf("%d. %s (%.1f KB)\n", i+1, backup.CreatedAt.Local().Format("2006-01-02 15:04:05"), float64(backup.Size)/1024)
+	}
+
+	choice, err := strconv.Atoi(c.readInput("\nBackup to restore (number): "))
+	if err != nil || choice < 1 || choice > len(backups) {
+		fmt.Println("Invalid choice.")
+		return
+	}
+	name := backups[choice-1].Name
+
+	diff, err := c.manager.DiffBackup(name)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	fmt.Printf("\nRestoring would bring back %d playlists and %d songs, remove %d playlists and %d songs, and change %d playlists.\n",
+		len(diff.PlaylistsAdded), len(diff.SongsAdded), len(diff.PlaylistsRemoved), len(diff.SongsRemoved), len(diff.PlaylistsChanged))
+	printNames("Playlists brought back", diff.PlaylistsAdded)
+	printNames("Playlists removed", diff.PlaylistsRemoved)
+	printNames("Playlists changed", diff.PlaylistsChanged)
+
+	if strings.ToLower(c.readInput("Restore this backup? The current collection is backed up first. (y/n): ")) != "y" {
+		fmt.Println("Restore cancelled.")
+		return
+	}
+	if err := c.manager.RestoreBackup(name); err != nil {
+		fmt.Printf("Restore failed: %v\n", err)
+		return
+	}
+	fmt.Println("Backup restored.")
+}
+
+func (c *CLI) showHistory() {
+	limit := 20
+	if input := c.readInput("\nHow many changes to show (Enter for 20, 0 for all): "); input != "" {
+		n, err := strconv.Atoi(input)
+		if err != nil || n < 0 {
+			fmt.Println("Invalid number.")
+			return
+		}
+		limit = n
+	}
+
+	entries, err := c.manager.History(limit)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	if len(entries) == 0 {
+		fmt.Println("\nNo changes recorded yet.")
+		return
+	}
+
+	fmt.Println("\nCHANGES, NEWEST FIRST:")
+	for _, entry := range entries {
+		fmt.Println(entry.String())
+	}
+}
+
+func (c *CLI) undo() {
+	description, err := c.manager.Undo()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	fmt.Printf("Undid %s.\n", description)
+}
+
+func (c *CLI) redo() {
+	description, err := c.manager.Redo()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	fmt.Printf("Redid %s.\n", description)
+}
+
+func (c *CLI) playlistRevisions() {
+	c.listPlaylists()
+	playlistID := c.readInput("\nEnter playlist ID (a deleted playlist's works too): ")
+
+	revisions, err := c.manager.Revisions(playlistID)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+	if len(revisions) == 0 {
+		fmt.Println("\nNo revisions recorded yet.")
+		return
+	}
+
+	fmt.Println("\nREVISIONS:")
+	for _, revision := range revisions {
+		name := ""
+		if revision.Name != "" {
+			name = fmt.Sprintf(" %q", revision.Name)
+		}
+		fmt.Printf("%d.%s %s by %s: %s\n", revision.Number, name,
+			revision.Time.Local().Format("2006-01-02 15:04:05"), revision.Author, revision.Summary)
+	}
+
+	fmt.Println("\n1. Compare two revisions")
+	fmt.Println("2. Restore a revision")
+	fmt.Println("3. Restore a revision as a new playlist")
+	fmt.Println("4. Name a revision")
+	choice := c.readInput("Enter your choice (Enter to go back): ")
+	if choice == "" {
+		return
+	}
+
+	number, err := strconv.Atoi(c.readInput("Revision number: "))
+	if err != nil {
+		fmt.Println("Invalid number.")
+		return
+	}
+
+	switch choice {
+	case "1":
+		to, err := strconv.Atoi(c.readInput("Compare with revision number: "))
+		if err != nil {
+			fmt.Println("Invalid number.")
+			return
+		}
+		diff, err := c.manager.DiffRevisions(playlistID, number, to)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		fmt.Printf("\nFrom revision %d to %d: %s\n", diff.From, diff.To, diff)
+		for _, song := range diff.Added {
+			fmt.Printf("  + %s\n", song.ToString())
+		}
+		for _, song := range diff.Removed {
+			fmt.Printf("  - %s\n", song.ToString())
+		}
+		for _, move := range diff.Moved {
+			fmt.Printf("  ~ %s (%d -> %d)\n", move.Song.ToString(), move.From+1, move.To+1)
+		}
+	case "2", "3":
+		playlist, err := c.manager.RestoreRevision(playlistID, number, choice == "3")
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		fmt.Printf("Restored revision %d as %s.\n", number, playlist.ToString())
+	case "4":
+		name := c.readInput("Name: ")
+		if err := c.manager.NameRevision(playlistID, number, name); err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		fmt.Println("Revision named.")
+	default:
+		fmt.Println("Invalid Option")
+	}
+}
+
+// refresh takes in what the web server or another CLI saved since the menu
+// was last shown.
+func (c *CLI) refresh() {
+	err := c.manager.Refresh()
+	var conflict *manager.ConflictError
+	switch {
+	case errors.As(err, &conflict):
+		if err.Error() != c.lastConflict {
+			c.lastConflict = err.Error()
+			fmt.Printf("Note: %v\nYou'll be asked which changes to keep when saving.\n\n", err)
+		}
+	case err != nil:
+		fmt.Printf("Could not take in changes saved elsewhere: %v\n\n", err)
+	}
+}
+
+func (c *CLI) exit() {
+	fmt.Println("\nSaving data...")
+	err := c.manager.Save()
+	var conflict *manager.ConflictError
+	if errors.As(err, &conflict) {
+		fmt.Printf("Another program saved changes to the same data while you worked:\n")
+		printNames("Playlists", conflict.Playlists)
+		printNames("Songs", conflict.Songs)
+		printNames("Folders", conflict.ScanRoots)
+
+		switch strings.ToLower(c.readInput("Keep (m)ine, keep (t)heirs, or (d)iscard all your unsaved changes? ")) {
+		case "m":
+			err = c.manager.SaveResolving(manager.KeepMine)
+		case "t":
+			err = c.manager.SaveResolving(manager.KeepTheirs)
+		default:
+			fmt.Println("Your unsaved changes were discarded.")
+			fmt.Println("Exiting.")
+			return
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error saving data: %v\n", err)
+	} else if err := c.manager.Compact(); err != nil {
+		fmt.Printf("Could not fold the journal into the saved data: %v\n", err)
+	}
+	fmt.Println("Exiting.")
+}
+
+func printNames(label string, names []string) {
+	if len(names) > 0 {
+		fmt.Printf("  %s: %s\n", label, strings.Join(names, ", "))
+	}
+}
+
+func keepIfEmpty(value, current string) string {
+	if value == "" {
+		return current
+	}
+	return value
 }
 
 func durationToString(d time.Duration) string {
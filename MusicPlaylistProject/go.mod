@@ -4,4 +4,8 @@ go 1.23.0
 
 require (
 	github.com/dhowden/tag v0.0.0-20240417053706-3d75831295e8
+	go.etcd.io/bbolt v1.4.0
+	// Imported by the Windows file lock
+	golang.org/x/sys v0.29.0
+	golang.org/x/text v0.28.0
 )
@@ -1,30 +1,104 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"musicplaylist/cli"
 	"musicplaylist/manager"
+	"musicplaylist/scanner"
 	"musicplaylist/storage"
 	"musicplaylist/web"
 	"os"
+	"os/user"
+	"path/filepath"
+	"time"
 )
 
 const dataFile = "playlists.json"
 const port = ":8080"
+const storageRefresh = 2 * time.Second
+
+var (
+	webMode = flag.Bool("web", false, "serve the web interface instead of the CLI")
+	backend = flag.String("storage", "json", "where the collection is kept: json or bolt")
+	dbFile  = flag.String("db", "playlists.db", "database file used with -storage=bolt")
+	migrate = flag.Bool("migrate", false, "copy "+dataFile+" and its library into the -db database, then exit")
+)
 
 func main() {
-	store := storage.NewJSONStorage(dataFile)
+	flag.Parse()
+
+	if *migrate {
+		if err := migrateToBolt(); err != nil {
+			fmt.Printf("Error migrating: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	var inner storage.Storage
+	dataPath := dataFile
+	switch *backend {
+	case "json":
+		js := storage.NewJSONStorage(dataFile)
+		js.Warn = warn
+		inner = js
+	case "bolt":
+		db, err := storage.OpenBoltStorage(*dbFile)
+		if err != nil {
+			fmt.Printf("Error opening database: %v\n", err)
+			os.Exit(1)
+		}
+		defer db.Close()
+		inner = db
+		dataPath = *dbFile
+	default:
+		fmt.Printf("Unknown storage %q, expected json or bolt\n", *backend)
+		os.Exit(2)
+	}
+
+	mode := "cli"
+	if *webMode {
+		mode = "web"
+	}
+	// Changes are journaled as they are made, and folded into the data file
+	// on exit or once the journal grows
+	journal := storage.NewJournalStorage(inner, dataPath+".journal", actor(mode))
+	journal.Warn = warn
+	store := storage.NewBackupStorage(
+		journal,
+		filepath.Join(filepath.Dir(dataPath), "backups"),
+		storage.DefaultBackupPolicy,
+	)
+	store.Warn = warn
 
 	mgr := manager.CreatePlaylistManager(store)
+	mgr.Warn = warn
 
 	fmt.Println("Loading playlists...")
 	err := mgr.Load()
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		// Carrying on would save an empty collection over the one stored
+		fmt.Printf("Error loading playlists: %v\n", err)
+		os.Exit(1)
+	}
 	if err != nil {
-		fmt.Printf("Warning: Could not load playlists: %v\n", err)
 		fmt.Println("Starting with empty playlist collection.")
 	}
 
-	if len(os.Args) > 1 && os.Args[1] == "-web" {
+	if *webMode {
+		// Keep playlists bound to scanned folders in sync while serving
+		watcher, err := scanner.NewWatcher(scanner.DefaultDebounce)
+		if err != nil {
+			fmt.Printf("Warning: Could not watch folders: %v\n", err)
+		} else {
+			mgr.WatchFolders(watcher)
+		}
+		// Take in what the CLI saves while the server runs
+		mgr.WatchStorage(storageRefresh)
+
 		server := web.CreateServer(mgr, port)
 
 		fmt.Println("Starting Web Server")
@@ -41,6 +115,56 @@ func main() {
 		app := cli.CreateCLI(mgr)
 		app.Run()
 	}
+}
+
+// migrateToBolt copies the JSON files into the database. A database that
+// already holds playlists is left alone rather than overwritten.
+func migrateToBolt() error {
+	if _, err := os.Stat(dataFile); err != nil {
+		return fmt.Errorf("nothing to migrate: %w", err)
+	}
 
-	os.Exit(0)
+	db, err := storage.OpenBoltStorage(*dbFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	existing, err := db.LoadPlaylists()
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("%s already holds %d playlists", *dbFile, len(existing))
+	}
+
+	// Read through the journal, so changes not yet folded in come along
+	js := storage.NewJSONStorage(dataFile)
+	js.Warn = warn
+	source := storage.NewJournalStorage(js, dataFile+".journal", actor("migrate"))
+	if err := storage.Copy(db, source); err != nil {
+		return err
+	}
+
+	playlists, _ := db.LoadPlaylists()
+	songs, _ := db.LoadLibrary()
+	fmt.Printf("Copied %d playlists and %d songs from %s into %s\n", len(playlists), len(songs), dataFile, *dbFile)
+	fmt.Printf("Run with -storage=bolt to use it.\n")
+	return nil
+}
+
+// warn prints problems the manager and storage run into along the way, which
+// don't stop what they were doing.
+func warn(err error) {
+	fmt.Printf("Warning: %v\n", err)
+}
+
+// actor is who the journal puts changes down to: the user running the
+// program, and how.
+func actor(mode string) string {
+	name := "unknown"
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	return name + " (" + mode + ")"
 }
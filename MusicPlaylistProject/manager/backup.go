@@ -0,0 +1,163 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"musicplaylist/models"
+	"musicplaylist/storage"
+	"slices"
+)
+
+var errNoBackups = errors.New("backups are not enabled")
+
+// BackupDiff is what restoring a backup would change. Added are the
+// playlists and songs the backup has that the collection doesn't, Removed
+// the other way round.
+type BackupDiff struct {
+	PlaylistsAdded   []string       `json:"playlistsAdded"`
+	PlaylistsRemoved []string       `json:"playlistsRemoved"`
+	PlaylistsChanged []string       `json:"playlistsChanged"`
+	SongsAdded       []*models.Song `json:"songsAdded"`
+	SongsRemoved     []*models.Song `json:"songsRemoved"`
+}
+
+func (d *BackupDiff) String() string {
+	return fmt.Sprintf("%d playlists added, %d removed, %d changed; %d songs added, %d removed",
+		len(d.PlaylistsAdded), len(d.PlaylistsRemoved), len(d.PlaylistsChanged),
+		len(d.SongsAdded), len(d.SongsRemoved))
+}
+
+func (pm *PlaylistManager) backups() (storage.BackupStore, error) {
+	store, ok := pm.storage.(storage.BackupStore)
+	if !ok {
+		return nil, errNoBackups
+	}
+	return store, nil
+}
+
+// ListBackups lists the saved snapshots, newest first.
+func (pm *PlaylistManager) ListBackups() ([]storage.BackupInfo, error) {
+	store, err := pm.backups()
+	if err != nil {
+		return nil, err
+	}
+	return store.ListBackups()
+}
+
+// DiffBackup compares a backup with the current collection.
+func (pm *PlaylistManager) DiffBackup(name string) (*BackupDiff, error) {
+	store, err := pm.backups()
+	if err != nil {
+		return nil, err
+	}
+	snap, err := store.ReadBackup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	diff := &BackupDiff{
+		PlaylistsAdded:   make([]string, 0),
+		PlaylistsRemoved: make([]string, 0),
+		PlaylistsChanged: make([]string, 0),
+	}
+
+	current := make(map[string]*models.Playlist, len(pm.playlists))
+	for _, playlist := range pm.playlists {
+		current[playlist.ID] = playlist
+	}
+	backedUp := make(map[string]bool, len(snap.Playlists))
+	for _, playlist := range snap.Playlists {
+		backedUp[playlist.ID] = true
+		now, ok := current[playlist.ID]
+		switch {
+		case !ok:
+			diff.PlaylistsAdded = append(diff.PlaylistsAdded, playlist.Name)
+		case playlist.Name != now.Name || (!playlist.IsSmart() && !sameSongs(playlist.Songs, now.Songs)):
+			diff.PlaylistsChanged = append(diff.PlaylistsChanged, playlist.Name)
+		}
+	}
+	for _, playlist := range pm.playlists {
+		if !backedUp[playlist.ID] {
+			diff.PlaylistsRemoved = append(diff.PlaylistsRemoved, playlist.Name)
+		}
+	}
+
+	// Older backups keep some songs only inside their playlists
+	backupSongs := make(map[string]bool)
+	diff.SongsAdded = make([]*models.Song, 0)
+	addSong := func(song *models.Song) {
+		if backupSongs[song.ID] {
+			return
+		}
+		backupSongs[song.ID] = true
+		if pm.library.Get(song.ID) == nil {
+			diff.SongsAdded = append(diff.SongsAdded, song)
+		}
+	}
+	for _, song := range snap.Library {
+		addSong(song)
+	}
+	for _, playlist := range snap.Playlists {
+		for _, song := range playlist.Songs {
+			addSong(song)
+		}
+	}
+
+	diff.SongsRemoved = make([]*models.Song, 0)
+	for _, song := range pm.library.Songs() {
+		if !backupSongs[song.ID] {
+			diff.SongsRemoved = append(diff.SongsRemoved, song)
+		}
+	}
+	return diff, nil
+}
+
+func sameSongs(a, b []*models.Song) bool {
+	return slices.EqualFunc(a, b, func(x, y *models.Song) bool {
+		return x.ID == y.ID
+	})
+}
+
+// RestoreBackup replaces the collection with a backup and saves it. The
+// collection being replaced is backed up first, so a restore can be undone
+// by restoring again.
+func (pm *PlaylistManager) RestoreBackup(name string) error {
+	store, err := pm.backups()
+	if err != nil {
+		return err
+	}
+	snap, err := store.ReadBackup(name)
+	if err != nil {
+		return err
+	}
+	if snap.Library == nil {
+		snap.Library = make([]*models.Song, 0)
+	}
+	if snap.ScanRoots == nil {
+		snap.ScanRoots = make([]*models.ScanRoot, 0)
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	unlock, err := pm.lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := pm.save(); err != nil {
+		return err
+	}
+	if err := store.Backup(); err != nil {
+		return fmt.Errorf("failed to back up before restoring: %w", err)
+	}
+
+	watched := pm.watchedRoots()
+	pm.install(snap.Playlists, snap.Library, snap.ScanRoots)
+	pm.updateWatches(watched)
+	return pm.save()
+}
@@ -0,0 +1,331 @@
+package manager
+
+import (
+	"errors"
+	"musicplaylist/models"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+type DuplicateKind string
+
+const (
+	DuplicateAudio    DuplicateKind = "audio"
+	DuplicatePath     DuplicateKind = "path"
+	DuplicateMetadata DuplicateKind = "metadata"
+)
+
+// DuplicateDurationTolerance is how far apart two durations may be for songs
+// with the same artist and title to still count as the same track.
+const DuplicateDurationTolerance = 2 * time.Second
+
+var AllDuplicateKinds = []DuplicateKind{DuplicateAudio, DuplicatePath, DuplicateMetadata}
+
+// DuplicateGroup is a set of songs that look like the same track. Positions
+// are only filled in when the search was scoped to a playlist.
+type DuplicateGroup struct {
+	Kind      DuplicateKind  `json:"kind"`
+	Songs     []*models.Song `json:"songs"`
+	Positions []int          `json:"positions,omitempty"`
+}
+
+type songEntry struct {
+	song     *models.Song
+	position int
+}
+
+// FindDuplicates reports duplicate groups within a playlist, or across the
+// whole library when playlistID is empty.
+func (pm *PlaylistManager) FindDuplicates(playlistID string) ([]DuplicateGroup, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	entries, err := pm.duplicateScope(playlistID)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := findDuplicateGroups(entries, AllDuplicateKinds)
+	if playlistID == "" {
+		for i := range groups {
+			groups[i].Positions = nil
+		}
+	}
+	return groups, nil
+}
+
+// DedupePlaylist keeps the first entry of every duplicate group of the given
+// kinds and removes the rest from the playlist. It returns how many entries
+// were removed.
+func (pm *PlaylistManager) DedupePlaylist(playlistID string, kinds []DuplicateKind) (int, error) {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	playlist, err := pm.findEditablePlaylist(playlistID)
+	if err != nil {
+		return 0, err
+	}
+	entries, err := pm.duplicateScope(playlistID)
+	if err != nil {
+		return 0, err
+	}
+
+	drop := make(map[int]bool)
+	for _, group := range findDuplicateGroups(entries, kinds) {
+		for _, position := range group.Positions[1:] {
+			drop[position] = true
+		}
+	}
+	if len(drop) == 0 {
+		return 0, nil
+	}
+
+	pm.touchPlaylist(playlist.ID)
+	kept := make([]*models.Song, 0, len(playlist.Songs)-len(drop))
+	for i, song := range playlist.Songs {
+		if !drop[i] {
+			kept = append(kept, song)
+		}
+	}
+	playlist.Songs = kept
+	playlist.UpdatedAt = time.Now()
+	return len(drop), nil
+}
+
+// DedupeLibrary merges every duplicate group of the given kinds into its
+// oldest library entry. Playlists are pointed at the kept song, and an entry
+// is dropped if the playlist already held the kept song. It returns how many
+// songs were removed from the library.
+func (pm *PlaylistManager) DedupeLibrary(kinds []DuplicateKind) (int, error) {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	entries, _ := pm.duplicateScope("")
+	replacement := make(map[string]*models.Song)
+	resolve := func(song *models.Song) *models.Song {
+		for {
+			next, ok := replacement[song.ID]
+			if !ok {
+				return song
+			}
+			song = next
+		}
+	}
+
+	for _, group := range findDuplicateGroups(entries, kinds) {
+		keep := resolve(group.Songs[0])
+		for _, song := range group.Songs[1:] {
+			if merged := resolve(song); merged != keep {
+				replacement[merged.ID] = keep
+			}
+		}
+	}
+	if len(replacement) == 0 {
+		return 0, nil
+	}
+
+	for _, playlist := range pm.playlists {
+		present := make(map[string]bool)
+		for _, song := range playlist.Songs {
+			present[song.ID] = true
+		}
+
+		songs := make([]*models.Song, 0, len(playlist.Songs))
+		changed := false
+		for _, song := range playlist.Songs {
+			target := resolve(song)
+			if target != song {
+				changed = true
+				if present[target.ID] {
+					continue
+				}
+				present[target.ID] = true
+			}
+			songs = append(songs, target)
+		}
+		if changed {
+			pm.touchPlaylist(playlist.ID)
+			playlist.Songs = songs
+			playlist.UpdatedAt = time.Now()
+		}
+	}
+
+	for id := range replacement {
+		pm.library.Remove(id)
+	}
+	pm.refreshSmartPlaylists()
+	return len(replacement), nil
+}
+
+func (pm *PlaylistManager) duplicateScope(playlistID string) ([]songEntry, error) {
+	var songs []*models.Song
+	if playlistID == "" {
+		songs = pm.library.Songs()
+	} else {
+		playlist := pm.findPlaylist(playlistID)
+		if playlist == nil {
+			return nil, errors.New("playlist not found")
+		}
+		songs = playlist.Songs
+	}
+
+	entries := make([]songEntry, len(songs))
+	for i, song := range songs {
+		entries[i] = songEntry{song: song, position: i}
+	}
+	return entries, nil
+}
+
+func findDuplicateGroups(entries []songEntry, kinds []DuplicateKind) []DuplicateGroup {
+	var groups []DuplicateGroup
+
+	for _, kind := range kinds {
+		var clusters [][]songEntry
+		switch kind {
+		case DuplicateAudio:
+			clusters = groupEntries(entries, func(song *models.Song) string {
+				return song.ContentHash
+			})
+		case DuplicatePath:
+			clusters = groupEntries(entries, normalizedPath)
+		case DuplicateMetadata:
+			for _, cluster := range groupEntries(entries, normalizedArtistTitle) {
+				clusters = append(clusters, splitByDuration(cluster)...)
+			}
+		}
+
+		for _, cluster := range clusters {
+			// Audio and metadata matches only mean something between different
+			// songs, a song repeated in a playlist is a path duplicate
+			if kind != DuplicatePath && distinctSongs(cluster) < 2 {
+				continue
+			}
+			group := DuplicateGroup{Kind: kind}
+			for _, entry := range cluster {
+				group.Songs = append(group.Songs, entry.song)
+				group.Positions = append(group.Positions, entry.position)
+			}
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
+
+// groupEntries buckets entries by key, skipping empty keys, and keeps the
+// buckets with more than one entry in order of first appearance.
+func groupEntries(entries []songEntry, key func(song *models.Song) string) [][]songEntry {
+	buckets := make(map[string][]songEntry)
+	var order []string
+
+	for _, entry := range entries {
+		k := key(entry.song)
+		if k == "" {
+			continue
+		}
+		if _, ok := buckets[k]; !ok {
+			order = append(order, k)
+		}
+		buckets[k] = append(buckets[k], entry)
+	}
+
+	var clusters [][]songEntry
+	for _, k := range order {
+		if len(buckets[k]) > 1 {
+			clusters = append(clusters, buckets[k])
+		}
+	}
+	return clusters
+}
+
+// splitByDuration breaks a cluster into runs whose neighbouring durations are
+// within DuplicateDurationTolerance of each other.
+func splitByDuration(cluster []songEntry) [][]songEntry {
+	sorted := make([]songEntry, len(cluster))
+	copy(sorted, cluster)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].song.Duration < sorted[j].song.Duration
+	})
+
+	var runs [][]songEntry
+	run := []songEntry{sorted[0]}
+	for _, entry := range sorted[1:] {
+		if entry.song.Duration-run[len(run)-1].song.Duration > DuplicateDurationTolerance {
+			if len(run) > 1 {
+				runs = append(runs, run)
+			}
+			run = nil
+		}
+		run = append(run, entry)
+	}
+	if len(run) > 1 {
+		runs = append(runs, run)
+	}
+
+	// Put each run back in playlist order so the first entry is the one kept
+	for _, run := range runs {
+		sort.SliceStable(run, func(i, j int) bool {
+			return run[i].position < run[j].position
+		})
+	}
+	return runs
+}
+
+func distinctSongs(cluster []songEntry) int {
+	ids := make(map[string]bool)
+	for _, entry := range cluster {
+		ids[entry.song.ID] = true
+	}
+	return len(ids)
+}
+
+// caseInsensitivePaths is whether paths differing only in case name the same
+// file, as they do on the default Windows and macOS filesystems.
+var caseInsensitivePaths = runtime.GOOS == "windows" || runtime.GOOS == "darwin"
+
+func normalizedPath(song *models.Song) string {
+	if song.FilePath == "" {
+		return ""
+	}
+	path := song.FilePath
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	path = filepath.Clean(path)
+	if caseInsensitivePaths {
+		path = strings.ToLower(path)
+	}
+	return path
+}
+
+func normalizedArtistTitle(song *models.Song) string {
+	artist := normalizeText(song.Artist)
+	title := normalizeText(song.Title)
+	if title == "" {
+		return ""
+	}
+	return artist + "\x00" + title
+}
+
+// normalizeText lowercases s, drops punctuation and collapses whitespace.
+func normalizeText(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			b.WriteRune(r)
+			space = false
+		case unicode.IsSpace(r):
+			space = true
+		}
+	}
+	return b.String()
+}
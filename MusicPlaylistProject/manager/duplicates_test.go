@@ -0,0 +1,54 @@
+package manager
+
+import (
+	"fmt"
+	"musicplaylist/models"
+	"musicplaylist/scanner"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathDuplicatesFoldCaseOnlyWhereFilesystemsDo(t *testing.T) {
+	defer func(saved bool) { caseInsensitivePaths = saved }(caseInsensitivePaths)
+
+	entries := []songEntry{
+		{song: &models.Song{ID: "a", FilePath: "/m/Song.mp3"}, position: 0},
+		{song: &models.Song{ID: "b", FilePath: "/m/song.mp3"}, position: 1},
+	}
+
+	caseInsensitivePaths = false
+	if groups := findDuplicateGroups(entries, []DuplicateKind{DuplicatePath}); len(groups) != 0 {
+		t.Errorf("case-sensitive paths grouped as duplicates: %+v", groups)
+	}
+
+	caseInsensitivePaths = true
+	if groups := findDuplicateGroups(entries, []DuplicateKind{DuplicatePath}); len(groups) != 1 {
+		t.Errorf("got %d groups for case-insensitive paths, want 1", len(groups))
+	}
+}
+
+func TestTruncatedFilesAreNotAudioDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	var entries []songEntry
+	for i, body := range []string{"first tag", "second, longer tag"} {
+		// An ID3v2 tag with nothing after it, as left by a copy cut short
+		tag := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(body))}, body...)
+		path := filepath.Join(dir, fmt.Sprintf("%d.mp3", i))
+		if err := os.WriteFile(path, tag, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		song, err := scanner.ScanFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if song.ContentHash != "" {
+			t.Errorf("%s has no audio but hashed to %s", path, song.ContentHash)
+		}
+		entries = append(entries, songEntry{song: song, position: i})
+	}
+
+	if groups := findDuplicateGroups(entries, []DuplicateKind{DuplicateAudio}); len(groups) != 0 {
+		t.Errorf("truncated files grouped as audio duplicates: %+v", groups)
+	}
+}
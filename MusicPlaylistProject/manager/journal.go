@@ -0,0 +1,90 @@
+package manager
+
+import (
+	"fmt"
+	"musicplaylist/storage"
+	"slices"
+)
+
+func (pm *PlaylistManager) journal() (storage.JournaledStorage, bool) {
+	journaled, ok := pm.store.(storage.JournaledStorage)
+	return journaled, ok
+}
+
+// commit releases pm.mu after a change, which the mutators defer in place of
+// unlocking, and marks what the change touched for the next save. When the
+// storage keeps a journal the change is stored right away, so it isn't lost
+// if the process dies before saving; if that fails it stays for the next
+// Save to store, or to report why it can't.
+func (pm *PlaylistManager) commit() {
+	defer pm.mu.Unlock()
+
+	pm.markDirty()
+	if _, ok := pm.journal(); !ok || pm.stored == nil {
+		return
+	}
+	unlock, err := pm.lockStore()
+	if err == nil {
+		err = pm.save()
+		unlock()
+	}
+	if err != nil {
+		pm.warn(fmt.Errorf("could not record change: %w", err))
+	}
+}
+
+// ActAs runs fn with the changes it makes put down to actor in the journal,
+// such as a web request made for a client, rather than to the storage's own
+// actor. Calls take turns, and so do the changes the manager makes by itself,
+// so no change is put down to the wrong actor.
+func (pm *PlaylistManager) ActAs(actor string, fn func()) {
+	pm.actorMu.Lock()
+	defer pm.actorMu.Unlock()
+
+	pm.actor = actor
+	defer func() { pm.actor = "" }()
+	fn()
+}
+
+// Compact saves, then folds the journal into the stored collection.
+func (pm *PlaylistManager) Compact() error {
+	journal, ok := pm.journal()
+	if !ok {
+		return pm.Save()
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	unlock, err := pm.lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := pm.save(); err != nil {
+		return err
+	}
+	if err := journal.Compact(); err != nil {
+		return err
+	}
+	return pm.updateStamp()
+}
+
+// History lists the last limit changes recorded in the journal, newest
+// first, or all of them when limit is 0.
+func (pm *PlaylistManager) History(limit int) ([]storage.JournalEntry, error) {
+	journal, ok := pm.journal()
+	if !ok {
+		return make([]storage.JournalEntry, 0), nil
+	}
+	entries, err := journal.History()
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	slices.Reverse(entries)
+	return entries, nil
+}
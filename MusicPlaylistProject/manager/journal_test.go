@@ -0,0 +1,34 @@
+package manager
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestActAsPutsChangesDownToActor(t *testing.T) {
+	pm := CreatePlaylistManager(testStores["journal"](filepath.Join(t.TempDir(), "playlists.json")))
+	if err := pm.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	const client = "alice@192.0.2.1 (web)"
+	pm.ActAs(client, func() {
+		pm.CreatePlaylist("Theirs", "")
+	})
+	pm.CreatePlaylist("Ours", "")
+
+	entries, err := pm.History(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actors := make(map[string]string)
+	for _, entry := range entries {
+		actors[entry.Name] = entry.Actor
+	}
+	if actors["Theirs"] != client {
+		t.Errorf("change made acting as %q put down to %q", client, actors["Theirs"])
+	}
+	if actors["Ours"] != "test" {
+		t.Errorf("change made afterwards put down to %q, want the storage's actor", actors["Ours"])
+	}
+}
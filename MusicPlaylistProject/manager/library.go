@@ -0,0 +1,144 @@
+package manager
+
+import (
+	"musicplaylist/models"
+	"musicplaylist/search"
+	"path/filepath"
+	"time"
+)
+
+// Library owns every known song exactly once. Playlists hold pointers to the
+// library's songs, so an edit made through the library shows up everywhere.
+// It is not safe for concurrent use; the PlaylistManager guards it.
+type Library struct {
+	songs  []*models.Song
+	byID   map[string]*models.Song
+	byPath map[string]*models.Song
+	index  *search.Index
+	// touch, when set, is called with the ID of every song about to be
+	// added, changed or removed, while it still holds the song as it was
+	touch func(id string)
+}
+
+func NewLibrary() *Library {
+	return &Library{
+		songs:  make([]*models.Song, 0),
+		byID:   make(map[string]*models.Song),
+		byPath: make(map[string]*models.Song),
+		index:  search.NewIndex(),
+	}
+}
+
+// songKey is the identity of a song in the library: its cleaned absolute path,
+// or its ID when it has no file behind it.
+func songKey(song *models.Song) string {
+	if song.FilePath == "" {
+		return "id:" + song.ID
+	}
+	if abs, err := filepath.Abs(song.FilePath); err == nil {
+		return abs
+	}
+	return filepath.Clean(song.FilePath)
+}
+
+// Add stores song unless the library already has one with the same identity,
+// and returns the library's copy either way. New songs are stamped with the
+// time they were added.
+func (l *Library) Add(song *models.Song) *models.Song {
+	if existing, ok := l.byPath[songKey(song)]; ok {
+		return existing
+	}
+	if existing, ok := l.byID[song.ID]; ok {
+		return existing
+	}
+
+	if song.AddedAt.IsZero() {
+		song.AddedAt = time.Now()
+	}
+
+	l.touched(song.ID)
+	l.songs = append(l.songs, song)
+	l.byID[song.ID] = song
+	l.byPath[songKey(song)] = song
+	l.index.Add(song)
+	return song
+}
+
+func (l *Library) Get(id string) *models.Song {
+	return l.byID[id]
+}
+
+func (l *Library) GetByPath(path string) *models.Song {
+	return l.byPath[songKey(&models.Song{FilePath: path})]
+}
+
+func (l *Library) Remove(id string) bool {
+	song, ok := l.byID[id]
+	if !ok {
+		return false
+	}
+
+	l.touched(id)
+	delete(l.byID, id)
+	delete(l.byPath, songKey(song))
+	l.index.Remove(id)
+	for i, s := range l.songs {
+		if s == song {
+			l.songs = append(l.songs[:i], l.songs[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (l *Library) Songs() []*models.Song {
+	result := make([]*models.Song, len(l.songs))
+	copy(result, l.songs)
+	return result
+}
+
+func (l *Library) Len() int {
+	return len(l.songs)
+}
+
+// Update applies edit to the song with the given ID, keeping the ID, path and
+// search indexes in step with the edit. An edit may change the song's ID.
+func (l *Library) Update(id string, edit func(song *models.Song)) bool {
+	song, ok := l.byID[id]
+	if !ok {
+		return false
+	}
+
+	l.touched(id)
+	oldKey := songKey(song)
+	edit(song)
+	if newKey := songKey(song); newKey != oldKey {
+		delete(l.byPath, oldKey)
+		l.byPath[newKey] = song
+	}
+	if song.ID != id {
+		l.touched(song.ID)
+		delete(l.byID, id)
+		l.byID[song.ID] = song
+		l.index.Remove(id)
+		l.index.Add(song)
+		return true
+	}
+	l.index.Update(song)
+	return true
+}
+
+func (l *Library) touched(id string) {
+	if l.touch != nil {
+		l.touch(id)
+	}
+}
+
+// Search runs a parsed query against the library's index.
+func (l *Library) Search(q *search.Query) []search.Hit {
+	return l.index.Search(q)
+}
+
+func (l *Library) Complete(field, prefix string, limit int) []string {
+	return l.index.Complete(field, prefix, limit)
+}
@@ -0,0 +1,41 @@
+package manager
+
+import "musicplaylist/models"
+
+// migrateLegacyIDs swaps the old nanosecond timestamp IDs for path derived
+// song IDs and ULID playlist IDs. Every copy of an old ID is mapped to the
+// same new one, so the library and the playlists still agree afterwards.
+// It reports whether anything was rewritten.
+func migrateLegacyIDs(songs []*models.Song, playlists []*models.Playlist) bool {
+	songIDs := make(map[string]string)
+	migrated := false
+
+	migrateSong := func(song *models.Song) {
+		if !models.IsLegacyID(song.ID) {
+			return
+		}
+		newID, ok := songIDs[song.ID]
+		if !ok {
+			newID = models.SongIDForPath(song.FilePath)
+			songIDs[song.ID] = newID
+		}
+		song.ID = newID
+		migrated = true
+	}
+
+	for _, song := range songs {
+		migrateSong(song)
+	}
+
+	for _, playlist := range playlists {
+		for _, song := range playlist.Songs {
+			migrateSong(song)
+		}
+		if models.IsLegacyID(playlist.ID) {
+			playlist.ID = models.NewPlaylistID(playlist.CreatedAt)
+			migrated = true
+		}
+	}
+
+	return migrated
+}
@@ -0,0 +1,409 @@
+package manager
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"musicplaylist/models"
+	"musicplaylist/storage"
+	"slices"
+	"sort"
+)
+
+// storedState is what the manager last stored, so that a save only sends the
+// changes made since. Records are kept as hashes of their JSON; a playlist
+// also keeps its song IDs to work out how they moved.
+type storedState struct {
+	playlists map[string]storedPlaylist
+	songs     map[string][sha256.Size]byte
+	roots     map[string][sha256.Size]byte
+}
+
+type storedPlaylist struct {
+	sum     [sha256.Size]byte
+	songIDs []string
+}
+
+// dirtySet names the records changed since the last save, so a save only
+// looks at those. all means anything may have changed, as after a load.
+type dirtySet struct {
+	playlists map[string]bool
+	songs     map[string]bool
+	roots     map[string]bool
+	all       bool
+}
+
+// markDirty adds what the change just made touched to pm.dirty.
+func (pm *PlaylistManager) markDirty() {
+	if pm.pending == nil {
+		return
+	}
+	if pm.dirty.playlists == nil {
+		pm.dirty.playlists = make(map[string]bool)
+		pm.dirty.songs = make(map[string]bool)
+		pm.dirty.roots = make(map[string]bool)
+	}
+	for id := range pm.pending.playlists {
+		pm.dirty.playlists[id] = true
+	}
+	for id := range pm.pending.songs {
+		pm.dirty.songs[id] = true
+	}
+	for path := range pm.pending.roots {
+		pm.dirty.roots[path] = true
+	}
+	pm.pending = nil
+}
+
+// newStoredState records the collection as it stands.
+func newStoredState(playlists []*models.Playlist, songs []*models.Song, roots []*models.ScanRoot) (*storedState, error) {
+	state := &storedState{
+		playlists: make(map[string]storedPlaylist, len(playlists)),
+		songs:     make(map[string][sha256.Size]byte, len(songs)),
+		roots:     make(map[string][sha256.Size]byte, len(roots)),
+	}
+
+	for _, playlist := range playlists {
+		stored, err := newStoredPlaylist(playlist)
+		if err != nil {
+			return nil, err
+		}
+		state.playlists[playlist.ID] = stored
+	}
+	for _, song := range songs {
+		sum, err := jsonSum(song)
+		if err != nil {
+			return nil, err
+		}
+		state.songs[song.ID] = sum
+	}
+	for _, root := range roots {
+		sum, err := jsonSum(root)
+		if err != nil {
+			return nil, err
+		}
+		state.roots[root.Path] = sum
+	}
+	return state, nil
+}
+
+// newStoredPlaylist records a playlist. The songs of smart playlists aren't
+// stored, so they aren't recorded.
+func newStoredPlaylist(playlist *models.Playlist) (storedPlaylist, error) {
+	header := *playlist
+	header.Songs = nil
+	sum, err := jsonSum(&header)
+	if err != nil {
+		return storedPlaylist{}, err
+	}
+	stored := storedPlaylist{sum: sum}
+	if !playlist.IsSmart() {
+		stored.songIDs = make([]string, len(playlist.Songs))
+		for i, song := range playlist.Songs {
+			stored.songIDs[i] = song.ID
+		}
+	}
+	return stored, nil
+}
+
+func jsonSum(value any) ([sha256.Size]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("failed to marshal: %w", err)
+	}
+	return sha256.Sum256(data), nil
+}
+
+// save stores what changed since the last save in one batch, refusing to if
+// that would overwrite conflicting changes saved elsewhere. The caller holds
+// the storage lock.
+func (pm *PlaylistManager) save() error {
+	return pm.saveResolving(RefuseConflicts)
+}
+
+// saveResolving first merges in whatever another process saved since the
+// last load or save here. The first save of a manager that never loaded
+// rewrites the whole collection instead, as there is nothing to compare with,
+// but one whose load failed saves nothing.
+func (pm *PlaylistManager) saveResolving(resolution Resolution) error {
+	if pm.stored == nil && pm.loadErr != nil {
+		return fmt.Errorf("not saving over a collection that could not be loaded: %w", pm.loadErr)
+	}
+	if pm.stored != nil {
+		changed, err := pm.storeChanged()
+		if err != nil {
+			return err
+		}
+		if changed {
+			if err := pm.merge(resolution); err != nil {
+				return err
+			}
+		}
+	}
+
+	if pm.stored == nil {
+		songs := pm.library.Songs()
+		next, err := newStoredState(pm.playlists, songs, pm.roots)
+		if err != nil {
+			return err
+		}
+		if err := pm.saveAll(songs); err != nil {
+			return err
+		}
+		pm.stored = next
+		pm.dirty = dirtySet{}
+		return pm.updateStamp()
+	}
+
+	changes, update, err := pm.changes()
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		update()
+		pm.dirty = dirtySet{}
+		return nil
+	}
+
+	batch, err := pm.store.Begin()
+	if err != nil {
+		return err
+	}
+	if actorBatch, ok := batch.(storage.ActorBatch); ok && pm.actor != "" {
+		actorBatch.SetActor(pm.actor)
+	}
+	for _, change := range changes {
+		if err := change(batch); err != nil {
+			batch.Rollback()
+			return fmt.Errorf("failed to save changes: %w", err)
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		return fmt.Errorf("failed to save changes: %w", err)
+	}
+	update()
+	pm.dirty = dirtySet{}
+	return pm.updateStamp()
+}
+
+func (pm *PlaylistManager) updateStamp() error {
+	stamp, err := pm.storeStamp()
+	if err != nil {
+		return err
+	}
+	pm.stamp = stamp
+	return nil
+}
+
+func (pm *PlaylistManager) saveAll(songs []*models.Song) error {
+	if err := pm.store.SavePlaylists(pm.playlists); err != nil {
+		return err
+	}
+	if err := pm.store.SaveLibrary(songs); err != nil {
+		return err
+	}
+	return pm.store.SaveScanRoots(pm.roots)
+}
+
+type change func(batch storage.Batch) error
+
+// changes lists the batch operations that store what changed among the
+// dirty records, along with a function that brings pm.stored up to date once
+// they are committed. Songs are upserted before the playlists that hold them
+// and deleted after the playlists have let go of them.
+func (pm *PlaylistManager) changes() ([]change, func(), error) {
+	prev := pm.stored
+	all := pm.dirty.all
+	var updates []func()
+	var upserts, deletes []change
+
+	// Scanned folders
+	currentRoots := make(map[string]bool, len(pm.roots))
+	for _, root := range pm.roots {
+		currentRoots[root.Path] = true
+		if !all && !pm.dirty.roots[root.Path] {
+			continue
+		}
+		sum, err := jsonSum(root)
+		if err != nil {
+			return nil, nil, err
+		}
+		if stored, ok := prev.roots[root.Path]; ok && stored == sum {
+			continue
+		}
+		upserts = append(upserts, func(batch storage.Batch) error {
+			return batch.UpsertScanRoot(root)
+		})
+		updates = append(updates, func() { prev.roots[root.Path] = sum })
+	}
+	var deletedRoots []change
+	for _, path := range dirtyKeys(pm.dirty.roots, prev.roots, all) {
+		if _, ok := prev.roots[path]; !ok || currentRoots[path] {
+			continue
+		}
+		deletedRoots = append(deletedRoots, func(batch storage.Batch) error {
+			return batch.DeleteScanRoot(path)
+		})
+		updates = append(updates, func() { delete(prev.roots, path) })
+	}
+
+	// Songs
+	var songs []*models.Song
+	if all {
+		songs = pm.library.Songs()
+	} else {
+		for id := range pm.dirty.songs {
+			if song := pm.library.Get(id); song != nil {
+				songs = append(songs, song)
+			}
+		}
+		// New songs go to the end of the stored library, in the order they
+		// were added
+		sort.Slice(songs, func(i, j int) bool {
+			if !songs[i].AddedAt.Equal(songs[j].AddedAt) {
+				return songs[i].AddedAt.Before(songs[j].AddedAt)
+			}
+			return songs[i].ID < songs[j].ID
+		})
+	}
+	for _, song := range songs {
+		sum, err := jsonSum(song)
+		if err != nil {
+			return nil, nil, err
+		}
+		if stored, ok := prev.songs[song.ID]; ok && stored == sum {
+			continue
+		}
+		upserts = append(upserts, func(batch storage.Batch) error {
+			return batch.UpsertSong(song)
+		})
+		updates = append(updates, func() { prev.songs[song.ID] = sum })
+	}
+	var deletedSongs []change
+	for _, id := range dirtyKeys(pm.dirty.songs, prev.songs, all) {
+		if _, ok := prev.songs[id]; !ok || pm.library.Get(id) != nil {
+			continue
+		}
+		deletedSongs = append(deletedSongs, func(batch storage.Batch) error {
+			return batch.DeleteSong(id)
+		})
+		updates = append(updates, func() { delete(prev.songs, id) })
+	}
+
+	// Playlists, in order so new ones are stored in the same order
+	currentPlaylists := make(map[string]bool, len(pm.playlists))
+	for _, playlist := range pm.playlists {
+		currentPlaylists[playlist.ID] = true
+		if !all && !pm.dirty.playlists[playlist.ID] {
+			continue
+		}
+		after, err := newStoredPlaylist(playlist)
+		if err != nil {
+			return nil, nil, err
+		}
+		before, ok := prev.playlists[playlist.ID]
+		if ok && before.equal(after) {
+			continue
+		}
+		if !ok || before.sum != after.sum {
+			upserts = append(upserts, func(batch storage.Batch) error {
+				return batch.UpsertPlaylist(playlist)
+			})
+		}
+		upserts = append(upserts, songChanges(playlist.ID, before.songIDs, after.songIDs)...)
+		updates = append(updates, func() { prev.playlists[playlist.ID] = after })
+	}
+	for _, id := range dirtyKeys(pm.dirty.playlists, prev.playlists, all) {
+		if _, ok := prev.playlists[id]; !ok || currentPlaylists[id] {
+			continue
+		}
+		deletes = append(deletes, func(batch storage.Batch) error {
+			return batch.DeletePlaylist(id)
+		})
+		updates = append(updates, func() { delete(prev.playlists, id) })
+	}
+
+	deletes = append(deletes, deletedSongs...)
+	deletes = append(deletes, deletedRoots...)
+	update := func() {
+		for _, update := range updates {
+			update()
+		}
+	}
+	return append(upserts, deletes...), update, nil
+}
+
+// dirtyKeys returns the keys of dirty in order, or of stored when all is
+// set, where every stored record may have been deleted.
+func dirtyKeys[V any](dirty map[string]bool, stored map[string]V, all bool) []string {
+	keys := make([]string, 0, len(dirty))
+	if all {
+		for key := range stored {
+			keys = append(keys, key)
+		}
+	} else {
+		for key := range dirty {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// songChanges turns one list of song IDs into another. Only the part
+// between what the lists start and end with in common is touched, so adding
+// or removing songs in one place stays a single insert or remove.
+func songChanges(playlistID string, before, after []string) []change {
+	if slices.Equal(before, after) {
+		return nil
+	}
+
+	start := 0
+	for start < len(before) && start < len(after) && before[start] == after[start] {
+		start++
+	}
+	end := 0
+	for end < len(before)-start && end < len(after)-start &&
+		before[len(before)-1-end] == after[len(after)-1-end] {
+		end++
+	}
+	removed := len(before) - start - end
+	inserted := after[start : len(after)-end]
+
+	if removed > 0 && len(inserted) > 0 && sameMembers(before, after) {
+		return []change{func(batch storage.Batch) error {
+			return batch.ReorderSongs(playlistID, after)
+		}}
+	}
+
+	changes := make([]change, 0, 2)
+	if removed > 0 {
+		changes = append(changes, func(batch storage.Batch) error {
+			return batch.RemoveSongs(playlistID, start, removed)
+		})
+	}
+	if len(inserted) > 0 {
+		changes = append(changes, func(batch storage.Batch) error {
+			return batch.InsertSongs(playlistID, start, inserted)
+		})
+	}
+	return changes
+}
+
+// sameMembers reports whether two lists hold the same songs, in any order.
+func sameMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[string]int, len(a))
+	for _, id := range a {
+		counts[id]++
+	}
+	for _, id := range b {
+		if counts[id] == 0 {
+			return false
+		}
+		counts[id]--
+	}
+	return true
+}
@@ -0,0 +1,299 @@
+package manager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"musicplaylist/models"
+	"musicplaylist/storage"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// recordingStore notes the batch operations saves make.
+type recordingStore struct {
+	storage.IncrementalStorage
+	ops []string
+}
+
+func (rs *recordingStore) Begin() (storage.Batch, error) {
+	batch, err := rs.IncrementalStorage.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &recordingBatch{Batch: batch, store: rs}, nil
+}
+
+type recordingBatch struct {
+	storage.Batch
+	store *recordingStore
+}
+
+func (rb *recordingBatch) note(op string) { rb.store.ops = append(rb.store.ops, op) }
+
+func (rb *recordingBatch) UpsertPlaylist(playlist *models.Playlist) error {
+	rb.note("UpsertPlaylist")
+	return rb.Batch.UpsertPlaylist(playlist)
+}
+
+func (rb *recordingBatch) DeletePlaylist(id string) error {
+	rb.note("DeletePlaylist")
+	return rb.Batch.DeletePlaylist(id)
+}
+
+func (rb *recordingBatch) InsertSongs(playlistID string, index int, songIDs []string) error {
+	rb.note("InsertSongs")
+	return rb.Batch.InsertSongs(playlistID, index, songIDs)
+}
+
+func (rb *recordingBatch) RemoveSongs(playlistID string, index, count int) error {
+	rb.note("RemoveSongs")
+	return rb.Batch.RemoveSongs(playlistID, index, count)
+}
+
+func (rb *recordingBatch) ReorderSongs(playlistID string, songIDs []string) error {
+	rb.note("ReorderSongs")
+	return rb.Batch.ReorderSongs(playlistID, songIDs)
+}
+
+func (rb *recordingBatch) UpsertSong(song *models.Song) error {
+	rb.note("UpsertSong")
+	return rb.Batch.UpsertSong(song)
+}
+
+func (rb *recordingBatch) DeleteSong(id string) error {
+	rb.note("DeleteSong")
+	return rb.Batch.DeleteSong(id)
+}
+
+func testSongs(n int) []*models.Song {
+	songs := make([]*models.Song, n)
+	for i := range songs {
+		path := fmt.Sprintf("/music/%03d.mp3", i)
+		songs[i] = &models.Song{ID: models.SongIDForPath(path), Title: fmt.Sprintf("Song %d", i), FilePath: path}
+	}
+	return songs
+}
+
+func TestSaveStoresOnlyWhatChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "playlists.json")
+	store := &recordingStore{IncrementalStorage: storage.Incremental(storage.NewJSONStorage(path))}
+	pm := CreatePlaylistManager(store)
+	if err := pm.Load(); err != nil {
+		t.Fatal(err)
+	}
+	songs := testSongs(100)
+	playlist := pm.ImportPlaylist("Big", "", songs)
+	if err := pm.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name   string
+		change func() error
+		want   []string
+	}{
+		{"edit a song", func() error {
+			_, err := pm.UpdateSong(songs[10].ID, func(s *models.Song) { s.Title = "Renamed" })
+			return err
+		}, []string{"UpsertSong"}},
+		{"remove from playlist", func() error {
+			return pm.RemoveSongFromPlaylist(playlist.ID, songs[20].ID)
+		}, []string{"UpsertPlaylist", "RemoveSongs"}},
+		{"undo", func() error {
+			_, err := pm.Undo()
+			return err
+		}, []string{"UpsertPlaylist", "InsertSongs"}},
+		{"delete a song", func() error {
+			return pm.DeleteSong(songs[30].ID)
+		}, []string{"UpsertPlaylist", "RemoveSongs", "DeleteSong"}},
+		{"nothing", func() error { return nil }, nil},
+	}
+	for _, step := range steps {
+		store.ops = nil
+		if err := step.change(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if err := pm.Save(); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		if !slices.Equal(store.ops, step.want) {
+			t.Errorf("%s stored %v, want %v", step.name, store.ops, step.want)
+		}
+	}
+}
+
+// testStores open the kinds of storage the manager is tested against.
+var testStores = map[string]func(path string) storage.Storage{
+	"json": func(path string) storage.Storage { return storage.NewJSONStorage(path) },
+	// Each change is stored as it is made
+	"journal": func(path string) storage.Storage {
+		return storage.NewJournalStorage(storage.NewJSONStorage(path), path+".journal", "test")
+	},
+}
+
+func TestReloadSeesEveryChange(t *testing.T) {
+	for name, open := range testStores {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "playlists.json")
+			pm := CreatePlaylistManager(open(path))
+			if err := pm.Load(); err != nil {
+				t.Fatal(err)
+			}
+			for i, step := range collectionChanges(pm) {
+				if err := step(); err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if err := pm.Save(); err != nil {
+					t.Fatal(err)
+				}
+
+				reloaded := CreatePlaylistManager(open(path))
+				if err := reloaded.Load(); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := describeCollection(reloaded), describeCollection(pm); !slices.Equal(got, want) {
+					t.Errorf("after step %d, reloaded collection:\n%v\nwant:\n%v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+// collectionChanges lists one of most kinds of change, to be made in order.
+func collectionChanges(pm *PlaylistManager) []func() error {
+	songs := testSongs(10)
+	var first, second *models.Playlist
+	return []func() error{
+		func() error { first = pm.ImportPlaylist("First", "", songs[:6]); return nil },
+		func() error { second = pm.CreatePlaylist("Second", ""); return nil },
+		func() error { _, err := pm.AddSongsToPlaylist(second.ID, songs[4:]); return err },
+		func() error { _, err := pm.MoveSongs(first.ID, []int{0}, 5); return err },
+		func() error {
+			_, err := pm.UpdateSong(songs[1].ID, func(s *models.Song) { s.Artist = "Someone" })
+			return err
+		},
+		func() error { return pm.DeleteSong(songs[5].ID) },
+		func() error { _, err := pm.ShufflePlaylist(second.ID); return err },
+		func() error { _, err := pm.Undo(); return err },
+		func() error { _, err := pm.DedupeLibrary(AllDuplicateKinds); return err },
+		func() error {
+			third := pm.CreatePlaylist("Third", "")
+			return pm.DeletePlaylist(third.ID)
+		},
+	}
+}
+
+// describeCollection lists the playlists with their songs and then the
+// library, one line each.
+func describeCollection(pm *PlaylistManager) []string {
+	var lines []string
+	for _, playlist := range pm.ListPlaylists() {
+		line := playlist.Name + ":"
+		for _, song := range playlist.Songs {
+			line += " " + song.ID
+		}
+		lines = append(lines, line)
+	}
+	for _, song := range pm.ListLibrary() {
+		lines = append(lines, fmt.Sprintf("%s %s %q %q", song.ID, song.FilePath, song.Title, song.Artist))
+	}
+	return lines
+}
+
+func TestFailedLoadSavesNothing(t *testing.T) {
+	for name, open := range testStores {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "playlists.json")
+			// Written by a newer version of the program
+			refused := []byte(`{"version":9,"kind":"playlists","data":[]}`)
+			if err := os.WriteFile(path, refused, 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			pm := CreatePlaylistManager(open(path))
+			if err := pm.Load(); !errors.Is(err, storage.ErrUnsupportedVersion) {
+				t.Fatalf("Load() = %v, want %v", err, storage.ErrUnsupportedVersion)
+			}
+			pm.CreatePlaylist("New", "")
+			if err := pm.Save(); err == nil {
+				t.Error("Save() succeeded after a failed load")
+			}
+
+			if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, refused) {
+				t.Errorf("playlists file = %q, %v, want it left as %q", data, err, refused)
+			}
+			files, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, file := range files {
+				if file.Name() != "playlists.json" && !strings.HasSuffix(file.Name(), ".lock") {
+					t.Errorf("%s was written after a failed load", file.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestJournalThatFailsToReplaySavesNothing(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "playlists.json")
+	open := testStores["journal"]
+
+	pm := CreatePlaylistManager(open(path))
+	if err := pm.Load(); err != nil {
+		t.Fatal(err)
+	}
+	pm.CreatePlaylist("Mix", "")
+	if err := pm.Save(); err != nil {
+		t.Fatal(err)
+	}
+	// A committed change to a playlist that doesn't exist
+	journal, err := os.OpenFile(path+".journal", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = journal.WriteString(`{"op":"insertSongs","playlistId":"missing","songIds":["S1"],"after":["S1"]}` + "\n" +
+		`{"op":"commit","count":1}` + "\n")
+	journal.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before := readFiles(t, dir)
+
+	reloaded := CreatePlaylistManager(open(path))
+	if err := reloaded.Load(); err == nil {
+		t.Fatal("Load() succeeded with a journal that doesn't replay")
+	}
+	reloaded.CreatePlaylist("New", "")
+	if err := reloaded.Save(); err == nil {
+		t.Error("Save() succeeded after a failed load")
+	}
+	if after := readFiles(t, dir); !maps.Equal(after, before) {
+		t.Errorf("files changed after a failed load:\n%v\nwant:\n%v", slices.Sorted(maps.Keys(after)), slices.Sorted(maps.Keys(before)))
+	}
+}
+
+// readFiles returns the contents of the files in dir, by name.
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name()] = string(data)
+	}
+	return files
+}
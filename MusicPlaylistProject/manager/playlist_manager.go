@@ -4,7 +4,10 @@ package manager
 import (
 	"errors"
 	"fmt"
+	"io/fs"
 	"musicplaylist/models"
+	"musicplaylist/scanner"
+	"musicplaylist/search"
 	"musicplaylist/storage"
 	"strings"
 	"sync"
@@ -13,42 +16,162 @@ import (
 
 type PlaylistManager struct {
 	playlists []*models.Playlist
+	library   *Library
+	roots     []*models.ScanRoot
+	watcher   scanner.Watcher
 	storage   storage.Storage
+	store     storage.IncrementalStorage
+	// stored is what was last loaded or saved, nil until then, and stamp
+	// the storage's stamp at the time
+	stored *storedState
+	stamp  string
+	// loadErr is why the last Load failed. Until a load succeeds nothing is
+	// saved, so a collection that couldn't be read isn't written over.
+	loadErr error
+	// dirty is what changed since the last save, and pending what the
+	// change being made has touched so far, as it was before
+	dirty   dirtySet
+	pending *collectionState
+	// Changes that can be undone, and ones undone that can be redone, the
+	// latest last
+	undoStack []*command
+	redoStack []*command
 	mu        sync.RWMutex
+	// actor is who changes are put down to in the journal while ActAs runs,
+	// and actorMu is held meanwhile
+	actor   string
+	actorMu sync.Mutex
+
+	// Warn is told about problems that don't fail the call that ran into
+	// them, such as a folder that can't be watched. Set it before using the
+	// manager; when it's nil they are dropped.
+	Warn func(err error)
 }
 
 func CreatePlaylistManager(store storage.Storage) *PlaylistManager {
-	return &PlaylistManager{
+	pm := &PlaylistManager{
 		playlists: make([]*models.Playlist, 0),
+		library:   NewLibrary(),
+		roots:     make([]*models.ScanRoot, 0),
 		storage:   store,
+		store:     storage.Incremental(store),
 	}
+	pm.library.touch = pm.touchSong
+	return pm
 }
 
+func (pm *PlaylistManager) warn(err error) {
+	if pm.Warn != nil {
+		pm.Warn(err)
+	}
+}
+
+// Load replaces the collection with the stored one. If that fails for any
+// reason but there being nothing stored yet, saves fail too until a later
+// Load succeeds.
 func (pm *PlaylistManager) Load() error {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	playlists, err := pm.storage.LoadPlaylists()
+	err := pm.load()
+	pm.loadErr = nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		pm.loadErr = err
+	}
+	return err
+}
+
+func (pm *PlaylistManager) load() error {
+	unlock, err := pm.lockStore()
 	if err != nil {
 		return err
 	}
+	defer unlock()
 
-	pm.playlists = playlists
+	songs, err := pm.store.LoadLibrary()
+	if err != nil {
+		return err
+	}
+	roots, err := pm.store.LoadScanRoots()
+	if err != nil {
+		return err
+	}
+	playlists, err := pm.store.LoadPlaylists()
+	if err != nil {
+		return err
+	}
+	// Taken after loading, which may have upgraded the files
+	stamp, err := pm.storeStamp()
+	if err != nil {
+		return err
+	}
+
+	// Taken before install changes anything, so the next save stores what
+	// loading had to fix up
+	stored, err := newStoredState(playlists, songs, roots)
+	if err != nil {
+		return err
+	}
+	pm.stored = stored
+	pm.stamp = stamp
+
+	// Write the new IDs back right away so the migration only ever runs once
+	if pm.install(playlists, songs, roots) {
+		return pm.save()
+	}
 	return nil
 }
 
-func (pm *PlaylistManager) Save() error {
-	pm.mu.RLock()
-	defer pm.mu.RUnlock()
+// install replaces the collection with freshly loaded data and reports
+// whether song IDs had to be migrated. Nothing done before can be undone or
+// redone after.
+func (pm *PlaylistManager) install(playlists []*models.Playlist, songs []*models.Song, roots []*models.ScanRoot) bool {
+	migrated := migrateLegacyIDs(songs, playlists)
 
-	return pm.storage.SavePlaylists(pm.playlists)
+	// Playlists saved before the library existed carry their own song copies,
+	// fold them into the library so every playlist shares one record per file
+	library := NewLibrary()
+	for _, song := range songs {
+		library.Add(song)
+	}
+	for _, playlist := range playlists {
+		for i, song := range playlist.Songs {
+			// Older songs don't know when they were added, their playlist's
+			// creation is the closest guess
+			if song.AddedAt.IsZero() {
+				song.AddedAt = playlist.CreatedAt
+			}
+			playlist.Songs[i] = library.Add(song)
+		}
+	}
+
+	library.touch = pm.touchSong
+	pm.library = library
+	pm.playlists = playlists
+	pm.roots = roots
+	// Anything may differ from what was stored, so the next save compares
+	// the whole collection
+	pm.dirty.all = true
+	// What was done to the collection being replaced doesn't apply to this one
+	pm.undoStack, pm.redoStack = nil, nil
+	pm.refreshSmartPlaylists()
+	return migrated
+}
+
+// Save stores the changes made since the collection was last loaded or
+// saved. Changes another process saved meanwhile are merged in first; if
+// they touch the same things as the changes here, nothing is saved and a
+// *ConflictError says what clashed.
+func (pm *PlaylistManager) Save() error {
+	return pm.SaveResolving(RefuseConflicts)
 }
 
 func (pm *PlaylistManager) CreatePlaylist(name, description string) *models.Playlist {
 	pm.mu.Lock()
-	defer pm.mu.Unlock()
+	defer pm.commit()
 
 	playlist := models.NewPlaylist(name, description)
+	pm.touchPlaylist(playlist.ID)
 	pm.playlists = append(pm.playlists, playlist)
 	return playlist
 }
@@ -67,17 +190,188 @@ func (pm *PlaylistManager) GetPlaylist(id string) (*models.Playlist, error) {
 
 func (pm *PlaylistManager) DeletePlaylist(id string) error {
 	pm.mu.Lock()
-	defer pm.mu.Unlock()
+	defer pm.commit()
 
 	for i, playlist := range pm.playlists {
 		if playlist.ID == id {
+			pm.touchPlaylist(id)
 			pm.playlists = append(pm.playlists[:i], pm.playlists[i+1:]...)
+			pm.record(fmt.Sprintf("deleting playlist %q", playlist.Name))
 			return nil
 		}
 	}
 	return errors.New("playlist not found")
 }
 
+// AddSongToPlaylist adds song to the library and appends the library's copy
+// of it to the playlist.
+func (pm *PlaylistManager) AddSongToPlaylist(playlistID string, song *models.Song) (*models.Song, error) {
+	added, err := pm.AddSongsToPlaylist(playlistID, []*models.Song{song})
+	if err != nil {
+		return nil, err
+	}
+	return added[0], nil
+}
+
+func (pm *PlaylistManager) AddSongsToPlaylist(playlistID string, songs []*models.Song) ([]*models.Song, error) {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	playlist, err := pm.findEditablePlaylist(playlistID)
+	if err != nil {
+		return nil, err
+	}
+
+	pm.touchPlaylist(playlist.ID)
+	added := make([]*models.Song, len(songs))
+	for i, song := range songs {
+		added[i] = pm.library.Add(song)
+	}
+	playlist.AddSongs(added)
+	pm.refreshSmartPlaylists()
+	pm.record(fmt.Sprintf("adding %d songs to %q", len(added), playlist.Name))
+	return added, nil
+}
+
+// ImportSongs adds songs to the library and returns how many were new to it.
+func (pm *PlaylistManager) ImportSongs(songs []*models.Song) int {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	added := 0
+	for _, song := range songs {
+		if pm.library.Add(song) == song {
+			added++
+		}
+	}
+	pm.refreshSmartPlaylists()
+	return added
+}
+
+// ImportPlaylist creates a playlist holding songs, which are added to the
+// library first.
+func (pm *PlaylistManager) ImportPlaylist(name, description string, songs []*models.Song) *models.Playlist {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	added := make([]*models.Song, len(songs))
+	for i, song := range songs {
+		added[i] = pm.library.Add(song)
+	}
+
+	playlist := models.NewPlaylist(name, description)
+	pm.touchPlaylist(playlist.ID)
+	playlist.AddSongs(added)
+	pm.playlists = append(pm.playlists, playlist)
+	pm.refreshSmartPlaylists()
+	return playlist
+}
+
+// RemoveSongFromPlaylist drops the song from the playlist only, it stays in
+// the library.
+func (pm *PlaylistManager) RemoveSongFromPlaylist(playlistID, songID string) error {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	playlist, err := pm.findEditablePlaylist(playlistID)
+	if err != nil {
+		return err
+	}
+	song := playlist.GetSongByID(songID)
+	if song == nil {
+		return errors.New("song not found")
+	}
+	pm.touchPlaylist(playlist.ID)
+	playlist.RemoveSong(songID)
+	pm.record(fmt.Sprintf("removing %q from %q", song.Title, playlist.Name))
+	return nil
+}
+
+// ShufflePlaylist puts the songs of a playlist in a random order.
+func (pm *PlaylistManager) ShufflePlaylist(id string) (*models.Playlist, error) {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	playlist, err := pm.findEditablePlaylist(id)
+	if err != nil {
+		return nil, err
+	}
+	pm.touchPlaylist(playlist.ID)
+	playlist.Shuffle()
+	pm.record(fmt.Sprintf("shuffling %q", playlist.Name))
+	return playlist, nil
+}
+
+func (pm *PlaylistManager) ListLibrary() []*models.Song {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	return pm.library.Songs()
+}
+
+func (pm *PlaylistManager) GetSong(id string) (*models.Song, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	song := pm.library.Get(id)
+	if song == nil {
+		return nil, errors.New("song not found")
+	}
+	return song, nil
+}
+
+// UpdateSong edits a song's metadata in the library, which every playlist
+// holding the song sees.
+func (pm *PlaylistManager) UpdateSong(id string, edit func(song *models.Song)) (*models.Song, error) {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	// The edit may move the song to another path and so another ID, so keep
+	// hold of the song itself rather than looking it up again
+	song := pm.library.Get(id)
+	if song == nil || !pm.library.Update(id, edit) {
+		return nil, errors.New("song not found")
+	}
+	pm.refreshSmartPlaylists()
+	return song, nil
+}
+
+// DeleteSong removes a song from the library and from every playlist.
+func (pm *PlaylistManager) DeleteSong(id string) error {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	if !pm.deleteSong(id) {
+		return errors.New("song not found")
+	}
+	pm.refreshSmartPlaylists()
+	return nil
+}
+
+func (pm *PlaylistManager) deleteSong(id string) bool {
+	if !pm.library.Remove(id) {
+		return false
+	}
+	for _, playlist := range pm.playlists {
+		if playlist.GetSongByID(id) == nil {
+			continue
+		}
+		pm.touchPlaylist(playlist.ID)
+		for playlist.RemoveSong(id) {
+		}
+	}
+	return true
+}
+
+func (pm *PlaylistManager) findPlaylist(id string) *models.Playlist {
+	for _, playlist := range pm.playlists {
+		if playlist.ID == id {
+			return playlist
+		}
+	}
+	return nil
+}
+
 func (pm *PlaylistManager) ListPlaylists() []*models.Playlist {
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
@@ -88,60 +382,95 @@ func (pm *PlaylistManager) ListPlaylists() []*models.Playlist {
 	return result
 }
 
-func (pm *PlaylistManager) SearchSongs(query string) []*SearchResult {
+// SearchSongs runs a query in the syntax of the search package against the
+// library's index, best matches first. A song gets one result per playlist
+// holding it, or a single result without a playlist when it is only in the
+// library. With fuzzy set, words also match with a few typos. A malformed
+// query returns a *search.ParseError.
+func (pm *PlaylistManager) SearchSongs(query string, fuzzy bool) ([]*SearchResult, error) {
+	parse := search.Parse
+	if fuzzy {
+		parse = search.ParseFuzzy
+	}
+	parsed, err := parse(query)
+	if err != nil {
+		return nil, err
+	}
+
 	pm.mu.RLock()
 	defer pm.mu.RUnlock()
 
-	query = strings.ToLower(query)
-	results := make([]*SearchResult, 0)
-	resultChan := make(chan *SearchResult, 100)
-	var waitGroup sync.WaitGroup
+	hits := pm.library.Search(parsed)
+	if len(hits) == 0 {
+		return make([]*SearchResult, 0), nil
+	}
 
-	//Use goroutines to search playlists concurrently
+	wanted := make(map[string]bool, len(hits))
+	for _, hit := range hits {
+		wanted[hit.Song.ID] = true
+	}
+	holders := make(map[string][]*models.Playlist)
 	for _, playlist := range pm.playlists {
-		waitGroup.Add(1)
-		go func(p *models.Playlist) {
-			defer waitGroup.Done()
-			for _, song := range p.Songs {
-				if matchesSong(song, query) {
-					resultChan <- &SearchResult{
-						Song:         song,
-						PlaylistName: p.Name,
-						PlaylistID:   p.ID,
-					}
-				}
+		seen := make(map[string]bool)
+		for _, song := range playlist.Songs {
+			if wanted[song.ID] && !seen[song.ID] {
+				seen[song.ID] = true
+				holders[song.ID] = append(holders[song.ID], playlist)
 			}
-		}(playlist)
+		}
 	}
 
-	// Close channel when all goroutines complete
-	go func() {
-		waitGroup.Wait()
-		close(resultChan)
-	}()
+	results := make([]*SearchResult, 0, len(hits))
+	for _, hit := range hits {
+		if len(holders[hit.Song.ID]) == 0 {
+			results = append(results, &SearchResult{Song: hit.Song, Score: hit.Score, Similarity: hit.Similarity})
+			continue
+		}
+		for _, playlist := range holders[hit.Song.ID] {
+			results = append(results, &SearchResult{
+				Song:         hit.Song,
+				PlaylistName: playlist.Name,
+				PlaylistID:   playlist.ID,
+				Score:        hit.Score,
+				Similarity:   hit.Similarity,
+			})
+		}
+	}
+	return results, nil
+}
 
-	for result := range resultChan {
-		results = append(results, result)
+// CompleteSearch suggests words for the last word of a query being typed,
+// for autocompletion. A word written as field:prefix is completed from that
+// field only.
+func (pm *PlaylistManager) CompleteSearch(word string, limit int) []string {
+	field, prefix, found := strings.Cut(word, ":")
+	if !found {
+		field, prefix = "", word
 	}
 
-	return results
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	return pm.library.Complete(field, prefix, limit)
 }
 
 type SearchResult struct {
 	Song         *models.Song
 	PlaylistName string
 	PlaylistID   string
+	Score        float64
+	Similarity   float64
 }
 
 func (sr *SearchResult) String() string {
-	return fmt.Sprintf("%s (in playlist: %s)", sr.Song.ToString(), sr.PlaylistName)
-}
-
-func matchesSong(song *models.Song, query string) bool {
-	return strings.Contains(strings.ToLower(song.Title), query) ||
-		strings.Contains(strings.ToLower(song.Artist), query) ||
-		strings.Contains(strings.ToLower(song.Album), query) ||
-		strings.Contains(strings.ToLower(song.Genre), query)
+	where := "in library only"
+	if sr.PlaylistID != "" {
+		where = "in playlist: " + sr.PlaylistName
+	}
+	if sr.Similarity < 1 {
+		return fmt.Sprintf("%s (%s, %.0f%% similar)", sr.Song.ToString(), where, sr.Similarity*100)
+	}
+	return fmt.Sprintf("%s (%s)", sr.Song.ToString(), where)
 }
 
 func (pm *PlaylistManager) GetStatistics() Statistics {
@@ -150,18 +479,17 @@ func (pm *PlaylistManager) GetStatistics() Statistics {
 
 	stats := Statistics{
 		TotalPlaylists: len(pm.playlists),
+		TotalSongs:     pm.library.Len(),
 		GenreCounts:    make(map[string]int),
 		ArtistCounts:   make(map[string]int),
 	}
 
-	for _, playlist := range pm.playlists {
-		stats.TotalSongs += len(playlist.Songs)
-		stats.TotalDuration += playlist.TotalDuration()
-
-		for _, song := range playlist.Songs {
-			stats.GenreCounts[song.Genre]++
-			stats.ArtistCounts[song.Artist]++
-		}
+	// Count from the library so a song shared by several playlists is only
+	// counted once
+	for _, song := range pm.library.Songs() {
+		stats.TotalDuration += song.Duration
+		stats.GenreCounts[song.Genre]++
+		stats.ArtistCounts[song.Artist]++
 	}
 
 	return stats
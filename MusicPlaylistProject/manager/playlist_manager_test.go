@@ -0,0 +1,31 @@
+package manager
+
+import (
+	"musicplaylist/models"
+	"testing"
+)
+
+func TestUpdateSongReturnsSongAfterPathEdit(t *testing.T) {
+	pm := newTestManager(t)
+	songs := testSongs(1)
+	pm.ImportSongs(songs)
+
+	oldID := songs[0].ID
+	newPath := "/music/renamed.mp3"
+	song, err := pm.UpdateSong(oldID, func(s *models.Song) {
+		s.FilePath = newPath
+		s.ID = models.SongIDForPath(newPath)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song == nil || song.FilePath != newPath {
+		t.Fatalf("UpdateSong returned %+v, want the song at %s", song, newPath)
+	}
+	if got, err := pm.GetSong(song.ID); err != nil || got != song {
+		t.Errorf("GetSong(%s) = %+v, %v, want the edited song", song.ID, got, err)
+	}
+	if _, err := pm.GetSong(oldID); err == nil {
+		t.Errorf("song is still found under its old ID %s", oldID)
+	}
+}
@@ -0,0 +1,98 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"musicplaylist/models"
+)
+
+// MoveSongs moves the songs at positions together so the first of them ends
+// up at to, keeping the order they were in. A single position moves one song
+// to an index. Positions count from 0.
+func (pm *PlaylistManager) MoveSongs(playlistID string, positions []int, to int) (*models.Playlist, error) {
+	return pm.reorder(playlistID, func(playlist *models.Playlist) (string, error) {
+		description := fmt.Sprintf("moving %d songs in %q", len(positions), playlist.Name)
+		if len(positions) == 1 && positions[0] >= 0 && positions[0] < len(playlist.Songs) {
+			description = fmt.Sprintf("moving %q in %q", playlist.Songs[positions[0]].Title, playlist.Name)
+		}
+		return description, playlist.MoveSongs(positions, to)
+	})
+}
+
+// MoveSongBy moves the song at position offset places down, or up when
+// offset is negative.
+func (pm *PlaylistManager) MoveSongBy(playlistID string, position, offset int) (*models.Playlist, error) {
+	return pm.MoveSongs(playlistID, []int{position}, position+offset)
+}
+
+func (pm *PlaylistManager) SwapSongs(playlistID string, i, j int) (*models.Playlist, error) {
+	return pm.reorder(playlistID, func(playlist *models.Playlist) (string, error) {
+		return fmt.Sprintf("swapping two songs in %q", playlist.Name), playlist.SwapSongs(i, j)
+	})
+}
+
+// SortPlaylist puts the songs of a playlist in the order of keys.
+func (pm *PlaylistManager) SortPlaylist(playlistID string, keys []models.SortKey) (*models.Playlist, error) {
+	return pm.reorder(playlistID, func(playlist *models.Playlist) (string, error) {
+		return fmt.Sprintf("sorting %q", playlist.Name), playlist.Sort(keys)
+	})
+}
+
+// SortedSongs returns the songs of a playlist in the order of keys, leaving
+// the playlist as it is. Smart playlists can be viewed sorted too.
+func (pm *PlaylistManager) SortedSongs(playlistID string, keys []models.SortKey) ([]*models.Song, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	playlist := pm.findPlaylist(playlistID)
+	if playlist == nil {
+		return nil, errors.New("playlist not found")
+	}
+	return playlist.SortedSongs(keys)
+}
+
+// reorder makes change to a playlist as one change that can be undone.
+// change describes itself for undo.
+func (pm *PlaylistManager) reorder(playlistID string, change func(playlist *models.Playlist) (string, error)) (*models.Playlist, error) {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	playlist, err := pm.findEditablePlaylist(playlistID)
+	if err != nil {
+		return nil, err
+	}
+	pm.touchPlaylist(playlist.ID)
+	description, err := change(playlist)
+	if err != nil {
+		return nil, err
+	}
+	pm.record(description)
+	return playlist, nil
+}
+
+// InsertSongsIntoPlaylist is AddSongsToPlaylist putting the songs at index
+// instead of the end.
+func (pm *PlaylistManager) InsertSongsIntoPlaylist(playlistID string, index int, songs []*models.Song) ([]*models.Song, error) {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	playlist, err := pm.findEditablePlaylist(playlistID)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index > len(playlist.Songs) {
+		return nil, errors.New("position out of range")
+	}
+
+	pm.touchPlaylist(playlist.ID)
+	added := make([]*models.Song, len(songs))
+	for i, song := range songs {
+		added[i] = pm.library.Add(song)
+	}
+	if err := playlist.InsertSongs(index, added); err != nil {
+		return nil, err
+	}
+	pm.refreshSmartPlaylists()
+	pm.record(fmt.Sprintf("inserting %d songs into %q", len(added), playlist.Name))
+	return added, nil
+}
@@ -0,0 +1,132 @@
+package manager
+
+import (
+	"fmt"
+	"musicplaylist/models"
+	"slices"
+	"testing"
+)
+
+// newReorderPlaylist returns a manager with a playlist of n songs, and the
+// song names by ID for songOrder.
+func newReorderPlaylist(t *testing.T, n int) (*PlaylistManager, *models.Playlist, map[string]string) {
+	t.Helper()
+	pm := newTestManager(t)
+	playlist := pm.CreatePlaylist("Mix", "")
+	songs := testSongs(n)
+	if _, err := pm.AddSongsToPlaylist(playlist.ID, songs); err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]string, n)
+	for i, song := range songs {
+		names[song.ID] = fmt.Sprint(i)
+	}
+	return pm, playlist, names
+}
+
+// songOrder lists a playlist's songs by name.
+func songOrder(playlist *models.Playlist, names map[string]string) []string {
+	order := make([]string, len(playlist.Songs))
+	for i, song := range playlist.Songs {
+		order[i] = names[song.ID]
+		if order[i] == "" {
+			order[i] = song.Title
+		}
+	}
+	return order
+}
+
+func TestMoveSongs(t *testing.T) {
+	original := []string{"0", "1", "2", "3", "4"}
+	tests := []struct {
+		name      string
+		positions []int
+		to        int
+		want      []string // nil when the move is refused
+	}{
+		{"one song down", []int{1}, 3, []string{"0", "2", "3", "1", "4"}},
+		{"one song up", []int{3}, 0, []string{"3", "0", "1", "2", "4"}},
+		{"first to last", []int{0}, 4, []string{"1", "2", "3", "4", "0"}},
+		{"block to the start", []int{1, 3}, 0, []string{"1", "3", "0", "2", "4"}},
+		{"block in any order", []int{3, 1}, 0, []string{"1", "3", "0", "2", "4"}},
+		{"block to the end", []int{0, 2}, 3, []string{"1", "3", "4", "0", "2"}},
+		{"block onto itself", []int{1, 2}, 1, original},
+		{"repeated position", []int{2, 2}, 0, []string{"2", "0", "1", "3", "4"}},
+		{"negative from", []int{-1}, 0, nil},
+		{"from at length", []int{5}, 0, nil},
+		{"from past length", []int{1, 9}, 0, nil},
+		{"negative to", []int{0}, -1, nil},
+		{"to at length", []int{0}, 5, nil},
+		{"block past the end", []int{0, 1}, 4, nil},
+		{"no positions", nil, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm, playlist, names := newReorderPlaylist(t, len(original))
+
+			_, err := pm.MoveSongs(playlist.ID, tt.positions, tt.to)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("MoveSongs(%v, %d) succeeded, want an error", tt.positions, tt.to)
+				}
+				if got := songOrder(playlist, names); !slices.Equal(got, original) {
+					t.Errorf("refused move left the songs in order %v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("MoveSongs(%v, %d): %v", tt.positions, tt.to, err)
+			}
+			if got := songOrder(playlist, names); !slices.Equal(got, tt.want) {
+				t.Errorf("MoveSongs(%v, %d) gave %v, want %v", tt.positions, tt.to, got, tt.want)
+			}
+
+			if _, err := pm.Undo(); err != nil {
+				t.Fatal(err)
+			}
+			if got := songOrder(playlist, names); !slices.Equal(got, original) {
+				t.Errorf("undoing the move gave %v, want %v", got, original)
+			}
+		})
+	}
+}
+
+func TestInsertSongsIntoPlaylist(t *testing.T) {
+	tests := []struct {
+		name  string
+		index int
+		want  []string // nil when the insert is refused
+	}{
+		{"at the start", 0, []string{"new", "0", "1", "2"}},
+		{"in the middle", 1, []string{"0", "new", "1", "2"}},
+		{"at length", 3, []string{"0", "1", "2", "new"}},
+		{"negative index", -1, nil},
+		{"past length", 4, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pm, playlist, names := newReorderPlaylist(t, 3)
+			song := &models.Song{ID: models.SongIDForPath("/music/new.mp3"), Title: "new", FilePath: "/music/new.mp3"}
+
+			_, err := pm.InsertSongsIntoPlaylist(playlist.ID, tt.index, []*models.Song{song})
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("InsertSongsIntoPlaylist at %d succeeded, want an error", tt.index)
+				}
+				if got := songOrder(playlist, names); !slices.Equal(got, []string{"0", "1", "2"}) {
+					t.Errorf("refused insert left the songs in order %v", got)
+				}
+				if pm.library.Get(song.ID) != nil {
+					t.Error("refused insert added the song to the library")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("InsertSongsIntoPlaylist at %d: %v", tt.index, err)
+			}
+			if got := songOrder(playlist, names); !slices.Equal(got, tt.want) {
+				t.Errorf("InsertSongsIntoPlaylist at %d gave %v, want %v", tt.index, got, tt.want)
+			}
+		})
+	}
+}
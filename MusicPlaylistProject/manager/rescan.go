@@ -0,0 +1,262 @@
+package manager
+
+import (
+	"fmt"
+	"musicplaylist/models"
+	"musicplaylist/scanner"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+func (pm *PlaylistManager) ListScanRoots() []*models.ScanRoot {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	result := make([]*models.ScanRoot, len(pm.roots))
+	copy(result, pm.roots)
+	return result
+}
+
+// PlanRescan compares a folder with what was recorded when it was last scanned.
+// A folder that was never scanned is planned against an empty record, so every
+// file in it shows up as added. The diff targets playlistID, or the playlist
+// the folder was first added to when playlistID is empty.
+func (pm *PlaylistManager) PlanRescan(path, playlistID string) (*scanner.RescanDiff, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Take a private copy of the record so the walk can run without the lock
+	pm.mu.RLock()
+	if playlistID != "" {
+		if _, err := pm.findEditablePlaylist(playlistID); err != nil {
+			pm.mu.RUnlock()
+			return nil, err
+		}
+	}
+	root := models.NewScanRoot(path, playlistID)
+	if existing := pm.findScanRoot(path); existing != nil {
+		for file, state := range existing.Files {
+			root.Files[file] = state
+		}
+		if playlistID == "" {
+			root.PlaylistID = existing.PlaylistID
+		}
+	}
+	pm.mu.RUnlock()
+
+	return scanner.PlanRescan(root)
+}
+
+// ApplyRescan brings the library, the diff's target playlist and the folder's
+// record in line with a diff from PlanRescan. Files that can no longer be read
+// are left out of the record so the next rescan picks them up again. Removed
+// files are flagged as missing, so a drive that is briefly unmounted doesn't
+// empty the playlists.
+func (pm *PlaylistManager) ApplyRescan(diff *scanner.RescanDiff) error {
+	scanned := make(map[string]*models.Song)
+	for _, changes := range [][]scanner.FileChange{diff.Added, diff.Changed, diff.Moved} {
+		for _, change := range changes {
+			if song, err := scanner.ScanFile(change.Path); err == nil {
+				scanned[change.Path] = song
+			}
+		}
+	}
+
+	pm.mu.Lock()
+	defer pm.commit()
+
+	var playlist *models.Playlist
+	if diff.PlaylistID != "" {
+		var err error
+		if playlist, err = pm.findEditablePlaylist(diff.PlaylistID); err != nil {
+			return err
+		}
+	}
+
+	rootPath, err := filepath.Abs(diff.Root)
+	if err != nil {
+		return err
+	}
+	if playlist != nil {
+		pm.touchPlaylist(playlist.ID)
+	}
+	pm.touchRoot(rootPath)
+	root := pm.findScanRoot(rootPath)
+	if root == nil {
+		root = models.NewScanRoot(rootPath, diff.PlaylistID)
+		pm.roots = append(pm.roots, root)
+		if pm.watcher != nil {
+			if err := pm.watcher.Add(rootPath); err != nil {
+				pm.warn(fmt.Errorf("could not watch %s: %w", rootPath, err))
+			}
+		}
+	} else if root.PlaylistID == "" {
+		root.PlaylistID = diff.PlaylistID
+	}
+
+	addScanned := func(change scanner.FileChange) {
+		song, ok := scanned[change.Path]
+		if !ok {
+			return
+		}
+		if existing := pm.library.Add(song); existing != song {
+			pm.library.Update(existing.ID, func(s *models.Song) { refreshSong(s, song) })
+			song = existing
+		}
+		if playlist != nil && playlist.GetSongByID(song.ID) == nil {
+			playlist.AddSong(song)
+		}
+		root.Files[change.Path] = fileState(change, song.ID)
+	}
+
+	for _, change := range diff.Added {
+		addScanned(change)
+	}
+
+	for _, change := range diff.Changed {
+		song, ok := scanned[change.Path]
+		if !ok || !pm.library.Update(change.SongID, func(s *models.Song) { refreshSong(s, song) }) {
+			addScanned(change)
+			continue
+		}
+		root.Files[change.Path] = fileState(change, change.SongID)
+	}
+
+	for _, change := range diff.Moved {
+		delete(root.Files, change.OldPath)
+		song := pm.moveSong(change.SongID, change.Path)
+		if song == nil {
+			addScanned(change)
+			continue
+		}
+		root.Files[change.Path] = fileState(change, song.ID)
+	}
+
+	for _, change := range diff.Removed {
+		delete(root.Files, change.Path)
+		pm.library.Update(change.SongID, func(s *models.Song) { s.Missing = true })
+	}
+
+	root.LastScanned = time.Now()
+	pm.refreshSmartPlaylists()
+	if playlist != nil {
+		pm.record(fmt.Sprintf("adding folder %s to %q", rootPath, playlist.Name))
+	} else {
+		pm.record("rescanning " + rootPath)
+	}
+	return nil
+}
+
+// AddFolderToPlaylist scans a folder into a playlist in one step. Songs that
+// are already in the playlist are not added again, so adding the same folder
+// twice only picks up what changed.
+func (pm *PlaylistManager) AddFolderToPlaylist(playlistID, path string) (*scanner.RescanDiff, error) {
+	diff, err := pm.PlanRescan(path, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	if err := pm.ApplyRescan(diff); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
+
+// moveSong points the song with the given ID at the file now at path. The
+// song takes the ID that path gives it, so a new file at the old path doesn't
+// collide with it, and playlists and folder records follow it. When the
+// library already has a song for path, that song takes the moved one's place.
+func (pm *PlaylistManager) moveSong(id, path string) *models.Song {
+	song := pm.library.Get(id)
+	if song == nil {
+		return nil
+	}
+	newID := models.SongIDForPath(path)
+
+	existing := pm.library.GetByPath(path)
+	if existing == nil {
+		existing = pm.library.Get(newID)
+	}
+	if existing != nil && existing != song {
+		pm.library.Update(existing.ID, func(s *models.Song) { s.Missing = false })
+		pm.replaceSong(id, song, existing)
+		pm.library.Remove(id)
+		return existing
+	}
+
+	pm.library.Update(id, func(s *models.Song) {
+		s.ID = newID
+		s.FilePath = path
+		s.Missing = false
+	})
+	pm.replaceSong(id, song, song)
+	return song
+}
+
+// replaceSong points every playlist entry for old, and every folder record of
+// oldID, at song. old and song may be the same song under a new ID. An entry
+// is dropped if the playlist already held song, as DedupeLibrary does.
+func (pm *PlaylistManager) replaceSong(oldID string, old, song *models.Song) {
+	for _, playlist := range pm.playlists {
+		if !slices.Contains(playlist.Songs, old) {
+			continue
+		}
+		present := old != song && slices.Contains(playlist.Songs, song)
+		songs := make([]*models.Song, 0, len(playlist.Songs))
+		for _, s := range playlist.Songs {
+			if s == old {
+				if present {
+					continue
+				}
+				s = song
+			}
+			songs = append(songs, s)
+		}
+		pm.touchPlaylist(playlist.ID)
+		playlist.Songs = songs
+		playlist.UpdatedAt = time.Now()
+	}
+	for _, root := range pm.roots {
+		for _, state := range root.Files {
+			if state.SongID == oldID {
+				pm.touchRoot(root.Path)
+				state.SongID = song.ID
+			}
+		}
+	}
+}
+
+func (pm *PlaylistManager) findScanRoot(path string) *models.ScanRoot {
+	for _, root := range pm.roots {
+		if root.Path == path {
+			return root
+		}
+	}
+	return nil
+}
+
+func fileState(change scanner.FileChange, songID string) *models.FileState {
+	return &models.FileState{
+		Size:    change.Size,
+		ModTime: change.ModTime,
+		Hash:    change.Hash,
+		SongID:  songID,
+	}
+}
+
+// refreshSong copies what was read from the file onto the library's song,
+// keeping its ID and anything that isn't stored in the file.
+func refreshSong(dst, src *models.Song) {
+	dst.FilePath = src.FilePath
+	dst.Title = src.Title
+	dst.Artist = src.Artist
+	dst.Album = src.Album
+	dst.Genre = src.Genre
+	dst.Year = src.Year
+	dst.TrackNumber = src.TrackNumber
+	dst.Duration = src.Duration
+	dst.ContentHash = src.ContentHash
+	dst.Missing = false
+}
@@ -0,0 +1,126 @@
+package manager
+
+import (
+	"musicplaylist/models"
+	"musicplaylist/storage"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFLAC writes a FLAC file with a STREAMINFO block followed by payload,
+// which stands in for the audio frames the content hash is taken over.
+func writeFLAC(t *testing.T, path, payload string) {
+	t.Helper()
+
+	data := []byte("fLaC")
+	// Last metadata block, type 0 (STREAMINFO), 34 bytes long
+	data = append(data, 0x80, 0, 0, 34)
+	data = append(data, make([]byte, 34)...)
+	data = append(data, payload...)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestManager(t *testing.T) *PlaylistManager {
+	t.Helper()
+	pm := CreatePlaylistManager(storage.NewJSONStorage(filepath.Join(t.TempDir(), "playlists.json")))
+	if err := pm.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return pm
+}
+
+func rescan(t *testing.T, pm *PlaylistManager, dir string) {
+	t.Helper()
+	diff, err := pm.PlanRescan(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.ApplyRescan(diff); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRescanMoveRekeysSong(t *testing.T) {
+	pm := newTestManager(t)
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "a.flac")
+	newPath := filepath.Join(dir, "sub", "b.flac")
+	writeFLAC(t, oldPath, "first")
+
+	playlist := pm.CreatePlaylist("Folder", "")
+	if _, err := pm.AddFolderToPlaylist(playlist.ID, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(newPath), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		t.Fatal(err)
+	}
+	rescan(t, pm, dir)
+
+	newID := models.SongIDForPath(newPath)
+	song := pm.library.Get(newID)
+	if song == nil || song.FilePath != newPath {
+		t.Fatalf("moved song = %+v, want ID %s at %s", song, newID, newPath)
+	}
+	if pm.library.Get(models.SongIDForPath(oldPath)) != nil {
+		t.Error("moved song is still in the library under its old ID")
+	}
+	if got := playlist.GetSongByID(newID); got != song {
+		t.Errorf("playlist holds %+v, want the moved song", got)
+	}
+	root := pm.findScanRoot(dir)
+	if state := root.Files[newPath]; state == nil || state.SongID != newID {
+		t.Errorf("folder record = %+v, want song %s", state, newID)
+	}
+
+	// A new file at the old path is a song of its own
+	writeFLAC(t, oldPath, "second")
+	rescan(t, pm, dir)
+	if pm.library.Len() != 2 || len(playlist.Songs) != 2 {
+		t.Errorf("got %d songs in the library and %d in the playlist, want 2 and 2",
+			pm.library.Len(), len(playlist.Songs))
+	}
+}
+
+func TestRescanFlagsRemovedFilesMissing(t *testing.T) {
+	pm := newTestManager(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.flac")
+	writeFLAC(t, path, "first")
+
+	playlist := pm.CreatePlaylist("Folder", "")
+	if _, err := pm.AddFolderToPlaylist(playlist.ID, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	rescan(t, pm, dir)
+
+	if len(playlist.Songs) != 1 || !playlist.Songs[0].Missing {
+		t.Fatalf("playlist songs = %+v, want the removed song flagged missing", playlist.Songs)
+	}
+
+	// The file coming back clears the flag
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	rescan(t, pm, dir)
+	if len(playlist.Songs) != 1 || playlist.Songs[0].Missing {
+		t.Errorf("playlist songs = %+v, want the song back and not missing", playlist.Songs)
+	}
+}
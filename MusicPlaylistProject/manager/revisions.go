@@ -0,0 +1,394 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"musicplaylist/models"
+	"musicplaylist/storage"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	errNoRevisions      = errors.New("revisions are kept in the journal, which this storage doesn't keep")
+	errRevisionNotFound = errors.New("revision not found")
+)
+
+// Revision is a playlist as one change left it. Revisions are read back from
+// the journal, one for every change stored that touched the playlist,
+// numbered from 1 in the order they were made.
+type Revision struct {
+	Number  int       `json:"number"`
+	Name    string    `json:"name,omitempty"`
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author"`
+	Summary string    `json:"summary"`
+	// Playlist is everything about the playlist but its songs
+	Playlist *models.Playlist `json:"playlist"`
+	SongIDs  []string         `json:"songIds"`
+	Deleted  bool             `json:"deleted,omitempty"`
+}
+
+// RevisionDiff is what changed from one revision of a playlist to another.
+// Moved are the songs both hold that changed places among the others. It
+// reads as a summary like "added 3, moved 1", counting songs.
+type RevisionDiff struct {
+	From    int            `json:"from"`
+	To      int            `json:"to"`
+	Details []string       `json:"details"`
+	Added   []*models.Song `json:"added"`
+	Removed []*models.Song `json:"removed"`
+	Moved   []*MovedSong   `json:"moved"`
+}
+
+// MovedSong is a song at a different position, counting from 0, in the
+// revision compared with.
+type MovedSong struct {
+	Song *models.Song `json:"song"`
+	From int          `json:"from"`
+	To   int          `json:"to"`
+}
+
+func (d *RevisionDiff) String() string {
+	parts := slices.Clone(d.Details)
+	if len(d.Added) > 0 {
+		parts = append(parts, fmt.Sprintf("added %d", len(d.Added)))
+	}
+	if len(d.Removed) > 0 {
+		parts = append(parts, fmt.Sprintf("removed %d", len(d.Removed)))
+	}
+	if len(d.Moved) > 0 {
+		parts = append(parts, fmt.Sprintf("moved %d", len(d.Moved)))
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Revisions lists the revisions of a playlist, oldest first.
+func (pm *PlaylistManager) Revisions(playlistID string) ([]*Revision, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	revisions, _, err := pm.revisions(playlistID)
+	return revisions, err
+}
+
+// revisions replays the journal for one playlist. It also returns the last
+// version of every song the journal stored, for songs since deleted.
+func (pm *PlaylistManager) revisions(playlistID string) ([]*Revision, map[string]*models.Song, error) {
+	journal, ok := pm.journal()
+	if !ok {
+		return nil, nil, errNoRevisions
+	}
+	entries, err := journal.History()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	revisions := make([]*Revision, 0)
+	songs := make(map[string]*models.Song)
+	names := make(map[int]string)
+	var header *models.Playlist
+	songIDs := make([]string, 0)
+
+	// The entries of one batch share its time and author
+	for start, end := 0, 0; start < len(entries); start = end {
+		touched, deleted := false, false
+		for end = start; end < len(entries) && entries[end].Time.Equal(entries[start].Time) &&
+			entries[end].Actor == entries[start].Actor; end++ {
+			entry := entries[end]
+			switch {
+			case entry.Op == storage.OpUpsertSong:
+				songs[entry.SongID] = entry.Song
+			case entry.PlaylistID != playlistID:
+			case entry.Op == storage.OpNameRevision:
+				names[entry.Index] = entry.Name
+			case entry.Op == storage.OpUpsertPlaylist:
+				header, touched, deleted = entry.Playlist, true, false
+			case entry.Op == storage.OpDeletePlaylist:
+				touched, deleted = true, true
+			case entry.Op == storage.OpInsertSongs, entry.Op == storage.OpRemoveSongs, entry.Op == storage.OpReorderSongs:
+				songIDs = slices.Clone(entry.After)
+				if songIDs == nil {
+					songIDs = make([]string, 0)
+				}
+				touched = true
+			}
+		}
+		if touched {
+			revisions = append(revisions, &Revision{
+				Number:   len(revisions) + 1,
+				Time:     entries[start].Time,
+				Author:   entries[start].Actor,
+				Playlist: header,
+				SongIDs:  songIDs,
+				Deleted:  deleted,
+			})
+		}
+	}
+
+	if len(revisions) == 0 && pm.findPlaylist(playlistID) == nil {
+		return nil, nil, errors.New("playlist not found")
+	}
+	for i, revision := range revisions {
+		// Changes recorded before the first full one only had the songs
+		if revision.Playlist == nil {
+			revision.Playlist = &models.Playlist{ID: playlistID}
+			if playlist := pm.findPlaylist(playlistID); playlist != nil {
+				*revision.Playlist = *playlist
+				revision.Playlist.Songs = nil
+			}
+		}
+		revision.Name = names[revision.Number]
+		switch {
+		case revision.Deleted:
+			revision.Summary = "deleted"
+		case i == 0:
+			revision.Summary = fmt.Sprintf("first revision, %d songs", len(revision.SongIDs))
+		default:
+			revision.Summary = pm.diff(revisions[i-1], revision, songs).String()
+		}
+	}
+	return revisions, songs, nil
+}
+
+// DiffRevisions compares two revisions of a playlist.
+func (pm *PlaylistManager) DiffRevisions(playlistID string, from, to int) (*RevisionDiff, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	revisions, songs, err := pm.revisions(playlistID)
+	if err != nil {
+		return nil, err
+	}
+	a, err := findRevision(revisions, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := findRevision(revisions, to)
+	if err != nil {
+		return nil, err
+	}
+	return pm.diff(a, b, songs), nil
+}
+
+func findRevision(revisions []*Revision, number int) (*Revision, error) {
+	if number < 1 || number > len(revisions) {
+		return nil, errRevisionNotFound
+	}
+	return revisions[number-1], nil
+}
+
+func (pm *PlaylistManager) diff(a, b *Revision, songs map[string]*models.Song) *RevisionDiff {
+	diff := &RevisionDiff{
+		From:    a.Number,
+		To:      b.Number,
+		Details: make([]string, 0),
+		Added:   make([]*models.Song, 0),
+		Removed: make([]*models.Song, 0),
+		Moved:   make([]*MovedSong, 0),
+	}
+	if a.Playlist.Name != b.Playlist.Name {
+		diff.Details = append(diff.Details, fmt.Sprintf("renamed from %q to %q", a.Playlist.Name, b.Playlist.Name))
+	}
+	if a.Playlist.Description != b.Playlist.Description {
+		diff.Details = append(diff.Details, "description changed")
+	}
+	if !sameJSON(a.Playlist.Smart, b.Playlist.Smart) {
+		diff.Details = append(diff.Details, "rules changed")
+	}
+
+	added, removed, moved := diffSongIDs(a.SongIDs, b.SongIDs)
+	for _, i := range added {
+		diff.Added = append(diff.Added, pm.knownSong(b.SongIDs[i], songs))
+	}
+	for _, i := range removed {
+		diff.Removed = append(diff.Removed, pm.knownSong(a.SongIDs[i], songs))
+	}
+	for _, move := range moved {
+		diff.Moved = append(diff.Moved, &MovedSong{Song: pm.knownSong(a.SongIDs[move[0]], songs), From: move[0], To: move[1]})
+	}
+	return diff
+}
+
+// knownSong finds a song in the library, or as the journal last stored it
+// if it has been deleted since.
+func (pm *PlaylistManager) knownSong(id string, songs map[string]*models.Song) *models.Song {
+	if song := pm.library.Get(id); song != nil {
+		return song
+	}
+	if song := songs[id]; song != nil {
+		return song
+	}
+	return &models.Song{ID: id, Title: id}
+}
+
+// diffSongIDs compares two lists of song IDs. It returns the positions in b
+// of the songs added, the positions in a of the songs removed, and the
+// positions in both of the songs that moved. A song in a list more than once
+// is matched occurrence by occurrence. The songs that didn't move are the
+// longest run of common songs still in the same order.
+func diffSongIDs(a, b []string) (added, removed []int, moved [][2]int) {
+	type occurrence struct {
+		id string
+		n  int
+	}
+	positions := make(map[occurrence]int, len(b))
+	seen := make(map[string]int, len(b))
+	for j, id := range b {
+		positions[occurrence{id, seen[id]}] = j
+		seen[id]++
+	}
+
+	matched := make([]bool, len(b))
+	common := make([][2]int, 0, len(a))
+	clear(seen)
+	for i, id := range a {
+		j, ok := positions[occurrence{id, seen[id]}]
+		seen[id]++
+		if !ok {
+			removed = append(removed, i)
+			continue
+		}
+		matched[j] = true
+		common = append(common, [2]int{i, j})
+	}
+	for j := range b {
+		if !matched[j] {
+			added = append(added, j)
+		}
+	}
+
+	targets := make([]int, len(common))
+	for k, pair := range common {
+		targets[k] = pair[1]
+	}
+	stays := longestIncreasing(targets)
+	for k, pair := range common {
+		if !stays[k] {
+			moved = append(moved, pair)
+		}
+	}
+	return added, removed, moved
+}
+
+// longestIncreasing marks the members of a longest increasing subsequence.
+func longestIncreasing(values []int) []bool {
+	// tails[k] is the index of the smallest value ending a run of k+1
+	tails := make([]int, 0)
+	prev := make([]int, len(values))
+	for i, value := range values {
+		k, _ := slices.BinarySearchFunc(tails, value, func(t, v int) int {
+			return values[t] - v
+		})
+		if k > 0 {
+			prev[i] = tails[k-1]
+		} else {
+			prev[i] = -1
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+
+	members := make([]bool, len(values))
+	if len(tails) > 0 {
+		for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+			members[i] = true
+		}
+	}
+	return members
+}
+
+// RestoreRevision brings back a revision of a playlist, either over the
+// playlist or as a new one next to it. Songs deleted from the library since
+// are added back as the journal last stored them. It can be undone.
+func (pm *PlaylistManager) RestoreRevision(playlistID string, number int, asNew bool) (*models.Playlist, error) {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	revisions, known, err := pm.revisions(playlistID)
+	if err != nil {
+		return nil, err
+	}
+	revision, err := findRevision(revisions, number)
+	if err != nil {
+		return nil, err
+	}
+
+	playlist := pm.findPlaylist(playlistID)
+	if !asNew && playlist == nil {
+		return nil, errors.New("playlist not found, it can only be restored as a new playlist")
+	}
+
+	songs := make([]*models.Song, 0, len(revision.SongIDs))
+	for _, id := range revision.SongIDs {
+		song := pm.library.Get(id)
+		if song == nil && known[id] != nil {
+			copied := *known[id]
+			song = pm.library.Add(&copied)
+		}
+		if song != nil {
+			songs = append(songs, song)
+		}
+	}
+
+	if asNew {
+		name := fmt.Sprintf("%s (revision %d)", revision.Playlist.Name, revision.Number)
+		playlist = models.NewPlaylist(name, revision.Playlist.Description)
+		pm.touchPlaylist(playlist.ID)
+		pm.playlists = append(pm.playlists, playlist)
+	} else {
+		pm.touchPlaylist(playlist.ID)
+		playlist.Name = revision.Playlist.Name
+		playlist.Description = revision.Playlist.Description
+		playlist.UpdatedAt = time.Now()
+	}
+	playlist.Smart = revision.Playlist.Smart
+	playlist.Songs = songs
+	pm.refreshSmartPlaylists()
+
+	pm.record(fmt.Sprintf("restoring revision %d of %q", revision.Number, revision.Playlist.Name))
+	return playlist, nil
+}
+
+// NameRevision gives a revision of a playlist a name to find it by.
+func (pm *PlaylistManager) NameRevision(playlistID string, number int, name string) error {
+	journal, ok := pm.journal()
+	if !ok {
+		return errNoRevisions
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	revisions, _, err := pm.revisions(playlistID)
+	if err != nil {
+		return err
+	}
+	if _, err := findRevision(revisions, number); err != nil {
+		return err
+	}
+
+	unlock, err := pm.lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// Saving first takes in what others saved, so updating the stamp below
+	// skips nothing
+	if err := pm.save(); err != nil {
+		return err
+	}
+	entry := storage.JournalEntry{Actor: pm.actor, Op: storage.OpNameRevision, PlaylistID: playlistID, Index: number, Name: name}
+	if err := journal.Annotate(entry); err != nil {
+		return err
+	}
+	return pm.updateStamp()
+}
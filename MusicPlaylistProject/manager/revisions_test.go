@@ -0,0 +1,103 @@
+package manager
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestDiffSongIDs(t *testing.T) {
+	tests := []struct {
+		name                  string
+		a, b                  []string
+		added, removed, moved int
+	}{
+		{"same", []string{"a", "b", "c"}, []string{"a", "b", "c"}, 0, 0, 0},
+		{"added and removed", []string{"a", "b", "c"}, []string{"a", "c", "d", "e"}, 2, 1, 0},
+		{"one moved to the end", []string{"a", "b", "c", "d"}, []string{"b", "c", "d", "a"}, 0, 0, 1},
+		{"two swapped", []string{"a", "b", "c", "d"}, []string{"a", "d", "c", "b"}, 0, 0, 2},
+		{"reversed", []string{"a", "b", "c"}, []string{"c", "b", "a"}, 0, 0, 2},
+		{"repeated song", []string{"a", "b", "a"}, []string{"a", "b"}, 0, 1, 0},
+		{"from empty", nil, []string{"a", "b"}, 2, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed, moved := diffSongIDs(tt.a, tt.b)
+			if len(added) != tt.added || len(removed) != tt.removed || len(moved) != tt.moved {
+				t.Errorf("diffSongIDs(%v, %v) = added %v, removed %v, moved %v; want %d, %d and %d",
+					tt.a, tt.b, added, removed, moved, tt.added, tt.removed, tt.moved)
+			}
+		})
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	pm := CreatePlaylistManager(testStores["journal"](filepath.Join(t.TempDir(), "playlists.json")))
+	if err := pm.Load(); err != nil {
+		t.Fatal(err)
+	}
+	songs := testSongs(3)
+	playlist := pm.CreatePlaylist("Mix", "")
+	steps := []func() error{
+		func() error { _, err := pm.AddSongsToPlaylist(playlist.ID, songs); return err },
+		func() error { _, err := pm.MoveSongs(playlist.ID, []int{0}, 2); return err },
+		func() error { return pm.RemoveSongFromPlaylist(playlist.ID, songs[1].ID) },
+	}
+	for i, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+	}
+
+	revisions, err := pm.Revisions(playlist.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summaries []string
+	for _, revision := range revisions {
+		summaries = append(summaries, revision.Summary)
+	}
+	want := []string{"first revision, 0 songs", "added 3", "moved 1", "removed 1"}
+	if !slices.Equal(summaries, want) {
+		t.Fatalf("revision summaries = %q, want %q", summaries, want)
+	}
+
+	diff, err := pm.DiffRevisions(playlist.ID, 2, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0] != pm.library.Get(songs[1].ID) {
+		t.Errorf("diff from revision 2 to 4 removed %v, want song %s", diff.Removed, songs[1].ID)
+	}
+
+	if err := pm.NameRevision(playlist.ID, 2, "All three"); err != nil {
+		t.Fatal(err)
+	}
+	if revisions, err := pm.Revisions(playlist.ID); err != nil || revisions[1].Name != "All three" {
+		t.Errorf("revision 2 is named %q, %v, want %q", revisions[1].Name, err, "All three")
+	}
+
+	// Restoring brings back revision 2's songs in its order, and undoing
+	// that goes back to the latest
+	latest := slices.Clone(playlist.Songs)
+	if _, err := pm.RestoreRevision(playlist.ID, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(playlist.Songs, songs) {
+		t.Errorf("restored songs = %v, want %v", playlist.Songs, songs)
+	}
+	if _, err := pm.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(playlist.Songs, latest) {
+		t.Errorf("songs after undoing the restore = %v, want %v", playlist.Songs, latest)
+	}
+
+	restored, err := pm.RestoreRevision(playlist.ID, 3, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.ID == playlist.ID || restored.Name != "Mix (revision 3)" || len(restored.Songs) != 3 {
+		t.Errorf("revision 3 restored as %q with %d songs, want a new playlist with 3", restored.Name, len(restored.Songs))
+	}
+}
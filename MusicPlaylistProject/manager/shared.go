@@ -0,0 +1,330 @@
+package manager
+
+import (
+	"fmt"
+	"musicplaylist/models"
+	"musicplaylist/storage"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Resolution says what a save does about conflicts: changes made here and by
+// another process to the same playlist, song or folder.
+type Resolution int
+
+const (
+	// RefuseConflicts saves nothing and reports the conflicts
+	RefuseConflicts Resolution = iota
+	// KeepMine overwrites the other process's version of what conflicts
+	KeepMine
+	// KeepTheirs drops the changes made here to what conflicts
+	KeepTheirs
+)
+
+// ConflictError lists what both this process and another one changed since
+// the collection was last loaded or saved here.
+type ConflictError struct {
+	Playlists []string `json:"playlists"`
+	Songs     []string `json:"songs"`
+	ScanRoots []string `json:"scanRoots"`
+}
+
+func (e *ConflictError) Error() string {
+	parts := make([]string, 0, 3)
+	if len(e.Playlists) > 0 {
+		parts = append(parts, "playlists "+quoteAll(e.Playlists))
+	}
+	if len(e.Songs) > 0 {
+		parts = append(parts, "songs "+quoteAll(e.Songs))
+	}
+	if len(e.ScanRoots) > 0 {
+		parts = append(parts, "folders "+quoteAll(e.ScanRoots))
+	}
+	return "another program saved changes to the same data: " + strings.Join(parts, "; ")
+}
+
+func (e *ConflictError) empty() bool {
+	return len(e.Playlists) == 0 && len(e.Songs) == 0 && len(e.ScanRoots) == 0
+}
+
+func quoteAll(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = fmt.Sprintf("%q", name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// lockStore holds off other processes sharing the storage, until the
+// returned function is called.
+func (pm *PlaylistManager) lockStore() (func(), error) {
+	if shared, ok := pm.store.(storage.SharedStorage); ok {
+		return shared.Lock()
+	}
+	return func() {}, nil
+}
+
+func (pm *PlaylistManager) storeStamp() (string, error) {
+	if shared, ok := pm.store.(storage.SharedStorage); ok {
+		return shared.Stamp()
+	}
+	return "", nil
+}
+
+// storeChanged reports whether anything saved to the storage since the
+// collection was last loaded or saved here.
+func (pm *PlaylistManager) storeChanged() (bool, error) {
+	stamp, err := pm.storeStamp()
+	if err != nil {
+		return false, err
+	}
+	return stamp != pm.stamp, nil
+}
+
+// SaveResolving saves like Save, settling any conflicts as resolution says.
+func (pm *PlaylistManager) SaveResolving(resolution Resolution) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	unlock, err := pm.lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return pm.saveResolving(resolution)
+}
+
+// Refresh takes in what other processes saved since the collection was last
+// loaded or saved here, keeping the changes made here. If both changed the
+// same thing nothing is taken in and the conflicts are returned.
+func (pm *PlaylistManager) Refresh() error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if pm.stored == nil {
+		return nil
+	}
+	unlock, err := pm.lockStore()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	changed, err := pm.storeChanged()
+	if err != nil || !changed {
+		return err
+	}
+	return pm.merge(RefuseConflicts)
+}
+
+// WatchStorage refreshes the collection every interval, for a process that
+// runs alongside others sharing its storage.
+func (pm *PlaylistManager) WatchStorage(interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			pm.ActAs("", func() {
+				if err := pm.Refresh(); err != nil {
+					pm.warn(fmt.Errorf("could not take in changes saved elsewhere: %w", err))
+				}
+			})
+		}
+	}()
+}
+
+// merge loads what is stored now and merges it with the collection here,
+// using what was last loaded or saved as the common ancestor: whichever side
+// changed a playlist, song or folder wins, and when both did it's a
+// conflict. Afterwards the stored collection is the one saves compare with.
+func (pm *PlaylistManager) merge(resolution Resolution) error {
+	songs, err := pm.store.LoadLibrary()
+	if err != nil {
+		return err
+	}
+	roots, err := pm.store.LoadScanRoots()
+	if err != nil {
+		return err
+	}
+	playlists, err := pm.store.LoadPlaylists()
+	if err != nil {
+		return err
+	}
+	stamp, err := pm.storeStamp()
+	if err != nil {
+		return err
+	}
+
+	theirs, err := newStoredState(playlists, songs, roots)
+	if err != nil {
+		return err
+	}
+	ourSongs := pm.library.Songs()
+	ours, err := newStoredState(pm.playlists, ourSongs, pm.roots)
+	if err != nil {
+		return err
+	}
+	base := pm.stored
+	conflicts := &ConflictError{}
+
+	// keepOurs picks the side whose version of a record is kept, and
+	// reports whether the sides conflict over it
+	keepOurs := func(oursChanged, theirsChanged, agree bool) (bool, bool) {
+		switch {
+		case !theirsChanged:
+			return true, false
+		case !oursChanged:
+			return false, false
+		case agree:
+			return true, false
+		}
+		return resolution != KeepTheirs, true
+	}
+
+	// Songs
+	theirSongs := make(map[string]*models.Song, len(songs))
+	for _, song := range songs {
+		theirSongs[song.ID] = song
+	}
+	mergedSongs := make([]*models.Song, 0, len(ourSongs))
+	decideSong := func(id string, our *models.Song) {
+		b, inBase := base.songs[id]
+		o, inOurs := ours.songs[id]
+		t, inTheirs := theirs.songs[id]
+		useOurs, conflict := keepOurs(inOurs != inBase || o != b, inTheirs != inBase || t != b,
+			inOurs == inTheirs && (!inOurs || o == t))
+		if conflict {
+			name := id
+			if our != nil {
+				name = our.Title
+			} else if their := theirSongs[id]; their != nil {
+				name = their.Title
+			}
+			conflicts.Songs = append(conflicts.Songs, name)
+		}
+		if useOurs && our != nil {
+			mergedSongs = append(mergedSongs, our)
+		} else if !useOurs && theirSongs[id] != nil {
+			mergedSongs = append(mergedSongs, theirSongs[id])
+		}
+	}
+	for _, song := range ourSongs {
+		decideSong(song.ID, song)
+	}
+	for _, song := range songs {
+		if _, ok := ours.songs[song.ID]; !ok {
+			decideSong(song.ID, nil)
+		}
+	}
+
+	// Playlists, in the stored order with new ones from here at the end
+	ourPlaylists := make(map[string]*models.Playlist, len(pm.playlists))
+	for _, playlist := range pm.playlists {
+		ourPlaylists[playlist.ID] = playlist
+	}
+	mergedPlaylists := make([]*models.Playlist, 0, len(playlists))
+	decidePlaylist := func(id string, their *models.Playlist) {
+		our := ourPlaylists[id]
+		b, inBase := base.playlists[id]
+		o, inOurs := ours.playlists[id]
+		t, inTheirs := theirs.playlists[id]
+		useOurs, conflict := keepOurs(inOurs != inBase || !o.equal(b), inTheirs != inBase || !t.equal(b),
+			inOurs == inTheirs && (!inOurs || o.equal(t)))
+		if conflict {
+			if our != nil {
+				conflicts.Playlists = append(conflicts.Playlists, our.Name)
+			} else {
+				conflicts.Playlists = append(conflicts.Playlists, their.Name)
+			}
+		}
+		if useOurs && our != nil {
+			mergedPlaylists = append(mergedPlaylists, our)
+		} else if !useOurs && their != nil {
+			mergedPlaylists = append(mergedPlaylists, their)
+		}
+	}
+	for _, playlist := range playlists {
+		decidePlaylist(playlist.ID, playlist)
+	}
+	for _, playlist := range pm.playlists {
+		if _, ok := theirs.playlists[playlist.ID]; !ok {
+			decidePlaylist(playlist.ID, nil)
+		}
+	}
+
+	// Scanned folders
+	ourRoots := make(map[string]*models.ScanRoot, len(pm.roots))
+	for _, root := range pm.roots {
+		ourRoots[root.Path] = root
+	}
+	mergedRoots := make([]*models.ScanRoot, 0, len(roots))
+	decideRoot := func(path string, their *models.ScanRoot) {
+		our := ourRoots[path]
+		b, inBase := base.roots[path]
+		o, inOurs := ours.roots[path]
+		t, inTheirs := theirs.roots[path]
+		useOurs, conflict := keepOurs(inOurs != inBase || o != b, inTheirs != inBase || t != b,
+			inOurs == inTheirs && (!inOurs || o == t))
+		if conflict {
+			conflicts.ScanRoots = append(conflicts.ScanRoots, path)
+		}
+		if useOurs && our != nil {
+			mergedRoots = append(mergedRoots, our)
+		} else if !useOurs && their != nil {
+			mergedRoots = append(mergedRoots, their)
+		}
+	}
+	for _, root := range roots {
+		decideRoot(root.Path, root)
+	}
+	for _, root := range pm.roots {
+		if _, ok := theirs.roots[root.Path]; !ok {
+			decideRoot(root.Path, nil)
+		}
+	}
+
+	if resolution == RefuseConflicts && !conflicts.empty() {
+		return conflicts
+	}
+
+	watched := pm.watchedRoots()
+	pm.install(mergedPlaylists, mergedSongs, mergedRoots)
+	pm.updateWatches(watched)
+	pm.stored = theirs
+	pm.stamp = stamp
+	return nil
+}
+
+func (p storedPlaylist) equal(q storedPlaylist) bool {
+	return p.sum == q.sum && slices.Equal(p.songIDs, q.songIDs)
+}
+
+func (pm *PlaylistManager) watchedRoots() map[string]bool {
+	watched := make(map[string]bool, len(pm.roots))
+	for _, root := range pm.roots {
+		watched[root.Path] = true
+	}
+	return watched
+}
+
+// updateWatches starts watching the folders that weren't watched before, and
+// stops watching the ones that are gone.
+func (pm *PlaylistManager) updateWatches(watched map[string]bool) {
+	if pm.watcher == nil {
+		return
+	}
+	current := pm.watchedRoots()
+	for _, root := range pm.roots {
+		if !watched[root.Path] {
+			if err := pm.watcher.Add(root.Path); err != nil {
+				pm.warn(fmt.Errorf("could not watch %s: %w", root.Path, err))
+			}
+		}
+	}
+	for path := range watched {
+		if !current[path] {
+			if err := pm.watcher.Remove(path); err != nil {
+				pm.warn(fmt.Errorf("could not stop watching %s: %w", path, err))
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package manager
+
+import (
+	"errors"
+	"musicplaylist/models"
+	"time"
+)
+
+var errSmartPlaylist = errors.New("smart playlists are filled by their rules and cannot be edited directly")
+
+func (pm *PlaylistManager) CreateSmartPlaylist(name, description string, criteria *models.SmartCriteria) (*models.Playlist, error) {
+	if err := criteria.Validate(); err != nil {
+		return nil, err
+	}
+
+	pm.mu.Lock()
+	defer pm.commit()
+
+	playlist := models.NewSmartPlaylist(name, description, criteria)
+	playlist.Songs = criteria.Evaluate(pm.library.Songs(), time.Now())
+	pm.touchPlaylist(playlist.ID)
+	pm.playlists = append(pm.playlists, playlist)
+	return playlist, nil
+}
+
+// UpdateSmartCriteria replaces the rules of a smart playlist and fills it
+// again from the library.
+func (pm *PlaylistManager) UpdateSmartCriteria(playlistID string, criteria *models.SmartCriteria) (*models.Playlist, error) {
+	if err := criteria.Validate(); err != nil {
+		return nil, err
+	}
+
+	pm.mu.Lock()
+	defer pm.commit()
+
+	playlist := pm.findPlaylist(playlistID)
+	if playlist == nil {
+		return nil, errors.New("playlist not found")
+	}
+	if !playlist.IsSmart() {
+		return nil, errors.New("playlist is not a smart playlist")
+	}
+
+	pm.touchPlaylist(playlist.ID)
+	playlist.Smart = criteria
+	playlist.Songs = criteria.Evaluate(pm.library.Songs(), time.Now())
+	playlist.UpdatedAt = time.Now()
+	return playlist, nil
+}
+
+// refreshSmartPlaylists evaluates every smart playlist against the library.
+// It runs on load and after every change to the library; rules based on the
+// current time, like added_within_days, are therefore only as fresh as the
+// last change.
+func (pm *PlaylistManager) refreshSmartPlaylists() {
+	songs := pm.library.Songs()
+	now := time.Now()
+	for _, playlist := range pm.playlists {
+		if playlist.IsSmart() {
+			playlist.Songs = playlist.Smart.Evaluate(songs, now)
+		}
+	}
+}
+
+// findEditablePlaylist is findPlaylist for operations that change which songs
+// a playlist holds, which smart playlists don't allow.
+func (pm *PlaylistManager) findEditablePlaylist(id string) (*models.Playlist, error) {
+	playlist := pm.findPlaylist(id)
+	if playlist == nil {
+		return nil, errors.New("playlist not found")
+	}
+	if playlist.IsSmart() {
+		return nil, errSmartPlaylist
+	}
+	return playlist, nil
+}
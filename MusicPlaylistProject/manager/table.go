@@ -0,0 +1,75 @@
+package manager
+
+import (
+	"errors"
+	"musicplaylist/playlistio"
+	"strings"
+)
+
+// PlaylistTable lists a playlist's songs as table rows, in playlist order.
+func (pm *PlaylistManager) PlaylistTable(playlistID string) ([]playlistio.TableRow, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	playlist := pm.findPlaylist(playlistID)
+	if playlist == nil {
+		return nil, errors.New("playlist not found")
+	}
+
+	rows := make([]playlistio.TableRow, len(playlist.Songs))
+	for i, song := range playlist.Songs {
+		rows[i] = playlistio.TableRow{Playlist: playlist.Name, Position: i + 1, Song: song}
+	}
+	return rows, nil
+}
+
+// LibraryTable lists every song in the library once. The playlist column
+// names all the playlists holding the song.
+func (pm *PlaylistManager) LibraryTable() []playlistio.TableRow {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	names := make(map[string][]string)
+	for _, playlist := range pm.playlists {
+		seen := make(map[string]bool)
+		for _, song := range playlist.Songs {
+			if !seen[song.ID] {
+				seen[song.ID] = true
+				names[song.ID] = append(names[song.ID], playlist.Name)
+			}
+		}
+	}
+
+	songs := pm.library.Songs()
+	rows := make([]playlistio.TableRow, len(songs))
+	for i, song := range songs {
+		rows[i] = playlistio.TableRow{Playlist: strings.Join(names[song.ID], "; "), Song: song}
+	}
+	return rows
+}
+
+// ApplySongEdits updates songs from an imported table and returns how many
+// were changed, along with the rows naming songs that aren't in the library.
+// With dryRun nothing is changed and the count is of the songs that would be.
+func (pm *PlaylistManager) ApplySongEdits(edits []playlistio.SongEdit, dryRun bool) (int, []playlistio.RowError) {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	updated := 0
+	rowErrors := make([]playlistio.RowError, 0)
+	for _, edit := range edits {
+		if pm.library.Get(edit.ID) == nil {
+			rowErrors = append(rowErrors, playlistio.RowError{Line: edit.Line, Column: "id", Message: "no song with ID " + edit.ID})
+			continue
+		}
+		if !dryRun {
+			pm.library.Update(edit.ID, edit.Apply)
+		}
+		updated++
+	}
+
+	if !dryRun && updated > 0 {
+		pm.refreshSmartPlaylists()
+	}
+	return updated, rowErrors
+}
@@ -0,0 +1,394 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"musicplaylist/models"
+	"slices"
+	"sort"
+)
+
+// UndoLimit is how many changes can be undone.
+const UndoLimit = 50
+
+var (
+	errNothingToUndo = errors.New("nothing to undo")
+	errNothingToRedo = errors.New("nothing to redo")
+	errChangedSince  = errors.New("what it changed has been changed again since")
+)
+
+// command is a change made through the manager, kept as the versions of the
+// playlists, songs and folders it touched from before and after it, so it
+// can be undone and redone. A nil version is one that didn't exist.
+type command struct {
+	description string
+	playlists   []playlistChange
+	songs       []songChange
+	roots       []rootChange
+}
+
+type playlistChange struct {
+	id            string
+	before, after *playlistVersion
+}
+
+type songChange struct {
+	id            string
+	before, after *models.Song
+}
+
+type rootChange struct {
+	path          string
+	before, after *models.ScanRoot
+}
+
+// playlistVersion is a playlist without its songs, the IDs of its songs, and
+// where it was in the list of playlists. Smart playlists keep no song IDs.
+type playlistVersion struct {
+	header  *models.Playlist
+	songIDs []string
+	index   int
+}
+
+// collectionState holds playlists, songs and folders by ID, or by path for
+// folders. A nil version is one that didn't exist.
+type collectionState struct {
+	playlists map[string]*playlistVersion
+	songs     map[string]*models.Song
+	roots     map[string]*models.ScanRoot
+}
+
+func newCollectionState() *collectionState {
+	return &collectionState{
+		playlists: make(map[string]*playlistVersion),
+		songs:     make(map[string]*models.Song),
+		roots:     make(map[string]*models.ScanRoot),
+	}
+}
+
+// touched returns what the change being made has touched so far, as it was
+// before the change.
+func (pm *PlaylistManager) touched() *collectionState {
+	if pm.pending == nil {
+		pm.pending = newCollectionState()
+	}
+	return pm.pending
+}
+
+// touchPlaylist is called before a playlist is added, changed or deleted. It
+// keeps the playlist as it was for record, and commit marks it for the next
+// save to look at. Songs are touched by the library as it changes them.
+func (pm *PlaylistManager) touchPlaylist(id string) {
+	touched := pm.touched()
+	if _, ok := touched.playlists[id]; ok {
+		return
+	}
+	var version *playlistVersion
+	for i, playlist := range pm.playlists {
+		if playlist.ID == id {
+			version = newPlaylistVersion(playlist, i)
+			break
+		}
+	}
+	touched.playlists[id] = version
+}
+
+func (pm *PlaylistManager) touchSong(id string) {
+	touched := pm.touched()
+	if _, ok := touched.songs[id]; ok {
+		return
+	}
+	var version *models.Song
+	if song := pm.library.Get(id); song != nil {
+		copied := *song
+		version = &copied
+	}
+	touched.songs[id] = version
+}
+
+func (pm *PlaylistManager) touchRoot(path string) {
+	touched := pm.touched()
+	if _, ok := touched.roots[path]; ok {
+		return
+	}
+	var version *models.ScanRoot
+	if root := pm.findScanRoot(path); root != nil {
+		version = cloneScanRoot(root)
+	}
+	touched.roots[path] = version
+}
+
+func newPlaylistVersion(playlist *models.Playlist, index int) *playlistVersion {
+	header := *playlist
+	header.Songs = nil
+	version := &playlistVersion{header: &header, index: index}
+	if !playlist.IsSmart() {
+		version.songIDs = make([]string, len(playlist.Songs))
+		for i, song := range playlist.Songs {
+			version.songIDs[i] = song.ID
+		}
+	}
+	return version
+}
+
+// record puts what the change being made touched on the undo stack, and
+// clears the redo stack. A change that changed nothing isn't recorded.
+func (pm *PlaylistManager) record(description string) {
+	before := pm.touched()
+	cmd := &command{description: description}
+
+	for id, version := range before.playlists {
+		var now *playlistVersion
+		for i, playlist := range pm.playlists {
+			if playlist.ID == id {
+				now = newPlaylistVersion(playlist, i)
+				break
+			}
+		}
+		if !now.same(version) {
+			cmd.playlists = append(cmd.playlists, playlistChange{id, version, now})
+		}
+	}
+	for id, song := range before.songs {
+		var now *models.Song
+		if current := pm.library.Get(id); current != nil {
+			copied := *current
+			now = &copied
+		}
+		if !sameSong(now, song) {
+			cmd.songs = append(cmd.songs, songChange{id, song, now})
+		}
+	}
+	for path, root := range before.roots {
+		var now *models.ScanRoot
+		if current := pm.findScanRoot(path); current != nil {
+			now = cloneScanRoot(current)
+		}
+		if !sameRoot(now, root) {
+			cmd.roots = append(cmd.roots, rootChange{path, root, now})
+		}
+	}
+
+	if len(cmd.playlists) == 0 && len(cmd.songs) == 0 && len(cmd.roots) == 0 {
+		return
+	}
+	pm.undoStack = append(pm.undoStack, cmd)
+	if len(pm.undoStack) > UndoLimit {
+		pm.undoStack = slices.Delete(pm.undoStack, 0, len(pm.undoStack)-UndoLimit)
+	}
+	pm.redoStack = nil
+}
+
+// same reports whether two versions of a playlist hold the same, wherever
+// they are in the list of playlists.
+func (v *playlistVersion) same(w *playlistVersion) bool {
+	if v == nil || w == nil {
+		return v == w
+	}
+	if !slices.Equal(v.songIDs, w.songIDs) {
+		return false
+	}
+	return sameJSON(v.header, w.header)
+}
+
+func sameSong(a, b *models.Song) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func sameRoot(a, b *models.ScanRoot) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return sameJSON(a, b)
+}
+
+func sameJSON(a, b any) bool {
+	sumA, errA := jsonSum(a)
+	sumB, errB := jsonSum(b)
+	return errA == nil && errB == nil && sumA == sumB
+}
+
+// Undo takes back the last change that can be undone and describes it. A
+// change whose playlists, songs or folders were changed again since can't
+// be, and is dropped so the one before it can be undone next.
+func (pm *PlaylistManager) Undo() (string, error) {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	if len(pm.undoStack) == 0 {
+		return "", errNothingToUndo
+	}
+	cmd := pm.undoStack[len(pm.undoStack)-1]
+	pm.undoStack = pm.undoStack[:len(pm.undoStack)-1]
+	if err := pm.apply(cmd, true); err != nil {
+		return "", fmt.Errorf("cannot undo %s: %w", cmd.description, err)
+	}
+	pm.redoStack = append(pm.redoStack, cmd)
+	return cmd.description, nil
+}
+
+// Redo makes the last undone change again and describes it.
+func (pm *PlaylistManager) Redo() (string, error) {
+	pm.mu.Lock()
+	defer pm.commit()
+
+	if len(pm.redoStack) == 0 {
+		return "", errNothingToRedo
+	}
+	cmd := pm.redoStack[len(pm.redoStack)-1]
+	pm.redoStack = pm.redoStack[:len(pm.redoStack)-1]
+	if err := pm.apply(cmd, false); err != nil {
+		return "", fmt.Errorf("cannot redo %s: %w", cmd.description, err)
+	}
+	pm.undoStack = append(pm.undoStack, cmd)
+	return cmd.description, nil
+}
+
+// UndoState describes the changes Undo and Redo would act on next, empty
+// when there are none.
+func (pm *PlaylistManager) UndoState() (undo, redo string) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	if len(pm.undoStack) > 0 {
+		undo = pm.undoStack[len(pm.undoStack)-1].description
+	}
+	if len(pm.redoStack) > 0 {
+		redo = pm.redoStack[len(pm.redoStack)-1].description
+	}
+	return undo, redo
+}
+
+// apply puts back the versions from before a command when undoing, or from
+// after it when redoing. Nothing is changed unless everything the command
+// touched is still as it left it.
+func (pm *PlaylistManager) apply(cmd *command, undoing bool) error {
+	for _, change := range cmd.playlists {
+		from, _ := change.versions(undoing)
+		var current *playlistVersion
+		if playlist := pm.findPlaylist(change.id); playlist != nil {
+			current = newPlaylistVersion(playlist, 0)
+		}
+		if !current.same(from) {
+			return errChangedSince
+		}
+	}
+	for _, change := range cmd.songs {
+		from, _ := change.versions(undoing)
+		if !sameSong(pm.library.Get(change.id), from) {
+			return errChangedSince
+		}
+	}
+	for _, change := range cmd.roots {
+		from, _ := change.versions(undoing)
+		if !sameRoot(pm.findScanRoot(change.path), from) {
+			return errChangedSince
+		}
+	}
+
+	// Songs first, so the playlists can hold them
+	for _, change := range cmd.songs {
+		_, song := change.versions(undoing)
+		switch {
+		case song == nil:
+			pm.library.Remove(change.id)
+		case pm.library.Get(change.id) != nil:
+			pm.library.Update(change.id, func(s *models.Song) { *s = *song })
+		default:
+			copied := *song
+			pm.library.Add(&copied)
+		}
+	}
+
+	// Playlists coming back go where they were, in the order they were in
+	restored := make([]*playlistVersion, 0)
+	for _, change := range cmd.playlists {
+		pm.touchPlaylist(change.id)
+		_, version := change.versions(undoing)
+		if version == nil {
+			pm.playlists = slices.DeleteFunc(pm.playlists, func(playlist *models.Playlist) bool {
+				return playlist.ID == change.id
+			})
+			continue
+		}
+		if playlist := pm.findPlaylist(change.id); playlist != nil {
+			*playlist = *version.header
+			playlist.Songs = pm.resolveSongs(version.songIDs)
+			continue
+		}
+		restored = append(restored, version)
+	}
+	sort.Slice(restored, func(i, j int) bool { return restored[i].index < restored[j].index })
+	for _, version := range restored {
+		playlist := *version.header
+		playlist.Songs = pm.resolveSongs(version.songIDs)
+		pm.playlists = slices.Insert(pm.playlists, min(version.index, len(pm.playlists)), &playlist)
+	}
+
+	watched := pm.watchedRoots()
+	for _, change := range cmd.roots {
+		pm.touchRoot(change.path)
+		_, root := change.versions(undoing)
+		i := slices.IndexFunc(pm.roots, func(r *models.ScanRoot) bool { return r.Path == change.path })
+		switch {
+		case root == nil:
+			if i >= 0 {
+				pm.roots = slices.Delete(pm.roots, i, i+1)
+			}
+		case i >= 0:
+			pm.roots[i] = cloneScanRoot(root)
+		default:
+			pm.roots = append(pm.roots, cloneScanRoot(root))
+		}
+	}
+	pm.updateWatches(watched)
+
+	pm.refreshSmartPlaylists()
+	return nil
+}
+
+// versions returns the version a change is undone or redone from, and the
+// one it goes back to.
+func (c playlistChange) versions(undoing bool) (from, to *playlistVersion) {
+	if undoing {
+		return c.after, c.before
+	}
+	return c.before, c.after
+}
+
+func (c songChange) versions(undoing bool) (from, to *models.Song) {
+	if undoing {
+		return c.after, c.before
+	}
+	return c.before, c.after
+}
+
+func (c rootChange) versions(undoing bool) (from, to *models.ScanRoot) {
+	if undoing {
+		return c.after, c.before
+	}
+	return c.before, c.after
+}
+
+func (pm *PlaylistManager) resolveSongs(ids []string) []*models.Song {
+	songs := make([]*models.Song, 0, len(ids))
+	for _, id := range ids {
+		if song := pm.library.Get(id); song != nil {
+			songs = append(songs, song)
+		}
+	}
+	return songs
+}
+
+func cloneScanRoot(root *models.ScanRoot) *models.ScanRoot {
+	copied := *root
+	copied.Files = make(map[string]*models.FileState, len(root.Files))
+	for path, state := range root.Files {
+		file := *state
+		copied.Files[path] = &file
+	}
+	return &copied
+}
@@ -0,0 +1,113 @@
+package manager
+
+import (
+	"musicplaylist/models"
+	"musicplaylist/scanner"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// fakeWatcher notes the folders it is asked to watch.
+type fakeWatcher struct {
+	watched []string
+}
+
+func (w *fakeWatcher) Add(root string) error {
+	w.watched = append(w.watched, root)
+	return nil
+}
+
+func (w *fakeWatcher) Remove(root string) error {
+	w.watched = slices.DeleteFunc(w.watched, func(path string) bool { return path == root })
+	return nil
+}
+
+func (w *fakeWatcher) Events() <-chan []scanner.Event {
+	events := make(chan []scanner.Event)
+	close(events)
+	return events
+}
+
+func (w *fakeWatcher) Close() error {
+	return nil
+}
+
+func TestUndoAddFolderStopsWatchingIt(t *testing.T) {
+	pm := newTestManager(t)
+	watcher := &fakeWatcher{}
+	pm.WatchFolders(watcher)
+
+	dir := t.TempDir()
+	writeFLAC(t, filepath.Join(dir, "a.flac"), "first")
+	playlist := pm.CreatePlaylist("Folder", "")
+	if _, err := pm.AddFolderToPlaylist(playlist.ID, dir); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(watcher.watched, []string{dir}) {
+		t.Fatalf("watching %v after adding %s", watcher.watched, dir)
+	}
+
+	if _, err := pm.Undo(); err != nil {
+		t.Fatal(err)
+	}
+	if len(watcher.watched) != 0 {
+		t.Errorf("still watching %v after undoing adding the folder", watcher.watched)
+	}
+	if _, err := pm.Redo(); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(watcher.watched, []string{dir}) {
+		t.Errorf("watching %v after redoing adding %s", watcher.watched, dir)
+	}
+}
+
+func TestReplacingCollectionClearsUndo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "playlists.json")
+	open := testStores["json"]
+	pm := CreatePlaylistManager(open(path))
+	if err := pm.Load(); err != nil {
+		t.Fatal(err)
+	}
+	assertCleared := func(after string) {
+		t.Helper()
+		if undo, redo := pm.UndoState(); undo != "" || redo != "" {
+			t.Errorf("after %s, undo = %q and redo = %q, want nothing", after, undo, redo)
+		}
+	}
+
+	mine := pm.CreatePlaylist("Mine", "")
+	songs := testSongs(2)
+	addSong := func(song *models.Song) {
+		t.Helper()
+		if _, err := pm.AddSongToPlaylist(mine.ID, song); err != nil {
+			t.Fatal(err)
+		}
+		if undo, _ := pm.UndoState(); undo == "" {
+			t.Fatal("adding a song can't be undone")
+		}
+	}
+	addSong(songs[0])
+	if err := pm.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Load(); err != nil {
+		t.Fatal(err)
+	}
+	assertCleared("loading")
+
+	// Another process saving makes the next refresh merge its changes in
+	other := CreatePlaylistManager(open(path))
+	if err := other.Load(); err != nil {
+		t.Fatal(err)
+	}
+	other.CreatePlaylist("Theirs", "")
+	if err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+	addSong(songs[1])
+	if err := pm.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	assertCleared("merging")
+}
@@ -0,0 +1,146 @@
+package manager
+
+import (
+	"fmt"
+	"musicplaylist/models"
+	"musicplaylist/scanner"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// WatchFolders watches every scanned folder with w, and any folder scanned
+// later on, and applies the changes it reports until w is closed. New and
+// changed files land in the library and in the playlist the folder is bound
+// to; deleted files are flagged as missing rather than dropped.
+func (pm *PlaylistManager) WatchFolders(w scanner.Watcher) {
+	pm.mu.Lock()
+	pm.watcher = w
+	roots := make([]*models.ScanRoot, len(pm.roots))
+	copy(roots, pm.roots)
+	pm.mu.Unlock()
+
+	for _, root := range roots {
+		if err := w.Add(root.Path); err != nil {
+			pm.warn(fmt.Errorf("could not watch %s: %w", root.Path, err))
+		}
+	}
+
+	go func() {
+		for events := range w.Events() {
+			// Put down to the storage's actor, not to a client acting meanwhile
+			pm.ActAs("", func() {
+				pm.applyFileEvents(events)
+				if err := pm.Save(); err != nil {
+					pm.warn(fmt.Errorf("could not save folder changes: %w", err))
+				}
+			})
+		}
+	}()
+}
+
+type scannedFile struct {
+	song *models.Song
+	info os.FileInfo
+}
+
+func (pm *PlaylistManager) applyFileEvents(events []scanner.Event) {
+	// Read the files before taking the lock, this is the slow part
+	scanned := make(map[string]scannedFile)
+	for _, event := range events {
+		if event.Kind == scanner.FileRemoved {
+			continue
+		}
+		info, err := os.Stat(event.Path)
+		if err != nil {
+			continue
+		}
+		song, err := scanner.ScanFile(event.Path)
+		if err != nil {
+			pm.warn(fmt.Errorf("could not read %s: %w", event.Path, err))
+			continue
+		}
+		scanned[event.Path] = scannedFile{song: song, info: info}
+	}
+
+	pm.mu.Lock()
+	defer pm.commit()
+
+	// Removals go first so a file moved within the batch is matched to the
+	// song it was moved from
+	for _, event := range events {
+		if root := pm.rootForPath(event.Path); root != nil && event.Kind == scanner.FileRemoved {
+			pm.markMissing(root, event.Path)
+		}
+	}
+	for _, event := range events {
+		file, ok := scanned[event.Path]
+		if root := pm.rootForPath(event.Path); root != nil && ok {
+			pm.upsertWatchedFile(root, file)
+		}
+	}
+	pm.refreshSmartPlaylists()
+}
+
+func (pm *PlaylistManager) upsertWatchedFile(root *models.ScanRoot, file scannedFile) {
+	pm.touchRoot(root.Path)
+	path := file.song.FilePath
+	song := pm.library.GetByPath(path)
+
+	// A missing song whose audio turns up under a new name was moved, and
+	// takes the ID of its new path
+	if song == nil && file.song.ContentHash != "" {
+		for oldPath, state := range root.Files {
+			candidate := pm.library.Get(state.SongID)
+			if candidate != nil && candidate.Missing && state.Hash == file.song.ContentHash {
+				delete(root.Files, oldPath)
+				song = pm.moveSong(candidate.ID, path)
+				break
+			}
+		}
+	}
+
+	if song != nil {
+		pm.library.Update(song.ID, func(s *models.Song) { refreshSong(s, file.song) })
+	} else {
+		song = pm.library.Add(file.song)
+	}
+
+	if playlist := pm.findPlaylist(root.PlaylistID); playlist != nil && playlist.GetSongByID(song.ID) == nil {
+		pm.touchPlaylist(playlist.ID)
+		playlist.AddSong(song)
+	}
+
+	root.Files[path] = &models.FileState{
+		Size:    file.info.Size(),
+		ModTime: file.info.ModTime(),
+		Hash:    file.song.ContentHash,
+		SongID:  song.ID,
+	}
+}
+
+// markMissing flags the song at path, or every song below it when path was a
+// directory. The file record is kept so a later rescan can offer to remove it.
+func (pm *PlaylistManager) markMissing(root *models.ScanRoot, path string) {
+	prefix := path + string(filepath.Separator)
+	for file, state := range root.Files {
+		if file != path && !strings.HasPrefix(file, prefix) {
+			continue
+		}
+		pm.library.Update(state.SongID, func(song *models.Song) { song.Missing = true })
+	}
+}
+
+// rootForPath returns the most specific scanned folder containing path.
+func (pm *PlaylistManager) rootForPath(path string) *models.ScanRoot {
+	var best *models.ScanRoot
+	for _, root := range pm.roots {
+		if path != root.Path && !strings.HasPrefix(path, root.Path+string(filepath.Separator)) {
+			continue
+		}
+		if best == nil || len(root.Path) > len(best.Path) {
+			best = root
+		}
+	}
+	return best
+}
@@ -0,0 +1,49 @@
+package manager
+
+import (
+	"musicplaylist/models"
+	"musicplaylist/scanner"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchedMoveRekeysSong(t *testing.T) {
+	pm := newTestManager(t)
+	dir := t.TempDir()
+	oldPath := filepath.Join(dir, "a.flac")
+	newPath := filepath.Join(dir, "b.flac")
+	writeFLAC(t, oldPath, "first")
+
+	playlist := pm.CreatePlaylist("Folder", "")
+	if _, err := pm.AddFolderToPlaylist(playlist.ID, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		t.Fatal(err)
+	}
+	pm.applyFileEvents([]scanner.Event{
+		{Path: oldPath, Kind: scanner.FileRemoved},
+		{Path: newPath, Kind: scanner.FileCreated},
+	})
+
+	newID := models.SongIDForPath(newPath)
+	song := pm.library.Get(newID)
+	if song == nil || song.FilePath != newPath || song.Missing {
+		t.Fatalf("moved song = %+v, want ID %s at %s", song, newID, newPath)
+	}
+	if pm.library.Len() != 1 {
+		t.Errorf("library has %d songs, want 1", pm.library.Len())
+	}
+	if len(playlist.Songs) != 1 || playlist.Songs[0] != song {
+		t.Errorf("playlist songs = %+v, want only the moved song", playlist.Songs)
+	}
+	root := pm.findScanRoot(dir)
+	if state := root.Files[newPath]; state == nil || state.SongID != newID {
+		t.Errorf("folder record = %+v, want song %s", state, newID)
+	}
+	if _, ok := root.Files[oldPath]; ok {
+		t.Error("folder still records the old path")
+	}
+}
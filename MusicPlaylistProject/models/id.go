@@ -0,0 +1,95 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var legacyIDPattern = regexp.MustCompile(`^[SP][0-9]+$`)
+
+// SongIDForPath derives a song ID from the file's absolute path, so scanning
+// the same file twice always yields the same ID. Songs without a file get a
+// random ID instead.
+func SongIDForPath(path string) string {
+	if path == "" {
+		return "S" + newULID(time.Now())
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	sum := sha256.Sum256([]byte(filepath.Clean(path)))
+	return "S" + hex.EncodeToString(sum[:8])
+}
+
+// NewPlaylistID returns a random, time ordered ID for a playlist created at t.
+func NewPlaylistID(t time.Time) string {
+	return "P" + newULID(t)
+}
+
+// IsLegacyID reports whether id uses the old nanosecond timestamp scheme.
+func IsLegacyID(id string) bool {
+	return legacyIDPattern.MatchString(id)
+}
+
+var ulidState struct {
+	sync.Mutex
+	lastMillis uint64
+	lastRandom [10]byte
+}
+
+// newULID encodes a 48 bit millisecond timestamp and 80 random bits in
+// Crockford base32. IDs generated within the same millisecond increment the
+// random part so they stay unique and sorted.
+func newULID(t time.Time) string {
+	ulidState.Lock()
+	defer ulidState.Unlock()
+
+	millis := uint64(t.UnixMilli())
+	var random [10]byte
+	if millis == ulidState.lastMillis {
+		random = ulidState.lastRandom
+		for i := len(random) - 1; i >= 0; i-- {
+			random[i]++
+			if random[i] != 0 {
+				break
+			}
+		}
+	} else if _, err := rand.Read(random[:]); err != nil {
+		panic(err)
+	}
+	ulidState.lastMillis = millis
+	ulidState.lastRandom = random
+
+	var raw [16]byte
+	for i := 0; i < 6; i++ {
+		raw[i] = byte(millis >> (40 - 8*i))
+	}
+	copy(raw[6:], random[:])
+
+	// 128 bits into 26 characters of 5 bits, the first one only holding 3
+	out := make([]byte, 26)
+	var acc uint32
+	var bits uint
+	pos := len(out) - 1
+	for i := len(raw) - 1; i >= 0; i-- {
+		acc |= uint32(raw[i]) << bits
+		bits += 8
+		for bits >= 5 && pos >= 0 {
+			out[pos] = crockford[acc&0x1F]
+			acc >>= 5
+			bits -= 5
+			pos--
+		}
+	}
+	if pos >= 0 {
+		out[pos] = crockford[acc&0x1F]
+	}
+	return string(out)
+}
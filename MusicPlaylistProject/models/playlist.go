@@ -2,11 +2,15 @@
 package models
 
 import (
+	"errors"
 	"fmt"
 	"math/rand"
+	"slices"
 	"time"
 )
 
+var errPosition = errors.New("position out of range")
+
 type Playlist struct {
 	ID          string    `json:"id"`
 	Name        string    `json:"name"`
@@ -14,12 +18,16 @@ type Playlist struct {
 	Songs       []*Song   `json:"songs"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Smart playlists are filled from the library by their criteria, so only
+	// the criteria are stored, never the songs
+	Smart *SmartCriteria `json:"smart,omitempty"`
 }
 
 func NewPlaylist(name, description string) *Playlist {
 	now := time.Now()
 	return &Playlist{
-		ID:          generatePlaylistID(),
+		ID:          NewPlaylistID(now),
 		Name:        name,
 		Description: description,
 		Songs:       make([]*Song, 0),
@@ -28,6 +36,16 @@ func NewPlaylist(name, description string) *Playlist {
 	}
 }
 
+func NewSmartPlaylist(name, description string, criteria *SmartCriteria) *Playlist {
+	playlist := NewPlaylist(name, description)
+	playlist.Smart = criteria
+	return playlist
+}
+
+func (p *Playlist) IsSmart() bool {
+	return p.Smart != nil
+}
+
 func (p *Playlist) AddSongs(songs []*Song) {
 	p.Songs = append(p.Songs, songs...)
 	p.UpdatedAt = time.Now()
@@ -38,6 +56,71 @@ func (p *Playlist) AddSong(song *Song) {
 	p.UpdatedAt = time.Now()
 }
 
+// InsertSongs puts songs at index, 0 being the top and len(p.Songs) the end.
+func (p *Playlist) InsertSongs(index int, songs []*Song) error {
+	if index < 0 || index > len(p.Songs) {
+		return errPosition
+	}
+	p.Songs = slices.Insert(p.Songs, index, songs...)
+	p.UpdatedAt = time.Now()
+	return nil
+}
+
+// MoveSong moves the song at from so that it ends up at to.
+func (p *Playlist) MoveSong(from, to int) error {
+	return p.MoveSongs([]int{from}, to)
+}
+
+// MoveSongBy moves the song at position offset places down, or up when
+// offset is negative.
+func (p *Playlist) MoveSongBy(position, offset int) error {
+	return p.MoveSong(position, position+offset)
+}
+
+// MoveSongs takes the songs at positions out and puts them back together,
+// in the order they were in, with the first of them ending up at to.
+func (p *Playlist) MoveSongs(positions []int, to int) error {
+	selected := make([]bool, len(p.Songs))
+	count := 0
+	for _, i := range positions {
+		if i < 0 || i >= len(p.Songs) {
+			return errPosition
+		}
+		if !selected[i] {
+			selected[i] = true
+			count++
+		}
+	}
+	if count == 0 {
+		return errors.New("no songs to move")
+	}
+	if to < 0 || to > len(p.Songs)-count {
+		return errPosition
+	}
+
+	moved := make([]*Song, 0, count)
+	rest := make([]*Song, 0, len(p.Songs)-count)
+	for i, song := range p.Songs {
+		if selected[i] {
+			moved = append(moved, song)
+		} else {
+			rest = append(rest, song)
+		}
+	}
+	p.Songs = slices.Insert(rest, to, moved...)
+	p.UpdatedAt = time.Now()
+	return nil
+}
+
+func (p *Playlist) SwapSongs(i, j int) error {
+	if i < 0 || i >= len(p.Songs) || j < 0 || j >= len(p.Songs) {
+		return errPosition
+	}
+	p.Songs[i], p.Songs[j] = p.Songs[j], p.Songs[i]
+	p.UpdatedAt = time.Now()
+	return nil
+}
+
 func (p *Playlist) RemoveSong(songID string) bool {
 	for i, song := range p.Songs {
 		if song.ID == songID {
@@ -74,10 +157,10 @@ func (p *Playlist) Shuffle() {
 }
 
 func (p *Playlist) ToString() string {
+	name := p.Name
+	if p.IsSmart() {
+		name += " (smart)"
+	}
 	return fmt.Sprintf("[%s] %s - %d songs (%s total)",
-		p.ID, p.Name, len(p.Songs), formatDuration(p.TotalDuration()))
-}
-
-func generatePlaylistID() string {
-	return fmt.Sprintf("P%d", time.Now().UnixNano())
+		p.ID, name, len(p.Songs), formatDuration(p.TotalDuration()))
 }
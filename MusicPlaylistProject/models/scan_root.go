@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// ScanRoot is a folder that was added to the collection, along with the state
+// of every audio file found in it on the last scan.
+type ScanRoot struct {
+	Path        string                `json:"path"`
+	PlaylistID  string                `json:"playlist_id,omitempty"`
+	LastScanned time.Time             `json:"last_scanned"`
+	Files       map[string]*FileState `json:"files"`
+}
+
+type FileState struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash,omitempty"`
+	SongID  string    `json:"song_id"`
+}
+
+func NewScanRoot(path, playlistID string) *ScanRoot {
+	return &ScanRoot{
+		Path:       path,
+		PlaylistID: playlistID,
+		Files:      make(map[string]*FileState),
+	}
+}
@@ -0,0 +1,171 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+type RuleOp string
+
+const (
+	RuleAnd             RuleOp = "and"
+	RuleOr              RuleOp = "or"
+	RuleNot             RuleOp = "not"
+	RuleGenreEquals     RuleOp = "genre_equals"
+	RuleYearBetween     RuleOp = "year_between"
+	RuleArtistIn        RuleOp = "artist_in"
+	RuleDurationUnder   RuleOp = "duration_under"
+	RuleAddedWithinDays RuleOp = "added_within_days"
+)
+
+// Rule is a node of a smart playlist's rule tree. Groups (and, or, not) use
+// Rules; the leaf rules each read the one field that applies to them.
+type Rule struct {
+	Op       RuleOp        `json:"op"`
+	Rules    []*Rule       `json:"rules,omitempty"`
+	Value    string        `json:"value,omitempty"`
+	Values   []string      `json:"values,omitempty"`
+	Min      int           `json:"min,omitempty"`
+	Max      int           `json:"max,omitempty"`
+	Duration time.Duration `json:"duration,omitempty"`
+	Days     int           `json:"days,omitempty"`
+}
+
+func (r *Rule) Validate() error {
+	if r == nil {
+		return errors.New("missing rule")
+	}
+
+	switch r.Op {
+	case RuleAnd, RuleOr:
+		if len(r.Rules) == 0 {
+			return fmt.Errorf("%s rule needs at least one rule", r.Op)
+		}
+	case RuleNot:
+		if len(r.Rules) != 1 {
+			return errors.New("not rule needs exactly one rule")
+		}
+	case RuleGenreEquals:
+		if r.Value == "" {
+			return errors.New("genre_equals rule needs a genre")
+		}
+	case RuleYearBetween:
+		if r.Min > r.Max {
+			return errors.New("year_between rule has min above max")
+		}
+	case RuleArtistIn:
+		if len(r.Values) == 0 {
+			return errors.New("artist_in rule needs at least one artist")
+		}
+	case RuleDurationUnder:
+		if r.Duration <= 0 {
+			return errors.New("duration_under rule needs a positive duration")
+		}
+	case RuleAddedWithinDays:
+		if r.Days <= 0 {
+			return errors.New("added_within_days rule needs a positive number of days")
+		}
+	default:
+		return fmt.Errorf("unknown rule %q", r.Op)
+	}
+
+	for _, child := range r.Rules {
+		if err := child.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Rule) Matches(song *Song, now time.Time) bool {
+	switch r.Op {
+	case RuleAnd:
+		for _, child := range r.Rules {
+			if !child.Matches(song, now) {
+				return false
+			}
+		}
+		return true
+	case RuleOr:
+		for _, child := range r.Rules {
+			if child.Matches(song, now) {
+				return true
+			}
+		}
+		return false
+	case RuleNot:
+		return !r.Rules[0].Matches(song, now)
+	case RuleGenreEquals:
+		return strings.EqualFold(song.Genre, r.Value)
+	case RuleYearBetween:
+		return song.Year >= r.Min && song.Year <= r.Max
+	case RuleArtistIn:
+		for _, artist := range r.Values {
+			if strings.EqualFold(song.Artist, artist) {
+				return true
+			}
+		}
+		return false
+	case RuleDurationUnder:
+		return song.Duration < r.Duration
+	case RuleAddedWithinDays:
+		return !song.AddedAt.IsZero() && now.Sub(song.AddedAt) <= time.Duration(r.Days)*24*time.Hour
+	}
+	return false
+}
+
+// SmartCriteria defines a smart playlist: the songs matching Match, ordered by
+// SortBy or shuffled, and cut down to Limit songs when Limit is set.
+type SmartCriteria struct {
+	Match      *Rule  `json:"match"`
+	Limit      int    `json:"limit,omitempty"`
+	SortBy     string `json:"sort_by,omitempty"`
+	Descending bool   `json:"descending,omitempty"`
+	Random     bool   `json:"random,omitempty"`
+}
+
+func (c *SmartCriteria) Validate() error {
+	if c == nil {
+		return errors.New("missing smart playlist criteria")
+	}
+	if c.Limit < 0 {
+		return errors.New("limit cannot be negative")
+	}
+	if c.SortBy != "" {
+		if err := validateSortKeys(c.sortKeys()); err != nil {
+			return err
+		}
+	}
+	return c.Match.Validate()
+}
+
+func (c *SmartCriteria) sortKeys() []SortKey {
+	return []SortKey{{Field: c.SortBy, Descending: c.Descending}}
+}
+
+// Evaluate picks the songs of the smart playlist out of songs.
+func (c *SmartCriteria) Evaluate(songs []*Song, now time.Time) []*Song {
+	result := make([]*Song, 0)
+	for _, song := range songs {
+		if c.Match.Matches(song, now) {
+			result = append(result, song)
+		}
+	}
+
+	if c.Random {
+		rand.Shuffle(len(result), func(i, j int) {
+			result[i], result[j] = result[j], result[i]
+		})
+	} else if c.SortBy != "" {
+		// Validate has checked the field
+		SortSongs(result, c.sortKeys())
+	}
+
+	if c.Limit > 0 && len(result) > c.Limit {
+		result = result[:c.Limit]
+	}
+	return result
+}
@@ -3,6 +3,7 @@ package models
 
 import (
 	"fmt"
+	"musicplaylist/audio"
 	"os"
 	"path/filepath"
 	"time"
@@ -19,9 +20,19 @@ type Song struct {
 	Duration time.Duration `json:"duration"`
 	Genre    string        `json:"genre"`
 	Year     int           `json:"year"`
+	// TrackNumber is the song's place on its album, or 0 when unknown
+	TrackNumber int `json:"trackNumber,omitempty"`
+
+	PlayCount int `json:"playCount,omitempty"`
+	// Rating is in stars, 1 to 5, or 0 when unrated
+	Rating int `json:"rating,omitempty"`
+
+	ContentHash string    `json:"contentHash,omitempty"`
+	Missing     bool      `json:"missing,omitempty"`
+	AddedAt     time.Time `json:"addedAt"`
 }
 
-func NewSongFromPath(path string, duration time.Duration) (*Song, error) {
+func NewSongFromPath(path string) (*Song, error) {
 	file, err := os.Open(path)
 
 	if err != nil {
@@ -35,14 +46,22 @@ func NewSongFromPath(path string, duration time.Duration) (*Song, error) {
 		return &Song{}, err
 	}
 
+	// A file whose headers can't be probed is still a valid song, it just
+	// has no known duration.
+	duration, err := audio.ProbeDuration(file)
+	if err != nil {
+		duration = 0
+	}
+
 	title := metadata.Title()
+	track, _ := metadata.Track()
 
 	if title == "" {
 		title = filepath.Base(path)
 	}
 
 	return &Song{
-		ID:       generateID(),
+		ID:       SongIDForPath(path),
 		Title:    title,
 		Artist:   metadata.Artist(),
 		Album:    metadata.Album(),
@@ -50,6 +69,8 @@ func NewSongFromPath(path string, duration time.Duration) (*Song, error) {
 		Genre:    metadata.Genre(),
 		Year:     metadata.Year(),
 		Duration: duration,
+
+		TrackNumber: track,
 	}, nil
 
 }
@@ -63,7 +84,3 @@ func formatDuration(d time.Duration) string {
 	seconds := int(d.Seconds()) % 60
 	return fmt.Sprintf("%d:%02d", minutes, seconds)
 }
-
-func generateID() string {
-	return fmt.Sprintf("S%d", time.Now().UnixNano())
-}
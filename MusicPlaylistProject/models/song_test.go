@@ -0,0 +1,45 @@
+package models
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeFLAC writes a FLAC file holding only its STREAMINFO block, which is
+// enough for both the tag reader and the duration probe.
+func writeFLAC(t *testing.T, path string, sampleRate, totalSamples uint64) {
+	t.Helper()
+
+	info := make([]byte, 34)
+	// 20 bits sample rate, 3 bits channels - 1, 5 bits bits per sample - 1,
+	// 36 bits total samples
+	packed := sampleRate<<44 | 1<<41 | 15<<36 | totalSamples
+	binary.BigEndian.PutUint64(info[10:18], packed)
+
+	data := []byte("fLaC")
+	// Last metadata block, type 0 (STREAMINFO), 34 bytes long
+	data = append(data, 0x80, 0, 0, 34)
+	data = append(data, info...)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNewSongFromPathProbesDuration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Song.flac")
+	writeFLAC(t, path, 44100, 44100*200)
+
+	song, err := NewSongFromPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := 200 * time.Second; song.Duration != want {
+		t.Errorf("Duration = %v, want %v", song.Duration, want)
+	}
+	if song.Title != "Song.flac" {
+		t.Errorf("Title = %q, want the file name", song.Title)
+	}
+}
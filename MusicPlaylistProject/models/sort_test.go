@@ -0,0 +1,80 @@
+package models
+
+import (
+	"slices"
+	"testing"
+)
+
+// sortedTitles sorts songs with the given titles by keys and returns the
+// titles in their new order.
+func sortedTitles(t *testing.T, songs []*Song, keys []SortKey) []string {
+	t.Helper()
+	if err := SortSongs(songs, keys); err != nil {
+		t.Fatal(err)
+	}
+	titles := make([]string, len(songs))
+	for i, song := range songs {
+		titles[i] = song.Title
+	}
+	return titles
+}
+
+func titledSongs(titles ...string) []*Song {
+	songs := make([]*Song, len(titles))
+	for i, title := range titles {
+		songs[i] = &Song{ID: title, Title: title}
+	}
+	return songs
+}
+
+func TestSortSongsKeepsOrderOfEqualSongs(t *testing.T) {
+	songs := []*Song{
+		{Title: "d", Artist: "B", Year: 2001},
+		{Title: "a", Artist: "A", Year: 1999},
+		{Title: "e", Artist: "B", Year: 1990},
+		{Title: "b", Artist: "A", Year: 1999},
+		{Title: "c", Artist: "A", Year: 2010},
+	}
+	got := sortedTitles(t, slices.Clone(songs), []SortKey{{Field: "artist"}})
+	if want := []string{"a", "b", "c", "d", "e"}; !slices.Equal(got, want) {
+		t.Errorf("by artist: %v, want %v", got, want)
+	}
+	got = sortedTitles(t, slices.Clone(songs), []SortKey{{Field: "artist"}, {Field: "year", Descending: true}})
+	if want := []string{"c", "a", "b", "d", "e"}; !slices.Equal(got, want) {
+		t.Errorf("by artist then newest: %v, want %v", got, want)
+	}
+}
+
+func TestSortSongsComparesNumbersInText(t *testing.T) {
+	songs := titledSongs("Track 10", "Track 2", "Track 1", "Track 21", "Track 3")
+	got := sortedTitles(t, songs, []SortKey{{Field: "title"}})
+	if want := []string{"Track 1", "Track 2", "Track 3", "Track 10", "Track 21"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSortSongsFoldsCaseAndDiacritics(t *testing.T) {
+	songs := titledSongs("zebra", "Élan", "apple", "elan", "Ember", "ELAN", "éclair")
+	got := sortedTitles(t, songs, []SortKey{{Field: "title"}})
+	// Spellings of the same word compare equal and keep their order
+	if want := []string{"apple", "éclair", "Élan", "elan", "ELAN", "Ember", "zebra"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParseSortKeys(t *testing.T) {
+	keys, err := ParseSortKeys(" Artist, -year ,title,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SortKey{{Field: "artist"}, {Field: "year", Descending: true}, {Field: "title"}}
+	if !slices.Equal(keys, want) {
+		t.Errorf("got %v, want %v", keys, want)
+	}
+
+	for _, list := range []string{"", " , ", "artist,mood"} {
+		if keys, err := ParseSortKeys(list); err == nil {
+			t.Errorf("ParseSortKeys(%q) = %v, want an error", list, keys)
+		}
+	}
+}
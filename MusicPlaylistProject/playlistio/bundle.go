@@ -0,0 +1,436 @@
+package playlistio
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"musicplaylist/models"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// DefaultBundleTemplate names bundled files when no template is given.
+const DefaultBundleTemplate = "{index:02} - {artist} - {title}{ext}"
+
+type BundleOptions struct {
+	// Template names each file. Fields in braces are replaced by the song's
+	// details: {index}, {title}, {artist}, {album}, {genre}, {year}, {name}
+	// for the original file name and {ext} for its extension, dot included.
+	// {index:03} pads the number with zeros. A '/' starts a subfolder.
+	Template string
+	// Hardlink links songs into a directory instead of copying them, where
+	// the target is on the same drive. Ignored for zip files.
+	Hardlink bool
+	// MaxBytes stops the export at the first song that would take it past
+	// this many bytes. 0 means no limit.
+	MaxBytes int64
+	// PlaylistFormat is the format of the playlist written beside the songs,
+	// M3U8 by default
+	PlaylistFormat Format
+	// Progress is called after every song, skipped ones included
+	Progress func(BundleProgress)
+}
+
+type BundleProgress struct {
+	Done  int          `json:"done"`
+	Total int          `json:"total"`
+	Song  *models.Song `json:"song"`
+	// File is the song's name in the bundle, empty if it was skipped
+	File  string `json:"file"`
+	Bytes int64  `json:"bytes"`
+}
+
+type BundleResult struct {
+	Files        []string `json:"files"`
+	PlaylistFile string   `json:"playlistFile"`
+	Bytes        int64    `json:"bytes"`
+	// Skipped are the songs whose file couldn't be read, by playlist position
+	Skipped []Unresolved `json:"skipped"`
+	// Left is how many songs didn't fit in MaxBytes
+	Left int `json:"left"`
+}
+
+// ExportBundle copies the songs of playlist into target, a directory or a
+// .zip file, with a playlist file beside them that finds them by relative
+// path. Names are made safe for FAT32, which most players and memory cards
+// use. Songs stay in playlist order, so a size budget keeps the start of the
+// playlist.
+func ExportBundle(playlist *models.Playlist, target string, opts BundleOptions) (*BundleResult, error) {
+	if opts.Template == "" {
+		opts.Template = DefaultBundleTemplate
+	}
+	tmpl, err := parseBundleTemplate(opts.Template)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PlaylistFormat == "" {
+		opts.PlaylistFormat = FormatM3U8
+	}
+
+	// Songs are named relative to root, where a zip would be unpacked to
+	root := strings.TrimSuffix(target, filepath.Ext(target))
+	var sink bundleSink
+	if strings.EqualFold(filepath.Ext(target), ".zip") {
+		sink, err = newZipSink(target)
+	} else {
+		root = target
+		sink, err = newDirSink(target, opts.Hardlink)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if root, err = filepath.Abs(root); err != nil {
+		sink.abort()
+		return nil, err
+	}
+
+	songs := append([]*models.Song(nil), playlist.Songs...)
+	bundled := &models.Playlist{Name: playlist.Name, Description: playlist.Description}
+	result := &BundleResult{Files: make([]string, 0, len(songs)), Skipped: make([]Unresolved, 0)}
+	used := make(map[string]bool)
+
+	for i, song := range songs {
+		progress := BundleProgress{Done: i + 1, Total: len(songs), Song: song}
+
+		info, err := os.Stat(song.FilePath)
+		if err == nil && !info.Mode().IsRegular() {
+			err = fmt.Errorf("%s is not a file", song.FilePath)
+		}
+		if err != nil {
+			result.Skipped = append(result.Skipped, Unresolved{Line: i + 1, Path: song.FilePath, Reason: err.Error()})
+			progress.Bytes = result.Bytes
+			opts.report(progress)
+			continue
+		}
+		if opts.MaxBytes > 0 && result.Bytes+info.Size() > opts.MaxBytes {
+			result.Left = len(songs) - i
+			break
+		}
+
+		name := uniqueName(tmpl.expand(len(result.Files)+1, song), used)
+		if err := sink.addFile(name, song.FilePath, info); err != nil {
+			sink.abort()
+			return nil, fmt.Errorf("failed to export %s: %w", song.FilePath, err)
+		}
+
+		result.Files = append(result.Files, name)
+		result.Bytes += info.Size()
+		copied := *song
+		copied.FilePath = filepath.Join(root, filepath.FromSlash(name))
+		bundled.Songs = append(bundled.Songs, &copied)
+
+		progress.File = name
+		progress.Bytes = result.Bytes
+		opts.report(progress)
+	}
+
+	var list bytes.Buffer
+	err = Write(&list, opts.PlaylistFormat, bundled, Options{Paths: RelativePaths, BaseDir: root})
+	if err == nil {
+		result.PlaylistFile = uniqueName(fatName(playlist.Name)+opts.PlaylistFormat.Extension(), used)
+		err = sink.addBytes(result.PlaylistFile, list.Bytes())
+	}
+	if err != nil {
+		sink.abort()
+		return nil, err
+	}
+	return result, sink.close()
+}
+
+func (opts BundleOptions) report(progress BundleProgress) {
+	if opts.Progress != nil {
+		opts.Progress(progress)
+	}
+}
+
+// uniqueName numbers a name already used in the bundle. FAT32 ignores case,
+// so names are compared without it.
+func uniqueName(name string, used map[string]bool) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for n := 2; used[strings.ToLower(candidate)]; n++ {
+		candidate = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
+
+type templatePart struct {
+	literal string
+	field   string
+	width   int
+}
+
+type bundleTemplate []templatePart
+
+var templateFields = map[string]bool{
+	"index": true, "title": true, "artist": true, "album": true,
+	"genre": true, "year": true, "name": true, "ext": true,
+}
+
+func parseBundleTemplate(text string) (bundleTemplate, error) {
+	var tmpl bundleTemplate
+	for text != "" {
+		open := strings.IndexByte(text, '{')
+		if open < 0 {
+			tmpl = append(tmpl, templatePart{literal: text})
+			break
+		}
+		if open > 0 {
+			tmpl = append(tmpl, templatePart{literal: text[:open]})
+		}
+		end := strings.IndexByte(text[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("template has an unclosed '{'")
+		}
+
+		field, spec, hasSpec := strings.Cut(text[open+1:open+end], ":")
+		if !templateFields[field] {
+			return nil, fmt.Errorf("unknown template field {%s}", field)
+		}
+		part := templatePart{field: field}
+		if hasSpec {
+			width, err := strconv.Atoi(spec)
+			if err != nil || width < 1 || (field != "index" && field != "year") {
+				return nil, fmt.Errorf("bad template field {%s:%s}, only numbers take a width such as {index:02}", field, spec)
+			}
+			part.width = width
+		}
+		tmpl = append(tmpl, part)
+		text = text[open+end+1:]
+	}
+	return tmpl, nil
+}
+
+// expand names a song's file, using forward slashes between folders.
+func (t bundleTemplate) expand(index int, song *models.Song) string {
+	ext := filepath.Ext(song.FilePath)
+	original := strings.TrimSuffix(filepath.Base(song.FilePath), ext)
+
+	var b strings.Builder
+	for _, part := range t {
+		if part.field == "" {
+			b.WriteString(part.literal)
+			continue
+		}
+		var value string
+		switch part.field {
+		case "index":
+			value = fmt.Sprintf("%0*d", part.width, index)
+		case "year":
+			if song.Year > 0 {
+				value = fmt.Sprintf("%0*d", part.width, song.Year)
+			}
+		case "title":
+			value = orDefault(song.Title, original)
+		case "artist":
+			value = orDefault(song.Artist, "Unknown Artist")
+		case "album":
+			value = orDefault(song.Album, "Unknown Album")
+		case "genre":
+			value = song.Genre
+		case "name":
+			value = original
+		case "ext":
+			value = ext
+		}
+		// A slash in a value is part of the name, not a folder
+		b.WriteString(strings.NewReplacer("/", "_", `\`, "_").Replace(value))
+	}
+
+	segments := strings.Split(strings.ReplaceAll(b.String(), `\`, "/"), "/")
+	clean := segments[:0]
+	for _, segment := range segments {
+		if strings.TrimSpace(segment) != "" {
+			clean = append(clean, fatName(segment))
+		}
+	}
+	if len(clean) == 0 {
+		return fatName(original + ext)
+	}
+	return strings.Join(clean, "/")
+}
+
+func orDefault(value, fallback string) string {
+	if strings.TrimSpace(value) == "" {
+		return fallback
+	}
+	return value
+}
+
+// Device names FAT32 won't allow as file names, with or without an extension
+var fatReserved = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// fatMaxName is the longest FAT32 long file name, in UTF-16 code units.
+const fatMaxName = 255
+
+// fatName makes one file or folder name valid on FAT32.
+func fatName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`<>:"/\|?*`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.TrimRight(strings.TrimSpace(name), ". ")
+	if name == "" {
+		name = "_"
+	}
+
+	ext := filepath.Ext(name)
+	if fatReserved[strings.ToUpper(strings.TrimSuffix(name, ext))] {
+		name = "_" + name
+	}
+
+	if len(utf16.Encode([]rune(name))) <= fatMaxName {
+		return name
+	}
+	keep := fatMaxName - len(utf16.Encode([]rune(ext)))
+	runes := []rune(strings.TrimSuffix(name, ext))
+	for len(utf16.Encode(runes)) > keep {
+		runes = runes[:len(runes)-1]
+	}
+	return strings.TrimRight(string(runes), ". ") + ext
+}
+
+// bundleSink is where exported files go. Names use forward slashes.
+type bundleSink interface {
+	addFile(name, src string, info os.FileInfo) error
+	addBytes(name string, data []byte) error
+	close() error
+	// abort gives up on the export, removing what it can
+	abort()
+}
+
+type dirSink struct {
+	root     string
+	hardlink bool
+}
+
+func newDirSink(root string, hardlink bool) (*dirSink, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &dirSink{root: root, hardlink: hardlink}, nil
+}
+
+func (d *dirSink) destination(name string) (string, error) {
+	dst := filepath.Join(d.root, filepath.FromSlash(name))
+	return dst, os.MkdirAll(filepath.Dir(dst), 0755)
+}
+
+func (d *dirSink) addFile(name, src string, info os.FileInfo) error {
+	dst, err := d.destination(name)
+	if err != nil {
+		return err
+	}
+	if d.hardlink {
+		os.Remove(dst)
+		// Linking fails across drives, copying always works
+		if os.Link(src, dst) == nil {
+			return nil
+		}
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	// Keeping the date lets sync tools tell the copy is up to date
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+func (d *dirSink) addBytes(name string, data []byte) error {
+	dst, err := d.destination(name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0644)
+}
+
+func (d *dirSink) close() error {
+	return nil
+}
+
+// abort leaves the copied files in place, a partial export is still usable.
+func (d *dirSink) abort() {}
+
+type zipSink struct {
+	path string
+	file *os.File
+	zw   *zip.Writer
+}
+
+func newZipSink(path string) (*zipSink, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &zipSink{path: path, file: file, zw: zip.NewWriter(file)}, nil
+}
+
+func (z *zipSink) addFile(name, src string, info os.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// Audio is compressed already, storing it as is saves time
+	w, err := z.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: info.ModTime()})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
+
+func (z *zipSink) addBytes(name string, data []byte) error {
+	w, err := z.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (z *zipSink) close() error {
+	err := z.zw.Close()
+	if closeErr := z.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(z.path)
+	}
+	return err
+}
+
+func (z *zipSink) abort() {
+	z.file.Close()
+	os.Remove(z.path)
+}
@@ -0,0 +1,47 @@
+package playlistio
+
+import (
+	"musicplaylist/models"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBundlePlaylistFindsFilesNamedWithHash(t *testing.T) {
+	src := t.TempDir()
+	playlist := models.NewPlaylist("Hits", "")
+	for _, title := range []string{"#1 Hit", "Second"} {
+		path := filepath.Join(src, title+".mp3")
+		if err := os.WriteFile(path, []byte(title), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		playlist.AddSong(&models.Song{ID: title, Title: title, FilePath: path})
+	}
+
+	target := filepath.Join(t.TempDir(), "bundle")
+	result, err := ExportBundle(playlist, target, BundleOptions{Template: "{title}{ext}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.Open(filepath.Join(target, result.PlaylistFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, entries, err := ReadM3U(file, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(result.Files) {
+		t.Fatalf("read %d entries, want %d", len(entries), len(result.Files))
+	}
+	for i, name := range result.Files {
+		if want := filepath.Join(target, name); entries[i].Path != want {
+			t.Errorf("entry %d path = %s, want %s", i, entries[i].Path, want)
+		}
+		if entries[i].Title != playlist.Songs[i].Title {
+			t.Errorf("entry %d title = %q, want %q", i, entries[i].Title, playlist.Songs[i].Title)
+		}
+	}
+}
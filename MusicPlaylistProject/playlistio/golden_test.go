@@ -0,0 +1,128 @@
+package playlistio
+
+import (
+	"bytes"
+	"flag"
+	"musicplaylist/models"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenPlaylist has names that need escaping in XML and in URLs, and paths
+// outside ASCII.
+func goldenPlaylist() *models.Playlist {
+	playlist := &models.Playlist{Name: `Rock & Roll <Live> "Best"`, Description: "Tom's picks & more"}
+	playlist.AddSongs([]*models.Song{
+		{
+			ID:       "1",
+			Title:    "Mrs. Robinson <Live>",
+			Artist:   "Simon & Garfunkel",
+			Album:    `"Bookends"`,
+			FilePath: "/music/Simon & Garfunkel/100% #1 Hits/Mrs Robinson.mp3",
+			Duration: 245 * time.Second,
+		},
+		{
+			ID:       "2",
+			Title:    "Jóga",
+			Artist:   "Björk",
+			Album:    "Homogenic",
+			FilePath: "/music/Björk/Homogénic/02 Jóga.flac",
+			Duration: 305500 * time.Millisecond,
+		},
+		{
+			ID:       "3",
+			Title:    "Merry Christmas Mr. Lawrence",
+			Artist:   "坂本龍一",
+			FilePath: "/music/坂本龍一/戦場のメリークリスマス.m4a",
+		},
+	})
+	return playlist
+}
+
+// checkGolden compares what was written with testdata/name, or rewrites the
+// file with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from what was written:\n%s", path, got)
+	}
+}
+
+func readGolden(t *testing.T, name string, format Format) (string, []Entry) {
+	t.Helper()
+	file, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	title, entries, err := Read(file, format, "/elsewhere")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return title, entries
+}
+
+func TestGoldenFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the golden files hold Unix paths")
+	}
+	playlist := goldenPlaylist()
+
+	tests := []struct {
+		file   string
+		format Format
+		// PLS has no playlist name or album
+		name  string
+		album bool
+	}{
+		{"golden.xspf", FormatXSPF, playlist.Name, true},
+		{"golden.pls", FormatPLS, "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, test.format, playlist, Options{}); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, test.file, buf.Bytes())
+
+			name, entries := readGolden(t, test.file, test.format)
+			if name != test.name {
+				t.Errorf("name = %q, want %q", name, test.name)
+			}
+			if len(entries) != len(playlist.Songs) {
+				t.Fatalf("read %d entries, want %d", len(entries), len(playlist.Songs))
+			}
+			for i, song := range playlist.Songs {
+				want := Entry{Path: song.FilePath, Title: song.Title, Artist: song.Artist, Duration: song.Duration}
+				if test.album {
+					want.Album = song.Album
+				}
+				if test.format == FormatPLS {
+					// PLS rounds to whole seconds
+					want.Duration = want.Duration.Round(time.Second)
+				}
+				got := entries[i]
+				got.Line = 0
+				if got != want {
+					t.Errorf("entry %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
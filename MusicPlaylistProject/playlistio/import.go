@@ -0,0 +1,55 @@
+package playlistio
+
+import (
+	"io"
+	"musicplaylist/models"
+)
+
+// Resolver turns a path read from a playlist file into a song.
+type Resolver func(path string) (*models.Song, error)
+
+// Unresolved is an entry of an imported playlist that didn't lead to a song.
+type Unresolved struct {
+	Line   int    `json:"line"`
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type ImportResult struct {
+	// Name is the playlist's name if the file gave one
+	Name       string         `json:"name"`
+	Songs      []*models.Song `json:"songs"`
+	Unresolved []Unresolved   `json:"unresolved"`
+}
+
+// Import reads a playlist file and resolves its entries to songs with
+// resolve, or with models.NewSongFromPath when resolve is nil. Entries that
+// can't be resolved are reported rather than failing the import.
+func Import(r io.Reader, format Format, baseDir string, resolve Resolver) (*ImportResult, error) {
+	name, entries, err := Read(r, format, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	if resolve == nil {
+		resolve = models.NewSongFromPath
+	}
+
+	result := &ImportResult{
+		Name:       name,
+		Songs:      make([]*models.Song, 0, len(entries)),
+		Unresolved: make([]Unresolved, 0),
+	}
+	for _, entry := range entries {
+		song, err := resolve(entry.Path)
+		if err != nil {
+			result.Unresolved = append(result.Unresolved, Unresolved{
+				Line:   entry.Line,
+				Path:   entry.Path,
+				Reason: err.Error(),
+			})
+			continue
+		}
+		result.Songs = append(result.Songs, song)
+	}
+	return result, nil
+}
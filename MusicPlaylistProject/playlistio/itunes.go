@@ -0,0 +1,161 @@
+package playlistio
+
+import (
+	"errors"
+	"io"
+	"musicplaylist/models"
+	"os"
+	"sort"
+	"time"
+)
+
+// ITunesLibrary is what an iTunes or Music.app "Library.xml" export holds.
+type ITunesLibrary struct {
+	// Songs are in the order iTunes numbered them
+	Songs []*models.Song
+	// Playlists are the user's playlists in the order iTunes lists them,
+	// holding songs from Songs
+	Playlists []*models.Playlist
+	// Missing are the songs whose file no longer exists. They are in Songs
+	// too, marked Missing.
+	Missing []*models.Song
+	// Skipped names the tracks with no local file, such as streams and songs
+	// only in the cloud
+	Skipped []string
+}
+
+// ReadITunesLibrary reads a library exported from iTunes or Music.app. Song
+// details come from the export rather than from the files, which may no
+// longer exist.
+func ReadITunesLibrary(r io.Reader) (*ITunesLibrary, error) {
+	root, err := decodePlist(r)
+	if err != nil {
+		return nil, err
+	}
+	dict, ok := root.(map[string]any)
+	if !ok {
+		return nil, errors.New("not an iTunes library")
+	}
+	tracks, ok := dict["Tracks"].(map[string]any)
+	if !ok {
+		return nil, errors.New("not an iTunes library: no Tracks")
+	}
+
+	lib := &ITunesLibrary{
+		Songs:     make([]*models.Song, 0, len(tracks)),
+		Playlists: make([]*models.Playlist, 0),
+		Missing:   make([]*models.Song, 0),
+		Skipped:   make([]string, 0),
+	}
+
+	type numbered struct {
+		id    int64
+		track map[string]any
+	}
+	ordered := make([]numbered, 0, len(tracks))
+	for _, value := range tracks {
+		if track, ok := value.(map[string]any); ok {
+			ordered = append(ordered, numbered{plistInt(track, "Track ID"), track})
+		}
+	}
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].id < ordered[j].id })
+
+	byTrackID := make(map[int64]*models.Song, len(ordered))
+	for _, t := range ordered {
+		song, ok := iTunesSong(t.track)
+		if !ok {
+			lib.Skipped = append(lib.Skipped, plistString(t.track, "Name"))
+			continue
+		}
+		if _, err := os.Stat(song.FilePath); err != nil {
+			song.Missing = true
+			lib.Missing = append(lib.Missing, song)
+		}
+		byTrackID[t.id] = song
+		lib.Songs = append(lib.Songs, song)
+	}
+
+	playlists, _ := dict["Playlists"].([]any)
+	for _, value := range playlists {
+		list, ok := value.(map[string]any)
+		if !ok || !isUserPlaylist(list) {
+			continue
+		}
+
+		description := plistString(list, "Description")
+		if description == "" {
+			description = "Imported from iTunes"
+		}
+		playlist := models.NewPlaylist(plistString(list, "Name"), description)
+
+		items, _ := list["Playlist Items"].([]any)
+		songs := make([]*models.Song, 0, len(items))
+		for _, item := range items {
+			if item, ok := item.(map[string]any); ok {
+				if song := byTrackID[plistInt(item, "Track ID")]; song != nil {
+					songs = append(songs, song)
+				}
+			}
+		}
+		playlist.AddSongs(songs)
+		lib.Playlists = append(lib.Playlists, playlist)
+	}
+	return lib, nil
+}
+
+// iTunesSong builds a song from a track, unless it has no local file.
+func iTunesSong(track map[string]any) (*models.Song, bool) {
+	path, ok := fileURLPath(plistString(track, "Location"))
+	if !ok {
+		return nil, false
+	}
+
+	song := &models.Song{
+		ID:        models.SongIDForPath(path),
+		FilePath:  path,
+		Title:     plistString(track, "Name"),
+		Artist:    plistString(track, "Artist"),
+		Album:     plistString(track, "Album"),
+		Genre:     plistString(track, "Genre"),
+		Year:      int(plistInt(track, "Year")),
+		Duration:  time.Duration(plistInt(track, "Total Time")) * time.Millisecond,
+		PlayCount: int(plistInt(track, "Play Count")),
+
+		TrackNumber: int(plistInt(track, "Track Number")),
+	}
+	// iTunes rates out of 100; a computed rating is the album's, not the song's
+	if computed, _ := track["Rating Computed"].(bool); !computed {
+		song.Rating = int(plistInt(track, "Rating")) / 20
+	}
+	if added, ok := track["Date Added"].(time.Time); ok {
+		song.AddedAt = added
+	}
+	return song, true
+}
+
+// isUserPlaylist leaves out the whole-library list, built-in lists such as
+// Music and Podcasts, folders and hidden lists.
+func isUserPlaylist(list map[string]any) bool {
+	for _, key := range []string{"Master", "Folder"} {
+		if flag, _ := list[key].(bool); flag {
+			return false
+		}
+	}
+	if _, builtIn := list["Distinguished Kind"]; builtIn {
+		return false
+	}
+	if visible, ok := list["Visible"].(bool); ok && !visible {
+		return false
+	}
+	return true
+}
+
+func plistString(dict map[string]any, key string) string {
+	s, _ := dict[key].(string)
+	return s
+}
+
+func plistInt(dict map[string]any, key string) int64 {
+	n, _ := dict[key].(int64)
+	return n
+}
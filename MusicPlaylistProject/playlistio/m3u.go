@@ -0,0 +1,114 @@
+package playlistio
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"musicplaylist/models"
+	"strings"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+)
+
+// WriteM3U writes playlist as an extended M3U. In legacy mode a song whose
+// path can't be written in Windows-1252 is an error, since the player would
+// not find it; titles are written with unknown characters replaced.
+func WriteM3U(w io.Writer, playlist *models.Playlist, opts Options) error {
+	var buf bytes.Buffer
+	buf.WriteString("#EXTM3U\n")
+	if playlist.Name != "" {
+		buf.WriteString("#PLAYLIST:" + singleLine(playlist.Name) + "\n")
+	}
+
+	for _, song := range playlist.Songs {
+		fmt.Fprintf(&buf, "#EXTINF:%d,%s\n", lengthSeconds(song.Duration), singleLine(displayTitle(song)))
+		buf.WriteString(m3uPath(exportPath(song.FilePath, opts.Paths, opts.BaseDir)) + "\n")
+	}
+
+	if !opts.Legacy {
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+	return writeWindows1252(w, buf.String())
+}
+
+// m3uPath keeps a relative path starting with # from being read back as a
+// comment line.
+func m3uPath(path string) string {
+	if strings.HasPrefix(path, "#") {
+		return "./" + path
+	}
+	return path
+}
+
+func writeWindows1252(w io.Writer, text string) error {
+	strict := charmap.Windows1252.NewEncoder()
+	lenient := encoding.ReplaceUnsupported(charmap.Windows1252.NewEncoder())
+
+	bw := bufio.NewWriter(w)
+	for _, line := range strings.SplitAfter(text, "\n") {
+		encoder := lenient
+		if !strings.HasPrefix(line, "#") {
+			encoder = strict
+		}
+		encoded, err := encoder.String(line)
+		if err != nil {
+			return fmt.Errorf("%q cannot be written to a legacy .m3u, export as .m3u8 instead", strings.TrimSpace(line))
+		}
+		if _, err := bw.WriteString(encoded); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// ReadM3U reads the entries of an M3U or M3U8 file, in UTF-8 or
+// Windows-1252. "Artist - Title" descriptions are split back into their
+// parts. Relative paths are resolved against baseDir.
+func ReadM3U(r io.Reader, baseDir string) (string, []Entry, error) {
+	text, err := readText(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var name string
+	var entries []Entry
+	var pending Entry
+
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			pending = parseExtinf(line)
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#"):
+			// #EXTM3U and directives we don't use
+		default:
+			pending.Line = i + 1
+			pending.Path = resolvePath(line, baseDir)
+			entries = append(entries, pending)
+			pending = Entry{}
+		}
+	}
+	return name, entries, nil
+}
+
+func parseExtinf(line string) Entry {
+	var entry Entry
+	info := strings.TrimPrefix(line, "#EXTINF:")
+	seconds, title, found := strings.Cut(info, ",")
+	if !found {
+		return entry
+	}
+	entry.Artist, entry.Title = splitDisplayTitle(strings.TrimSpace(title))
+
+	// the length may be followed by attributes, as in #EXTINF:123 tvg-id="x",
+	if fields := strings.Fields(seconds); len(fields) > 0 {
+		entry.Duration = parseSeconds(fields[0])
+	}
+	return entry
+}
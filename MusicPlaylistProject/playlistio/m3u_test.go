@@ -0,0 +1,54 @@
+package playlistio
+
+import (
+	"bytes"
+	"musicplaylist/models"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestM3URoundTripsPathsStartingWithHash(t *testing.T) {
+	dir := t.TempDir()
+	playlist := models.NewPlaylist("Hits", "")
+	playlist.AddSongs([]*models.Song{
+		{ID: "1", Title: "Number One", Artist: "Band", FilePath: filepath.Join(dir, "#1 Hit.mp3"), Duration: 200 * time.Second},
+		{ID: "2", Title: "Second", Artist: "Band", FilePath: filepath.Join(dir, "second.mp3"), Duration: 100 * time.Second},
+	})
+
+	for _, legacy := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := WriteM3U(&buf, playlist, Options{Paths: RelativePaths, BaseDir: dir, Legacy: legacy}); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(buf.String(), "\n./#1 Hit.mp3\n") {
+			t.Errorf("legacy %v: path starting with # not written as ./#...:\n%s", legacy, buf.String())
+		}
+
+		_, entries, err := ReadM3U(&buf, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != len(playlist.Songs) {
+			t.Fatalf("legacy %v: read %d entries, want %d", legacy, len(entries), len(playlist.Songs))
+		}
+		for i, song := range playlist.Songs {
+			entry := entries[i]
+			if entry.Path != song.FilePath || entry.Title != song.Title || entry.Duration != song.Duration {
+				t.Errorf("legacy %v: entry %d = %+v, want %s at %s", legacy, i, entry, song.Title, song.FilePath)
+			}
+		}
+	}
+}
+
+func TestLegacyM3URejectsUnencodablePathStartingWithHash(t *testing.T) {
+	dir := t.TempDir()
+	playlist := models.NewPlaylist("Hits", "")
+	playlist.AddSong(&models.Song{ID: "1", Title: "Song", FilePath: filepath.Join(dir, "#日本.mp3")})
+
+	var buf bytes.Buffer
+	if err := WriteM3U(&buf, playlist, Options{Paths: RelativePaths, BaseDir: dir, Legacy: true}); err == nil {
+		t.Errorf("wrote a path Windows-1252 can't hold:\n%s", buf.String())
+	}
+}
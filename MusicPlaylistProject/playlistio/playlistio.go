@@ -0,0 +1,208 @@
+// Package playlistio moves playlists in and out of the formats other players
+// understand.
+package playlistio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"musicplaylist/models"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Format is a playlist file format, named by its usual file extension.
+type Format string
+
+const (
+	FormatM3U8 Format = "m3u8"
+	// FormatM3U is M3U in Windows-1252, for older players and car stereos
+	FormatM3U  Format = "m3u"
+	FormatXSPF Format = "xspf"
+	FormatPLS  Format = "pls"
+)
+
+// ParseFormat accepts a format name or extension, such as "xspf" or ".xspf".
+func ParseFormat(name string) (Format, error) {
+	format := Format(strings.ToLower(strings.TrimPrefix(name, ".")))
+	switch format {
+	case FormatM3U8, FormatM3U, FormatXSPF, FormatPLS:
+		return format, nil
+	}
+	return "", fmt.Errorf("unsupported playlist format %q", name)
+}
+
+// FormatForPath picks the format from a file name's extension.
+func FormatForPath(path string) (Format, error) {
+	return ParseFormat(filepath.Ext(path))
+}
+
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatM3U:
+		return "audio/x-mpegurl"
+	case FormatXSPF:
+		return "application/xspf+xml"
+	case FormatPLS:
+		return "audio/x-scpls"
+	}
+	return "audio/x-mpegurl; charset=utf-8"
+}
+
+// PathStyle is how song paths are written into an exported playlist.
+type PathStyle int
+
+const (
+	AbsolutePaths PathStyle = iota
+	// RelativePaths writes paths relative to the folder the playlist file is
+	// saved in, so the playlist and its music can be moved together.
+	RelativePaths
+)
+
+type Options struct {
+	Paths PathStyle
+	// BaseDir is the folder the playlist file will live in, used for
+	// RelativePaths. Songs that can't be reached from it keep absolute paths.
+	BaseDir string
+	// Legacy writes a plain .m3u in Windows-1252 instead of UTF-8 .m3u8.
+	// Only used by WriteM3U.
+	Legacy bool
+}
+
+// Write writes playlist in the given format.
+func Write(w io.Writer, format Format, playlist *models.Playlist, opts Options) error {
+	switch format {
+	case FormatM3U8, FormatM3U:
+		opts.Legacy = format == FormatM3U
+		return WriteM3U(w, playlist, opts)
+	case FormatXSPF:
+		return WriteXSPF(w, playlist, opts)
+	case FormatPLS:
+		return WritePLS(w, playlist, opts)
+	}
+	return fmt.Errorf("unsupported playlist format %q", format)
+}
+
+// Read reads the entries of a playlist file in the given format, returning
+// the playlist's name if the file gives one.
+func Read(r io.Reader, format Format, baseDir string) (string, []Entry, error) {
+	switch format {
+	case FormatM3U8, FormatM3U:
+		return ReadM3U(r, baseDir)
+	case FormatXSPF:
+		return ReadXSPF(r, baseDir)
+	case FormatPLS:
+		return ReadPLS(r, baseDir)
+	}
+	return "", nil, fmt.Errorf("unsupported playlist format %q", format)
+}
+
+// Entry is one song of a playlist file, with what the file says about it.
+// Formats carry different amounts of metadata, so any field but Path may be
+// empty.
+type Entry struct {
+	// Line is where the entry starts in the file
+	Line     int
+	Path     string
+	Title    string
+	Artist   string
+	Album    string
+	Duration time.Duration
+}
+
+// Song is the song as the playlist file describes it, without reading the
+// audio file.
+func (e Entry) Song() *models.Song {
+	return &models.Song{
+		ID:       models.SongIDForPath(e.Path),
+		FilePath: e.Path,
+		Title:    e.Title,
+		Artist:   e.Artist,
+		Album:    e.Album,
+		Duration: e.Duration,
+	}
+}
+
+// readText reads a text playlist. It is taken as UTF-8 when it is valid UTF-8
+// and as Windows-1252 otherwise, which covers files written by older software.
+func readText(r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		if data, err = charmap.Windows1252.NewDecoder().Bytes(data); err != nil {
+			return "", err
+		}
+	}
+	return string(data), nil
+}
+
+// displayTitle is the "Artist - Title" line M3U and PLS use to describe a
+// song.
+func displayTitle(song *models.Song) string {
+	if song.Artist == "" {
+		return song.Title
+	}
+	return song.Artist + " - " + song.Title
+}
+
+// splitDisplayTitle undoes displayTitle. A title without " - " is taken to
+// have no artist.
+func splitDisplayTitle(s string) (artist, title string) {
+	if artist, title, found := strings.Cut(s, " - "); found {
+		return strings.TrimSpace(artist), strings.TrimSpace(title)
+	}
+	return "", s
+}
+
+// lengthSeconds is a song length as M3U and PLS write it, -1 when unknown.
+func lengthSeconds(d time.Duration) int {
+	if d <= 0 {
+		return -1
+	}
+	return int(d.Round(time.Second) / time.Second)
+}
+
+func parseSeconds(s string) time.Duration {
+	n, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return time.Duration(n * float64(time.Second))
+}
+
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+// exportPath writes path for a playlist file stored in baseDir.
+func exportPath(path string, style PathStyle, baseDir string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if style != RelativePaths || baseDir == "" {
+		return path
+	}
+
+	base, err := filepath.Abs(baseDir)
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return path
+	}
+	// Forward slashes are understood by players on every platform
+	return filepath.ToSlash(rel)
+}
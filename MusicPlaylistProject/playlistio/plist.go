@@ -0,0 +1,108 @@
+package playlistio
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// decodePlist reads an XML property list into plain Go values: dicts become
+// map[string]any, arrays []any, integers int64, reals float64, booleans bool,
+// dates time.Time and data []byte.
+func decodePlist(r io.Reader) (any, error) {
+	dec := xml.NewDecoder(r)
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("property list is empty")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local != "plist" {
+			return decodePlistValue(dec, start)
+		}
+	}
+}
+
+func decodePlistValue(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	switch start.Name.Local {
+	case "dict":
+		return decodePlistDict(dec)
+	case "array":
+		return decodePlistArray(dec)
+	case "true", "false":
+		return start.Name.Local == "true", dec.Skip()
+	}
+
+	var text string
+	if err := dec.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+	text = strings.TrimSpace(text)
+
+	switch start.Name.Local {
+	case "string":
+		return text, nil
+	case "integer":
+		return strconv.ParseInt(text, 10, 64)
+	case "real":
+		return strconv.ParseFloat(text, 64)
+	case "date":
+		return time.Parse(time.RFC3339, text)
+	case "data":
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(text), ""))
+	}
+	return nil, fmt.Errorf("unknown property list element <%s>", start.Name.Local)
+}
+
+func decodePlistDict(dec *xml.Decoder) (map[string]any, error) {
+	dict := make(map[string]any)
+	key := ""
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "key" {
+				if err := dec.DecodeElement(&key, &t); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			value, err := decodePlistValue(dec, t)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			dict[key] = value
+		case xml.EndElement:
+			return dict, nil
+		}
+	}
+}
+
+func decodePlistArray(dec *xml.Decoder) ([]any, error) {
+	array := make([]any, 0)
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			value, err := decodePlistValue(dec, t)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		case xml.EndElement:
+			return array, nil
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package playlistio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"musicplaylist/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WritePLS writes playlist as a version 2 PLS file in UTF-8. PLS has no
+// playlist name or album, so only paths, "Artist - Title" and lengths are
+// kept.
+func WritePLS(w io.Writer, playlist *models.Playlist, opts Options) error {
+	var buf bytes.Buffer
+	buf.WriteString("[playlist]\n")
+	for i, song := range playlist.Songs {
+		n := i + 1
+		fmt.Fprintf(&buf, "File%d=%s\n", n, exportPath(song.FilePath, opts.Paths, opts.BaseDir))
+		fmt.Fprintf(&buf, "Title%d=%s\n", n, singleLine(displayTitle(song)))
+		fmt.Fprintf(&buf, "Length%d=%d\n", n, lengthSeconds(song.Duration))
+	}
+	fmt.Fprintf(&buf, "NumberOfEntries=%d\n", len(playlist.Songs))
+	buf.WriteString("Version=2\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ReadPLS reads the entries of a PLS file in the order of their numbers,
+// which need not be the order they appear in. Relative paths are resolved
+// against baseDir.
+func ReadPLS(r io.Reader, baseDir string) (string, []Entry, error) {
+	text, err := readText(r)
+	if err != nil {
+		return "", nil, err
+	}
+
+	byNumber := make(map[int]*Entry)
+	entry := func(n int) *Entry {
+		if byNumber[n] == nil {
+			byNumber[n] = &Entry{}
+		}
+		return byNumber[n]
+	}
+
+	for i, line := range strings.Split(text, "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if !found {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		prefix := strings.TrimRight(key, "0123456789")
+		n, err := strconv.Atoi(key[len(prefix):])
+		if err != nil {
+			// NumberOfEntries and Version aren't needed
+			continue
+		}
+
+		switch prefix {
+		case "file":
+			entry(n).Line = i + 1
+			entry(n).Path = resolvePath(value, baseDir)
+		case "title":
+			entry(n).Artist, entry(n).Title = splitDisplayTitle(value)
+		case "length":
+			entry(n).Duration = parseSeconds(value)
+		}
+	}
+
+	numbers := make([]int, 0, len(byNumber))
+	for n, e := range byNumber {
+		// a title or length without a File line has nothing to play
+		if e.Path != "" {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+
+	entries := make([]Entry, len(numbers))
+	for i, n := range numbers {
+		entries[i] = *byNumber[n]
+	}
+	return "", entries, nil
+}
@@ -0,0 +1,363 @@
+package playlistio
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"musicplaylist/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Delimiters for CSV and TSV tables
+const (
+	CSV = ','
+	TSV = '\t'
+)
+
+// TableDelimiter accepts "csv" or "tsv", or a file extension naming them.
+func TableDelimiter(name string) (rune, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "csv":
+		return CSV, nil
+	case "tsv", "tab":
+		return TSV, nil
+	}
+	return 0, fmt.Errorf("unsupported table format %q, use csv or tsv", name)
+}
+
+// TableRow is a song as one row of a table. Position counts from 1 and is 0
+// outside a playlist.
+type TableRow struct {
+	Playlist string
+	Position int
+	Song     *models.Song
+}
+
+// column reads a field for export, and writes it back on import unless it is
+// read-only.
+type column struct {
+	get func(row TableRow) string
+	set func(song *models.Song, value string) error
+}
+
+var columns = map[string]column{
+	"id":       {get: func(row TableRow) string { return row.Song.ID }},
+	"playlist": {get: func(row TableRow) string { return row.Playlist }},
+	"position": {get: func(row TableRow) string {
+		if row.Position == 0 {
+			return ""
+		}
+		return strconv.Itoa(row.Position)
+	}},
+	"title": {
+		get: func(row TableRow) string { return row.Song.Title },
+		set: func(song *models.Song, value string) error {
+			if value == "" {
+				return errors.New("cannot be empty")
+			}
+			song.Title = value
+			return nil
+		},
+	},
+	"artist": {
+		get: func(row TableRow) string { return row.Song.Artist },
+		set: func(song *models.Song, value string) error { song.Artist = value; return nil },
+	},
+	"album": {
+		get: func(row TableRow) string { return row.Song.Album },
+		set: func(song *models.Song, value string) error { song.Album = value; return nil },
+	},
+	"genre": {
+		get: func(row TableRow) string { return row.Song.Genre },
+		set: func(song *models.Song, value string) error { song.Genre = value; return nil },
+	},
+	"year": {
+		get: func(row TableRow) string { return optionalInt(row.Song.Year) },
+		set: func(song *models.Song, value string) error {
+			year, err := parseOptionalInt(value, 0, 9999)
+			song.Year = year
+			return err
+		},
+	},
+	"track": {
+		get: func(row TableRow) string { return optionalInt(row.Song.TrackNumber) },
+		set: func(song *models.Song, value string) error {
+			track, err := parseOptionalInt(value, 0, -1)
+			song.TrackNumber = track
+			return err
+		},
+	},
+	"duration": {get: func(row TableRow) string { return formatMinutes(row.Song.Duration) }},
+	"playCount": {
+		get: func(row TableRow) string { return optionalInt(row.Song.PlayCount) },
+		set: func(song *models.Song, value string) error {
+			count, err := parseOptionalInt(value, 0, -1)
+			song.PlayCount = count
+			return err
+		},
+	},
+	"rating": {
+		get: func(row TableRow) string { return optionalInt(row.Song.Rating) },
+		set: func(song *models.Song, value string) error {
+			rating, err := parseOptionalInt(value, 0, 5)
+			song.Rating = rating
+			return err
+		},
+	},
+	"path": {get: func(row TableRow) string { return row.Song.FilePath }},
+	"addedAt": {get: func(row TableRow) string {
+		if row.Song.AddedAt.IsZero() {
+			return ""
+		}
+		return row.Song.AddedAt.Format(time.RFC3339)
+	}},
+}
+
+// TableColumns lists every column a table can have.
+var TableColumns = []string{
+	"id", "playlist", "position", "title", "artist", "album", "genre", "year",
+	"track", "duration", "playCount", "rating", "path", "addedAt",
+}
+
+var DefaultTableColumns = []string{
+	"id", "playlist", "position", "title", "artist", "album", "genre", "year", "duration", "path",
+}
+
+// ParseColumns reads a comma-separated list of column names, ignoring case.
+// An empty list means DefaultTableColumns.
+func ParseColumns(list string) ([]string, error) {
+	if strings.TrimSpace(list) == "" {
+		return DefaultTableColumns, nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(list, ",") {
+		canonical, ok := columnName(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q, choose from %s", strings.TrimSpace(name), strings.Join(TableColumns, ", "))
+		}
+		names = append(names, canonical)
+	}
+	return names, nil
+}
+
+func columnName(name string) (string, bool) {
+	name = strings.TrimSpace(name)
+	for _, canonical := range TableColumns {
+		if strings.EqualFold(name, canonical) {
+			return canonical, true
+		}
+	}
+	return "", false
+}
+
+// WriteTable writes rows as CSV or TSV with a header line naming columns.
+func WriteTable(w io.Writer, rows []TableRow, columnNames []string, delimiter rune) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = delimiter
+
+	if err := cw.Write(columnNames); err != nil {
+		return err
+	}
+	record := make([]string, len(columnNames))
+	for _, row := range rows {
+		for i, name := range columnNames {
+			col, ok := columns[name]
+			if !ok {
+				return fmt.Errorf("unknown column %q", name)
+			}
+			record[i] = col.get(row)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// SongEdit is a row of an imported table: the new values it gives the song
+// with ID.
+type SongEdit struct {
+	Line   int
+	ID     string
+	values map[string]string
+}
+
+// Apply writes the edit's values to song. The values were checked when the
+// table was read.
+func (e SongEdit) Apply(song *models.Song) {
+	for name, value := range e.values {
+		columns[name].set(song, value)
+	}
+}
+
+// RowError is a problem with one row, or one cell, of an imported table.
+type RowError struct {
+	Line    int    `json:"line"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e RowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return fmt.Sprintf("line %d, %s: %s", e.Line, e.Column, e.Message)
+}
+
+// ReadTable reads song edits from a CSV or TSV table like the ones WriteTable
+// writes. The header must have an id column; columns that can't be edited,
+// such as path and duration, are ignored. Rows with bad values are reported
+// and left out, and so is a row for a song already edited differently
+// higher up. An error is only returned when the table can't be read at all.
+func ReadTable(r io.Reader, delimiter rune) ([]SongEdit, []RowError, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = delimiter
+	cr.FieldsPerRecord = -1
+	if delimiter == TSV {
+		cr.LazyQuotes = true
+	}
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, nil, errors.New("table is empty")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	idColumn := -1
+	names := make([]string, len(header))
+	for i, heading := range header {
+		name, ok := columnName(strings.TrimPrefix(heading, "\ufeff"))
+		if !ok {
+			return nil, nil, fmt.Errorf("unknown column %q, choose from %s", heading, strings.Join(TableColumns, ", "))
+		}
+		names[i] = name
+		if name == "id" {
+			idColumn = i
+		}
+	}
+	if idColumn < 0 {
+		return nil, nil, errors.New("table needs an id column to match rows to songs")
+	}
+
+	edits := make([]SongEdit, 0)
+	rowErrors := make([]RowError, 0)
+	seen := make(map[string]SongEdit)
+
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return nil, nil, err
+			}
+			rowErrors = append(rowErrors, RowError{Line: parseErr.StartLine, Message: parseErr.Err.Error()})
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+		if len(record) == 1 && strings.TrimSpace(record[0]) == "" {
+			continue
+		}
+		if len(record) != len(names) {
+			rowErrors = append(rowErrors, RowError{
+				Line:    line,
+				Message: fmt.Sprintf("has %d cells, the header has %d", len(record), len(names)),
+			})
+			continue
+		}
+
+		edit := SongEdit{Line: line, ID: strings.TrimSpace(record[idColumn]), values: make(map[string]string)}
+		if edit.ID == "" {
+			rowErrors = append(rowErrors, RowError{Line: line, Column: "id", Message: "is empty"})
+			continue
+		}
+
+		valid := true
+		scratch := &models.Song{}
+		for i, name := range names {
+			if columns[name].set == nil {
+				continue
+			}
+			value := strings.TrimSpace(record[i])
+			if err := columns[name].set(scratch, value); err != nil {
+				rowErrors = append(rowErrors, RowError{Line: line, Column: name, Message: err.Error()})
+				valid = false
+				continue
+			}
+			edit.values[name] = value
+		}
+		if !valid {
+			continue
+		}
+
+		// A song listed twice, as in a playlist holding it twice, only needs
+		// editing once
+		if first, ok := seen[edit.ID]; ok {
+			if !sameValues(first.values, edit.values) {
+				rowErrors = append(rowErrors, RowError{
+					Line:    line,
+					Message: fmt.Sprintf("song %s was given different values on line %d", edit.ID, first.Line),
+				})
+			}
+			continue
+		}
+		seen[edit.ID] = edit
+		edits = append(edits, edit)
+	}
+	return edits, rowErrors, nil
+}
+
+func sameValues(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, value := range a {
+		if other, ok := b[name]; !ok || other != value {
+			return false
+		}
+	}
+	return true
+}
+
+func optionalInt(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// parseOptionalInt reads a whole number from lo to hi, or with no upper bound
+// when hi is negative. Empty means 0.
+func parseOptionalInt(value string, lo, hi int) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a whole number", value)
+	}
+	if n < lo || (hi >= 0 && n > hi) {
+		if hi < 0 {
+			return 0, fmt.Errorf("%d is below %d", n, lo)
+		}
+		return 0, fmt.Errorf("%d is not between %d and %d", n, lo, hi)
+	}
+	return n, nil
+}
+
+func formatMinutes(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	seconds := int(d.Round(time.Second) / time.Second)
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
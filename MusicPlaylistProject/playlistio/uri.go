@@ -0,0 +1,76 @@
+package playlistio
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// fileURLPath returns the local path of a file:// URL.
+func fileURLPath(s string) (string, bool) {
+	if !strings.HasPrefix(strings.ToLower(s), "file:") {
+		return "", false
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Path == "" {
+		return "", false
+	}
+
+	path := u.Path
+	// file:///C:/Music/a.mp3 names C:\Music\a.mp3 on Windows
+	if len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+	return filepath.FromSlash(path), true
+}
+
+// fileURL is the file:// URL of an absolute path, with spaces, '#', '%' and
+// non-ASCII letters escaped.
+func fileURL(path string) string {
+	slashed := filepath.ToSlash(path)
+	if !strings.HasPrefix(slashed, "/") {
+		slashed = "/" + slashed
+	}
+	return (&url.URL{Scheme: "file", Path: slashed}).String()
+}
+
+// exportLocation writes path as a URI for a playlist file stored in baseDir:
+// a file:// URL, or an escaped relative reference when relative paths are
+// asked for and the song can be reached from baseDir.
+func exportLocation(path string, style PathStyle, baseDir string) string {
+	exported := exportPath(path, style, baseDir)
+	if filepath.IsAbs(filepath.FromSlash(exported)) {
+		return fileURL(exported)
+	}
+	// a leading "./" is added when the first segment holds a colon
+	return (&url.URL{Path: exported}).String()
+}
+
+// resolvePath turns a path from a playlist file into a local path. URLs
+// other than file:// are returned unchanged; they won't resolve to a song.
+func resolvePath(path, baseDir string) string {
+	if local, ok := fileURLPath(path); ok {
+		return local
+	}
+	if strings.Contains(path, "://") {
+		return path
+	}
+
+	path = filepath.FromSlash(strings.ReplaceAll(path, `\`, "/"))
+	if !filepath.IsAbs(path) && baseDir != "" {
+		path = filepath.Join(baseDir, path)
+	}
+	return filepath.Clean(path)
+}
+
+// resolveLocation is resolvePath for URIs, where a relative reference is
+// escaped.
+func resolveLocation(location, baseDir string) string {
+	if _, ok := fileURLPath(location); ok || strings.Contains(location, "://") {
+		return resolvePath(location, baseDir)
+	}
+	if unescaped, err := url.PathUnescape(location); err == nil {
+		location = unescaped
+	}
+	return resolvePath(location, baseDir)
+}
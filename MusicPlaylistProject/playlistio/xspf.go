@@ -0,0 +1,133 @@
+package playlistio
+
+import (
+	"encoding/xml"
+	"io"
+	"musicplaylist/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+type xspfPlaylist struct {
+	XMLName    xml.Name    `xml:"playlist"`
+	Version    string      `xml:"version,attr"`
+	Namespace  string      `xml:"xmlns,attr"`
+	Title      string      `xml:"title,omitempty"`
+	Annotation string      `xml:"annotation,omitempty"`
+	Tracks     []xspfTrack `xml:"trackList>track"`
+}
+
+// xspfTrack lists its elements in the order the XSPF spec gives them.
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	// Duration is in milliseconds
+	Duration string `xml:"duration,omitempty"`
+}
+
+// WriteXSPF writes playlist as XSPF. Songs are located by file:// URL, or by
+// relative reference with RelativePaths.
+func WriteXSPF(w io.Writer, playlist *models.Playlist, opts Options) error {
+	doc := xspfPlaylist{
+		Version:    "1",
+		Namespace:  xspfNamespace,
+		Title:      playlist.Name,
+		Annotation: playlist.Description,
+		Tracks:     make([]xspfTrack, 0, len(playlist.Songs)),
+	}
+	for _, song := range playlist.Songs {
+		track := xspfTrack{
+			Location: exportLocation(song.FilePath, opts.Paths, opts.BaseDir),
+			Title:    song.Title,
+			Creator:  song.Artist,
+			Album:    song.Album,
+		}
+		if song.Duration > 0 {
+			track.Duration = strconv.FormatInt(song.Duration.Milliseconds(), 10)
+		}
+		doc.Tracks = append(doc.Tracks, track)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// ReadXSPF reads the tracks of an XSPF file. A track with several locations
+// is read from the first one; relative references are resolved against
+// baseDir.
+func ReadXSPF(r io.Reader, baseDir string) (string, []Entry, error) {
+	dec := xml.NewDecoder(r)
+
+	var name string
+	var entries []Entry
+	depth := 0
+	for {
+		token, err := dec.Token()
+		if err == io.EOF {
+			return name, entries, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 2 && t.Name.Local == "title":
+				if err := dec.DecodeElement(&name, &t); err != nil {
+					return "", nil, err
+				}
+				name = strings.TrimSpace(name)
+				depth--
+			case depth == 3 && t.Name.Local == "track":
+				line, _ := dec.InputPos()
+				var track struct {
+					Locations []string `xml:"location"`
+					Title     string   `xml:"title"`
+					Creator   string   `xml:"creator"`
+					Album     string   `xml:"album"`
+					Duration  string   `xml:"duration"`
+				}
+				if err := dec.DecodeElement(&track, &t); err != nil {
+					return "", nil, err
+				}
+				depth--
+				if len(track.Locations) == 0 {
+					continue
+				}
+				entries = append(entries, Entry{
+					Line:     line,
+					Path:     resolveLocation(strings.TrimSpace(track.Locations[0]), baseDir),
+					Title:    strings.TrimSpace(track.Title),
+					Artist:   strings.TrimSpace(track.Creator),
+					Album:    strings.TrimSpace(track.Album),
+					Duration: parseMilliseconds(track.Duration),
+				})
+			}
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+func parseMilliseconds(s string) time.Duration {
+	ms, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || ms <= 0 {
+		return 0
+	}
+	return time.Duration(ms) * time.Millisecond
+}
@@ -0,0 +1,39 @@
+package scanner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"musicplaylist/audio"
+	"musicplaylist/models"
+	"os"
+)
+
+// ContentHash hashes the audio payload of a file with its tag blocks left out,
+// so retagged copies of the same recording hash the same.
+func ContentHash(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if err := audio.CopyPayload(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// ScanFile reads a single audio file into a song, including its content hash.
+func ScanFile(path string) (*models.Song, error) {
+	song, err := models.NewSongFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// A file we can't hash is still playable, it just can't be matched by content
+	if hash, err := ContentHash(path); err == nil {
+		song.ContentHash = hash
+	}
+	return song, nil
+}
@@ -0,0 +1,169 @@
+package scanner
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"musicplaylist/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FileChange is one file in a rescan diff. OldPath and SongID are set for
+// files that were seen before, so the change can be applied to the right song.
+type FileChange struct {
+	Path    string    `json:"path"`
+	OldPath string    `json:"old_path,omitempty"`
+	SongID  string    `json:"song_id,omitempty"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Hash    string    `json:"hash,omitempty"`
+}
+
+// RescanDiff describes how a scanned folder differs from what was recorded on
+// its last scan. It is plain data so it can be shown for confirmation and then
+// handed back to be applied.
+type RescanDiff struct {
+	Root       string       `json:"root"`
+	PlaylistID string       `json:"playlist_id,omitempty"`
+	Added      []FileChange `json:"added"`
+	Changed    []FileChange `json:"changed"`
+	Moved      []FileChange `json:"moved"`
+	Removed    []FileChange `json:"removed"`
+	Unchanged  int          `json:"unchanged"`
+}
+
+func (d *RescanDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Moved) == 0 && len(d.Removed) == 0
+}
+
+func (d *RescanDiff) Summary() string {
+	return fmt.Sprintf("%d added, %d changed, %d moved, %d removed, %d unchanged",
+		len(d.Added), len(d.Changed), len(d.Moved), len(d.Removed), d.Unchanged)
+}
+
+// IsAudioFile reports whether path has one of the extensions the scanner reads.
+func IsAudioFile(path string) bool {
+	return supportedExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// PlanRescan walks root.Path and compares what it finds with root.Files.
+// Files whose size and modification time are unchanged are not read at all;
+// new files are hashed so a file that only moved can be told apart from a
+// removal plus an addition.
+func PlanRescan(root *models.ScanRoot) (*RescanDiff, error) {
+	info, err := os.Stat(root.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, errors.New("Provided path was not a directory")
+	}
+
+	diff := &RescanDiff{
+		Root:       root.Path,
+		PlaylistID: root.PlaylistID,
+		Added:      make([]FileChange, 0),
+		Changed:    make([]FileChange, 0),
+		Moved:      make([]FileChange, 0),
+		Removed:    make([]FileChange, 0),
+	}
+	seen := make(map[string]bool)
+	var added []FileChange
+
+	err = filepath.WalkDir(root.Path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			// An unreadable folder shouldn't make its songs look deleted
+			if entry != nil && entry.IsDir() {
+				markSubtreeSeen(root, path, seen)
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if entry.IsDir() || !IsAudioFile(path) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		seen[path] = true
+		change := FileChange{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+
+		state, ok := root.Files[path]
+		if ok && state.Size == change.Size && state.ModTime.Equal(change.ModTime) {
+			diff.Unchanged++
+			return nil
+		}
+
+		change.Hash, _ = ContentHash(path)
+		if ok {
+			change.SongID = state.SongID
+			diff.Changed = append(diff.Changed, change)
+		} else {
+			added = append(added, change)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A removed file whose audio shows up under a new name was moved
+	removedByHash := make(map[string][]string)
+	var removedPaths []string
+	for path := range root.Files {
+		if !seen[path] {
+			removedPaths = append(removedPaths, path)
+		}
+	}
+	sort.Strings(removedPaths)
+	for _, path := range removedPaths {
+		if hash := root.Files[path].Hash; hash != "" {
+			removedByHash[hash] = append(removedByHash[hash], path)
+		}
+	}
+
+	moved := make(map[string]bool)
+	for _, change := range added {
+		candidates := removedByHash[change.Hash]
+		if change.Hash == "" || len(candidates) == 0 {
+			diff.Added = append(diff.Added, change)
+			continue
+		}
+		change.OldPath = candidates[0]
+		change.SongID = root.Files[change.OldPath].SongID
+		removedByHash[change.Hash] = candidates[1:]
+		moved[change.OldPath] = true
+		diff.Moved = append(diff.Moved, change)
+	}
+
+	for _, path := range removedPaths {
+		if moved[path] {
+			continue
+		}
+		state := root.Files[path]
+		diff.Removed = append(diff.Removed, FileChange{
+			Path:    path,
+			SongID:  state.SongID,
+			Size:    state.Size,
+			ModTime: state.ModTime,
+			Hash:    state.Hash,
+		})
+	}
+
+	return diff, nil
+}
+
+func markSubtreeSeen(root *models.ScanRoot, dir string, seen map[string]bool) {
+	prefix := dir + string(filepath.Separator)
+	for path := range root.Files {
+		if strings.HasPrefix(path, prefix) {
+			seen[path] = true
+		}
+	}
+}
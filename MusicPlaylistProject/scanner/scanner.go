@@ -8,7 +8,6 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
-	"time"
 )
 
 var supportedExtensions = map[string]bool{
@@ -72,7 +71,7 @@ func walkDir(dir string, wg *sync.WaitGroup, songChan chan<- *models.Song) {
 		} else {
 			ext := strings.ToLower(filepath.Ext(entry.Name()))
 			if supportedExtensions[ext] {
-				song, err := models.NewSongFromPath(fullPath, time.Second*time.Duration(180))
+				song, err := ScanFile(fullPath)
 				if err == nil {
 					songChan <- song
 				} else {
@@ -0,0 +1,259 @@
+package scanner
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type EventKind int
+
+const (
+	FileCreated EventKind = iota
+	FileModified
+	FileRemoved
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case FileCreated:
+		return "created"
+	case FileModified:
+		return "modified"
+	}
+	return "removed"
+}
+
+// Event is a change to a single audio file under a watched folder. A removal
+// may also name a directory, meaning everything below it is gone.
+type Event struct {
+	Path string
+	Kind EventKind
+}
+
+// Watcher reports changes to audio files under a set of folders. Events are
+// debounced and delivered in batches once the folders have been quiet for a
+// moment, so a file being copied in shows up once, after it is complete.
+type Watcher interface {
+	Add(root string) error
+	// Remove stops watching a folder given to Add
+	Remove(root string) error
+	Events() <-chan []Event
+	Close() error
+}
+
+const DefaultDebounce = 2 * time.Second
+
+// debouncer merges raw events per path and flushes them after a quiet period.
+type debouncer struct {
+	delay     time.Duration
+	in        chan Event
+	out       chan []Event
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newDebouncer(delay time.Duration) *debouncer {
+	d := &debouncer{
+		delay: delay,
+		in:    make(chan Event, 256),
+		out:   make(chan []Event),
+		done:  make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *debouncer) push(event Event) {
+	select {
+	case d.in <- event:
+	case <-d.done:
+	}
+}
+
+func (d *debouncer) close() {
+	d.closeOnce.Do(func() { close(d.done) })
+}
+
+func (d *debouncer) run() {
+	defer close(d.out)
+
+	pending := make(map[string]EventKind)
+	var order []string
+	timer := time.NewTimer(d.delay)
+	timer.Stop()
+
+	for {
+		select {
+		case event := <-d.in:
+			kind, seen := pending[event.Path]
+			if !seen {
+				order = append(order, event.Path)
+				pending[event.Path] = event.Kind
+			} else {
+				pending[event.Path] = mergeEventKinds(kind, event.Kind)
+			}
+			timer.Reset(d.delay)
+
+		case <-timer.C:
+			batch := make([]Event, 0, len(order))
+			for _, path := range order {
+				batch = append(batch, Event{Path: path, Kind: pending[path]})
+			}
+			pending = make(map[string]EventKind)
+			order = nil
+
+			select {
+			case d.out <- batch:
+			case <-d.done:
+				return
+			}
+
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// mergeEventKinds folds a new event into the one already pending for a path.
+func mergeEventKinds(pending, next EventKind) EventKind {
+	switch {
+	case pending == FileCreated && next == FileModified:
+		return FileCreated
+	case pending == FileRemoved && next == FileCreated:
+		// replaced in place, e.g. by an editor or a re-rip
+		return FileModified
+	}
+	return next
+}
+
+type fileSnapshot struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshotAudioFiles records the size and modification time of every audio
+// file under root.
+func snapshotAudioFiles(root string) map[string]fileSnapshot {
+	files := make(map[string]fileSnapshot)
+	filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !IsAudioFile(path) {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			files[path] = fileSnapshot{size: info.Size(), modTime: info.ModTime()}
+		}
+		return nil
+	})
+	return files
+}
+
+// PollingWatcher finds changes by walking its folders on a fixed interval. It
+// works on every platform and filesystem, including network mounts.
+type PollingWatcher struct {
+	interval time.Duration
+	debounce *debouncer
+
+	mu        sync.Mutex
+	snapshots map[string]map[string]fileSnapshot
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+func NewPollingWatcher(interval, debounce time.Duration) *PollingWatcher {
+	w := &PollingWatcher{
+		interval:  interval,
+		debounce:  newDebouncer(debounce),
+		snapshots: make(map[string]map[string]fileSnapshot),
+		stop:      make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *PollingWatcher) Add(root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "watch", Path: root, Err: fs.ErrInvalid}
+	}
+
+	snapshot := snapshotAudioFiles(root)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.snapshots[root]; !ok {
+		w.snapshots[root] = snapshot
+	}
+	return nil
+}
+
+func (w *PollingWatcher) Remove(root string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.snapshots, root)
+	return nil
+}
+
+func (w *PollingWatcher) Events() <-chan []Event {
+	return w.debounce.out
+}
+
+func (w *PollingWatcher) Close() error {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+		w.debounce.close()
+	})
+	return nil
+}
+
+func (w *PollingWatcher) run() {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.poll()
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+func (w *PollingWatcher) poll() {
+	w.mu.Lock()
+	roots := make([]string, 0, len(w.snapshots))
+	for root := range w.snapshots {
+		roots = append(roots, root)
+	}
+	w.mu.Unlock()
+
+	for _, root := range roots {
+		current := snapshotAudioFiles(root)
+
+		w.mu.Lock()
+		previous := w.snapshots[root]
+		w.snapshots[root] = current
+		w.mu.Unlock()
+
+		for path, file := range current {
+			old, ok := previous[path]
+			switch {
+			case !ok:
+				w.debounce.push(Event{Path: path, Kind: FileCreated})
+			case old.size != file.size || !old.modTime.Equal(file.modTime):
+				w.debounce.push(Event{Path: path, Kind: FileModified})
+			}
+		}
+		for path := range previous {
+			if _, ok := current[path]; !ok {
+				w.debounce.push(Event{Path: path, Kind: FileRemoved})
+			}
+		}
+	}
+}
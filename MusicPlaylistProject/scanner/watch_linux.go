@@ -0,0 +1,195 @@
+//go:build linux
+
+package scanner
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// InotifyWatcher uses the Linux inotify API, with one watch per directory
+// since inotify itself is not recursive.
+type InotifyWatcher struct {
+	fd       int
+	file     *os.File
+	debounce *debouncer
+
+	mu   sync.Mutex
+	dirs map[int32]string
+}
+
+// NewWatcher returns the inotify backend on Linux.
+func NewWatcher(debounce time.Duration) (Watcher, error) {
+	return NewInotifyWatcher(debounce)
+}
+
+func NewInotifyWatcher(debounce time.Duration) (*InotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+
+	// A non-blocking descriptor wrapped in an os.File goes through the runtime
+	// poller, so Close unblocks a pending Read. The raw descriptor is kept
+	// because File.Fd would switch it back to blocking mode.
+	w := &InotifyWatcher{
+		fd:       fd,
+		file:     os.NewFile(uintptr(fd), "inotify"),
+		debounce: newDebouncer(debounce),
+		dirs:     make(map[int32]string),
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+// Add watches root and every directory below it.
+func (w *InotifyWatcher) Add(root string) error {
+	info, err := os.Stat(root)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return &fs.PathError{Op: "watch", Path: root, Err: fs.ErrInvalid}
+	}
+	return w.addTree(root, false)
+}
+
+// Remove drops the watches on root and every directory below it.
+func (w *InotifyWatcher) Remove(root string) error {
+	prefix := root + string(filepath.Separator)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for wd, path := range w.dirs {
+		if path != root && !strings.HasPrefix(path, prefix) {
+			continue
+		}
+		delete(w.dirs, wd)
+		if _, err := syscall.InotifyRmWatch(w.fd, uint32(wd)); err != nil && err != syscall.EINVAL {
+			return os.NewSyscallError("inotify_rm_watch", err)
+		}
+	}
+	return nil
+}
+
+func (w *InotifyWatcher) Events() <-chan []Event {
+	return w.debounce.out
+}
+
+func (w *InotifyWatcher) Close() error {
+	w.debounce.close()
+	return w.file.Close()
+}
+
+// addTree adds a watch for every directory under root. When report is set the
+// audio files already inside are reported as created, which covers a folder
+// that was moved in, or files written before its watch was in place.
+func (w *InotifyWatcher) addTree(root string, report bool) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil
+		}
+		if !entry.IsDir() {
+			if report && IsAudioFile(path) {
+				w.debounce.push(Event{Path: path, Kind: FileCreated})
+			}
+			return nil
+		}
+
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return os.NewSyscallError("inotify_add_watch", err)
+		}
+		w.mu.Lock()
+		w.dirs[int32(wd)] = path
+		w.mu.Unlock()
+		return nil
+	})
+}
+
+// removeTree forgets the watches under a directory that went away and reports
+// the directory itself as removed.
+func (w *InotifyWatcher) removeTree(dir string) {
+	prefix := dir + string(filepath.Separator)
+
+	w.mu.Lock()
+	for wd, path := range w.dirs {
+		if path == dir || strings.HasPrefix(path, prefix) {
+			delete(w.dirs, wd)
+		}
+	}
+	w.mu.Unlock()
+
+	w.debounce.push(Event{Path: dir, Kind: FileRemoved})
+}
+
+func (w *InotifyWatcher) readEvents() {
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			// closed, or the descriptor is no longer usable
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+syscall.SizeofInotifyEvent : offset+syscall.SizeofInotifyEvent+int(raw.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			offset += syscall.SizeofInotifyEvent + int(raw.Len)
+
+			w.handle(raw.Wd, raw.Mask, name)
+		}
+	}
+}
+
+func (w *InotifyWatcher) handle(wd int32, mask uint32, name string) {
+	w.mu.Lock()
+	dir, ok := w.dirs[wd]
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+	}
+	w.mu.Unlock()
+	if !ok || name == "" {
+		return
+	}
+
+	path := filepath.Join(dir, name)
+
+	if mask&syscall.IN_ISDIR != 0 {
+		switch {
+		case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			w.addTree(path, true)
+		case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+			w.removeTree(path)
+		}
+		return
+	}
+
+	if !IsAudioFile(path) {
+		return
+	}
+
+	switch {
+	case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+		w.debounce.push(Event{Path: path, Kind: FileCreated})
+	case mask&syscall.IN_CLOSE_WRITE != 0:
+		w.debounce.push(Event{Path: path, Kind: FileModified})
+	case mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0:
+		w.debounce.push(Event{Path: path, Kind: FileRemoved})
+	}
+}
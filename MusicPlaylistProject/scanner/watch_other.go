@@ -0,0 +1,12 @@
+//go:build !linux
+
+package scanner
+
+import "time"
+
+const defaultPollInterval = 5 * time.Second
+
+// NewWatcher falls back to polling where no native backend is available.
+func NewWatcher(debounce time.Duration) (Watcher, error) {
+	return NewPollingWatcher(defaultPollInterval, debounce), nil
+}
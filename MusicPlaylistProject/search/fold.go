@@ -0,0 +1,65 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Letters that don't decompose into a base letter and a mark
+var foldSpecial = map[rune]string{
+	'ß': "ss",
+	'æ': "ae",
+	'œ': "oe",
+	'ø': "o",
+	'ł': "l",
+	'đ': "d",
+	'ð': "d",
+	'þ': "th",
+	'ı': "i",
+}
+
+// Fold lowercases s and strips diacritics, so "Beyoncé" and "beyonce" fold to
+// the same text.
+func Fold(s string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+
+	for _, r := range norm.NFD.String(s) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if special, ok := foldSpecial[r]; ok {
+			b.WriteString(special)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Tokenize splits s into folded words. Apostrophes are dropped rather than
+// split on, so "Don't" becomes the single token "dont".
+func Tokenize(s string) []string {
+	var tokens []string
+	var current strings.Builder
+
+	for _, r := range Fold(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			current.WriteRune(r)
+		case r == '\'' || r == '’':
+		default:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
@@ -0,0 +1,151 @@
+package search
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxEdits is how many typos a word may hold and still match in fuzzy mode.
+// Short words have to be exact, or everything would match them.
+func maxEdits(word string) int {
+	switch n := utf8.RuneCountInString(word); {
+	case n <= 3:
+		return 0
+	case n <= 6:
+		return 1
+	}
+	return 2
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// neighbouring letters that turn a into b. It gives up once the count is
+// known to exceed limit, returning limit+1.
+func editDistance(a, b string, limit int) int {
+	return newDistancer(a).distance(b, limit)
+}
+
+// distancer measures the distance from one word to many others, reusing its
+// buffers between them.
+type distancer struct {
+	word              []rune
+	other             []rune
+	prev2, prev, curr []int
+}
+
+func newDistancer(word string) *distancer {
+	return &distancer{word: []rune(word)}
+}
+
+func (d *distancer) distance(other string, limit int) int {
+	d.other = d.other[:0]
+	for _, r := range other {
+		d.other = append(d.other, r)
+	}
+	ra, rb := d.word, d.other
+	if abs(len(ra)-len(rb)) > limit {
+		return limit + 1
+	}
+
+	// Three rows of the table are enough, the swap looks back two rows
+	if cap(d.prev) < len(rb)+1 {
+		d.prev2 = make([]int, len(rb)+1)
+		d.prev = make([]int, len(rb)+1)
+		d.curr = make([]int, len(rb)+1)
+	}
+	prev2, prev, curr := d.prev2[:len(rb)+1], d.prev[:len(rb)+1], d.curr[:len(rb)+1]
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > limit {
+			return limit + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+	return prev[len(rb)]
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// similarity scores a typo match between 0 and 1, 1 being identical.
+func similarity(a, b string, distance int) float64 {
+	longest := max(utf8.RuneCountInString(a), utf8.RuneCountInString(b))
+	if longest == 0 {
+		return 1
+	}
+	return 1 - float64(distance)/float64(longest)
+}
+
+// fuzzyWordMatch finds how closely want matches the best of tokens. The last
+// word of a term may also be the start of a token, as in exact mode.
+func fuzzyWordMatch(tokens []string, want string, prefix bool) (float64, bool) {
+	limit := maxEdits(want)
+	distances := newDistancer(want)
+	best, found := 0.0, false
+	for _, token := range tokens {
+		if token == want || (prefix && strings.HasPrefix(token, want)) {
+			return 1, true
+		}
+		if limit == 0 {
+			continue
+		}
+		if d := distances.distance(token, limit); d <= limit {
+			found = true
+			best = max(best, similarity(token, want, d))
+		}
+	}
+	return best, found
+}
+
+// fuzzyLookup is lookup with typos allowed: besides the tokens starting with
+// word, it takes in every token within maxEdits of it.
+func (ix *Index) fuzzyLookup(word string, fields []field) []int {
+	limit := maxEdits(word)
+	if limit == 0 {
+		return ix.lookup(word, fields)
+	}
+
+	var lists [][]int
+	add := func(p *postings) {
+		for _, f := range fields {
+			if len(p[f]) > 0 {
+				lists = append(lists, p[f])
+			}
+		}
+	}
+
+	ix.withPrefix(word, func(_ string, p *postings) { add(p) })
+
+	distances := newDistancer(word)
+	length := utf8.RuneCountInString(word)
+	for _, token := range ix.vocabulary() {
+		// Byte length bounds rune length from above, which rules out most
+		// tokens without counting
+		if len(token) < length-limit || strings.HasPrefix(token, word) {
+			continue
+		}
+		if p, ok := ix.terms[token]; ok && distances.distance(token, limit) <= limit {
+			add(p)
+		}
+	}
+	return unionSeqs(lists)
+}
@@ -0,0 +1,344 @@
+package search
+
+import (
+	"musicplaylist/models"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type field int
+
+const (
+	fieldTitle field = iota
+	fieldArtist
+	fieldAlbum
+	fieldGenre
+	fieldPath
+	numFields
+)
+
+var fieldNames = map[string]field{
+	"title":  fieldTitle,
+	"artist": fieldArtist,
+	"album":  fieldAlbum,
+	"genre":  fieldGenre,
+	"path":   fieldPath,
+}
+
+func (f field) text(song *models.Song) string {
+	switch f {
+	case fieldTitle:
+		return song.Title
+	case fieldArtist:
+		return song.Artist
+	case fieldAlbum:
+		return song.Album
+	case fieldGenre:
+		return song.Genre
+	case fieldPath:
+		return song.FilePath
+	}
+	return ""
+}
+
+// document is a song with its fields tokenized. Tokens are worked out on
+// first use for songs that aren't in an index.
+type document struct {
+	song   *models.Song
+	seq    int
+	tokens [numFields][]string
+	ready  [numFields]bool
+}
+
+func (d *document) fieldTokens(f field) []string {
+	if !d.ready[f] {
+		d.tokens[f] = Tokenize(f.text(d.song))
+		d.ready[f] = true
+	}
+	return d.tokens[f]
+}
+
+// postings holds, per field, the sequence numbers of the songs containing a
+// token, in ascending order.
+type postings [numFields][]int
+
+func (p *postings) empty() bool {
+	for _, seqs := range p {
+		if len(seqs) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Index is an inverted index over song text fields. Songs are found by token
+// prefix, so lookups never scan the whole collection unless the query has no
+// text terms at all. Like the library it indexes, it is not safe for
+// concurrent changes, but any number of searches may run at once.
+type Index struct {
+	docs  []*document // by sequence number, nil once removed
+	bySeq map[string]int
+	terms map[string]*postings
+
+	// the sorted vocabulary is rebuilt on the first search after a change
+	vocabMu sync.Mutex
+	vocab   []string
+	dirty   bool
+}
+
+func NewIndex() *Index {
+	return &Index{
+		bySeq: make(map[string]int),
+		terms: make(map[string]*postings),
+	}
+}
+
+// Add indexes song, or re-indexes it if a song with its ID is already in the
+// index. A re-indexed song keeps its place in the order of results.
+func (ix *Index) Add(song *models.Song) {
+	seq, existing := ix.bySeq[song.ID]
+	if existing {
+		ix.unlink(ix.docs[seq])
+	} else {
+		seq = len(ix.docs)
+		ix.docs = append(ix.docs, nil)
+		ix.bySeq[song.ID] = seq
+	}
+
+	doc := &document{song: song, seq: seq}
+	for f := field(0); f < numFields; f++ {
+		for _, token := range doc.fieldTokens(f) {
+			p, ok := ix.terms[token]
+			if !ok {
+				p = &postings{}
+				ix.terms[token] = p
+				ix.dirty = true
+			}
+			p[f] = insertSeq(p[f], seq)
+		}
+	}
+	ix.docs[seq] = doc
+}
+
+// Update re-indexes a song after its fields changed.
+func (ix *Index) Update(song *models.Song) {
+	ix.Add(song)
+}
+
+func (ix *Index) Remove(id string) {
+	seq, ok := ix.bySeq[id]
+	if !ok {
+		return
+	}
+	ix.unlink(ix.docs[seq])
+	ix.docs[seq] = nil
+	delete(ix.bySeq, id)
+}
+
+// unlink takes a document out of the postings of every token it holds.
+func (ix *Index) unlink(doc *document) {
+	for f, tokens := range doc.tokens {
+		for _, token := range tokens {
+			p := ix.terms[token]
+			if p == nil {
+				continue
+			}
+			p[f] = removeSeq(p[f], doc.seq)
+			if p.empty() {
+				delete(ix.terms, token)
+				ix.dirty = true
+			}
+		}
+	}
+}
+
+func insertSeq(seqs []int, seq int) []int {
+	// New songs get the highest number, so this is nearly always an append
+	if n := len(seqs); n == 0 || seqs[n-1] < seq {
+		return append(seqs, seq)
+	}
+	i := sort.SearchInts(seqs, seq)
+	if seqs[i] == seq {
+		return seqs
+	}
+	seqs = append(seqs, 0)
+	copy(seqs[i+1:], seqs[i:])
+	seqs[i] = seq
+	return seqs
+}
+
+func removeSeq(seqs []int, seq int) []int {
+	i := sort.SearchInts(seqs, seq)
+	if i < len(seqs) && seqs[i] == seq {
+		return append(seqs[:i], seqs[i+1:]...)
+	}
+	return seqs
+}
+
+func (ix *Index) vocabulary() []string {
+	ix.vocabMu.Lock()
+	defer ix.vocabMu.Unlock()
+
+	if ix.dirty || ix.vocab == nil {
+		vocab := make([]string, 0, len(ix.terms))
+		for token := range ix.terms {
+			vocab = append(vocab, token)
+		}
+		sort.Strings(vocab)
+		ix.vocab = vocab
+		ix.dirty = false
+	}
+	return ix.vocab
+}
+
+// withPrefix calls fn for every indexed token starting with prefix.
+func (ix *Index) withPrefix(prefix string, fn func(token string, p *postings)) {
+	vocab := ix.vocabulary()
+	for i := sort.SearchStrings(vocab, prefix); i < len(vocab) && strings.HasPrefix(vocab[i], prefix); i++ {
+		if p, ok := ix.terms[vocab[i]]; ok {
+			fn(vocab[i], p)
+		}
+	}
+}
+
+// lookup returns the sequence numbers of songs with a token starting with
+// prefix in any of fields, in ascending order.
+func (ix *Index) lookup(prefix string, fields []field) []int {
+	var lists [][]int
+	ix.withPrefix(prefix, func(_ string, p *postings) {
+		for _, f := range fields {
+			if len(p[f]) > 0 {
+				lists = append(lists, p[f])
+			}
+		}
+	})
+	return unionSeqs(lists)
+}
+
+// unionSeqs merges sorted lists into one sorted list without repeats. The
+// result may share memory with the input and must not be modified.
+func unionSeqs(lists [][]int) []int {
+	switch len(lists) {
+	case 0:
+		return nil
+	case 1:
+		return lists[0]
+	}
+
+	total := 0
+	for _, list := range lists {
+		total += len(list)
+	}
+	merged := make([]int, 0, total)
+	for _, list := range lists {
+		merged = append(merged, list...)
+	}
+	sort.Ints(merged)
+
+	out := merged[:0]
+	for i, seq := range merged {
+		if i == 0 || seq != merged[i-1] {
+			out = append(out, seq)
+		}
+	}
+	return out
+}
+
+func intersectSeqs(a, b []int) []int {
+	out := make([]int, 0, min(len(a), len(b)))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+type Hit struct {
+	Song       *models.Song
+	Score      float64
+	Similarity float64
+}
+
+// Search returns the indexed songs matching q, best first. Songs that score
+// the same keep the order they were added in.
+func (ix *Index) Search(q *Query) []Hit {
+	hits := make([]Hit, 0)
+	check := func(doc *document) {
+		if doc == nil {
+			return
+		}
+		if r, ok := q.root.match(doc); ok {
+			hits = append(hits, Hit{doc.song, r.score, r.similarity})
+		}
+	}
+
+	if seqs, restricted := q.root.candidates(ix); restricted {
+		for _, seq := range seqs {
+			check(ix.docs[seq])
+		}
+	} else {
+		for _, doc := range ix.docs {
+			check(doc)
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Score > hits[j].Score
+	})
+	return hits
+}
+
+// Complete suggests up to limit indexed words starting with prefix, the ones
+// found in the most songs first. fieldName narrows the suggestions to one
+// field; empty means the fields searched by a plain term.
+func (ix *Index) Complete(fieldName, prefix string, limit int) []string {
+	fields := defaultFields
+	if fieldName != "" {
+		f, ok := fieldNames[strings.ToLower(fieldName)]
+		if !ok {
+			return nil
+		}
+		fields = []field{f}
+	}
+
+	prefix = strings.Join(Tokenize(prefix), "")
+	if prefix == "" {
+		return nil
+	}
+
+	type suggestion struct {
+		token string
+		count int
+	}
+	var suggestions []suggestion
+	ix.withPrefix(prefix, func(token string, p *postings) {
+		count := 0
+		for _, f := range fields {
+			count += len(p[f])
+		}
+		if count > 0 {
+			suggestions = append(suggestions, suggestion{token, count})
+		}
+	})
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].count > suggestions[j].count
+	})
+
+	if limit > 0 && len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	result := make([]string, len(suggestions))
+	for i, s := range suggestions {
+		result[i] = s.token
+	}
+	return result
+}
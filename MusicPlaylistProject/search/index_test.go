@@ -0,0 +1,130 @@
+package search
+
+import (
+	"fmt"
+	"math/rand"
+	"musicplaylist/models"
+	"testing"
+	"time"
+)
+
+var benchWords = []string{
+	"love", "night", "blue", "river", "fire", "dream", "city", "heart", "light", "road",
+	"summer", "rain", "moon", "gold", "wild", "ghost", "silver", "stone", "ocean", "shadow",
+	"café", "señor", "über", "naïve", "déjà", "mañana", "björk", "sigur", "rós", "señorita",
+}
+
+var benchGenres = []string{"Rock", "Jazz", "Pop", "Électronique", "Hip-Hop", "Folk", "Classical", "Metal"}
+
+// benchSongs generates n songs with titles, artists and albums drawn from a
+// small vocabulary, so queries hit realistic numbers of songs.
+func benchSongs(n int) []*models.Song {
+	r := rand.New(rand.NewSource(1))
+	phrase := func(words int) string {
+		s := benchWords[r.Intn(len(benchWords))]
+		for i := 1; i < words; i++ {
+			s += " " + benchWords[r.Intn(len(benchWords))]
+		}
+		return s
+	}
+
+	songs := make([]*models.Song, n)
+	for i := range songs {
+		songs[i] = &models.Song{
+			ID:          fmt.Sprintf("S%08d", i),
+			Title:       phrase(1 + r.Intn(4)),
+			Artist:      fmt.Sprintf("%s %d", phrase(2), r.Intn(5000)),
+			Album:       phrase(2),
+			Genre:       benchGenres[r.Intn(len(benchGenres))],
+			Year:        1950 + r.Intn(75),
+			TrackNumber: 1 + r.Intn(20),
+			Duration:    time.Duration(60+r.Intn(540)) * time.Second,
+		}
+	}
+	return songs
+}
+
+func benchIndex(songs []*models.Song) *Index {
+	ix := NewIndex()
+	for _, song := range songs {
+		ix.Add(song)
+	}
+	return ix
+}
+
+// BenchmarkIndex times building an index of 100k songs, searching it, and
+// keeping it up to date one song at a time.
+func BenchmarkIndex(b *testing.B) {
+	const size = 100_000
+	songs := benchSongs(size)
+
+	b.Run("Build", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			benchIndex(songs)
+		}
+	})
+
+	ix := benchIndex(songs)
+	queries := []string{
+		"river",
+		"cafe",
+		"sil",
+		`artist:"ghost light"`,
+		"genre:jazz year:1960..1969",
+		"love OR night -genre:rock",
+		"duration:>5m moon",
+	}
+	for _, input := range queries {
+		q, err := Parse(input)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run("Query/"+input, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				ix.Search(q)
+			}
+		})
+	}
+
+	fuzzy, err := ParseFuzzy("sommer ocaen")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Run("Query/fuzzy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ix.Search(fuzzy)
+		}
+	})
+
+	b.Run("Complete", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ix.Complete("", "s", 10)
+		}
+	})
+
+	// Each iteration takes a song out and puts it back, so the index stays
+	// at size songs throughout
+	b.Run("Remove", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			song := songs[i%size]
+			ix.Remove(song.ID)
+			b.StopTimer()
+			ix.Add(song)
+			b.StartTimer()
+		}
+	})
+	b.Run("Add", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			song := songs[i%size]
+			b.StopTimer()
+			ix.Remove(song.ID)
+			b.StartTimer()
+			ix.Add(song)
+		}
+	})
+	b.Run("Update", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ix.Update(songs[i%size])
+		}
+	})
+}
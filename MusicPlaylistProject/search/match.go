@@ -0,0 +1,264 @@
+package search
+
+import (
+	"musicplaylist/models"
+	"slices"
+	"strings"
+)
+
+// Query is a parsed search query.
+type Query struct {
+	root node
+}
+
+// Match reports whether song matches the query and how well. Higher scores
+// are better matches; the score only means something relative to other songs
+// matched by the same query. Similarity is 1 unless the query is fuzzy and
+// matched with typos, falling towards 0 the more letters had to change.
+func (q *Query) Match(song *models.Song) (score, similarity float64, ok bool) {
+	result, ok := q.root.match(&document{song: song})
+	return result.score, result.similarity, ok
+}
+
+type result struct {
+	score      float64
+	similarity float64
+}
+
+var exact = result{similarity: 1}
+
+// A node can check a single song, and can also narrow a search down to the
+// songs the index says might match, as a sorted list of their sequence
+// numbers. candidates returns false when it can't narrow anything, meaning
+// every song has to be checked.
+type node interface {
+	match(doc *document) (result, bool)
+	candidates(ix *Index) ([]int, bool)
+}
+
+type matchAll struct{}
+
+func (matchAll) match(*document) (result, bool) {
+	return exact, true
+}
+
+func (matchAll) candidates(*Index) ([]int, bool) {
+	return nil, false
+}
+
+// andNode adds up the scores of its terms and is only as similar as its
+// least similar term.
+type andNode []node
+
+func (n andNode) match(doc *document) (result, bool) {
+	total := exact
+	for _, child := range n {
+		r, ok := child.match(doc)
+		if !ok {
+			return result{}, false
+		}
+		total.score += r.score
+		total.similarity = min(total.similarity, r.similarity)
+	}
+	return total, true
+}
+
+func (n andNode) candidates(ix *Index) ([]int, bool) {
+	var seqs []int
+	restricted := false
+	for _, child := range n {
+		childSeqs, ok := child.candidates(ix)
+		if !ok {
+			continue
+		}
+		if !restricted {
+			seqs, restricted = childSeqs, true
+			continue
+		}
+		seqs = intersectSeqs(seqs, childSeqs)
+	}
+	return seqs, restricted
+}
+
+type orNode []node
+
+func (n orNode) match(doc *document) (result, bool) {
+	best, matched := result{}, false
+	for _, child := range n {
+		if r, ok := child.match(doc); ok && (!matched || r.score > best.score) {
+			best, matched = r, true
+		}
+	}
+	return best, matched
+}
+
+func (n orNode) candidates(ix *Index) ([]int, bool) {
+	lists := make([][]int, 0, len(n))
+	for _, child := range n {
+		seqs, ok := child.candidates(ix)
+		if !ok {
+			return nil, false
+		}
+		lists = append(lists, seqs)
+	}
+	return unionSeqs(lists), true
+}
+
+type notNode struct {
+	child node
+}
+
+func (n notNode) match(doc *document) (result, bool) {
+	_, ok := n.child.match(doc)
+	return exact, !ok
+}
+
+func (notNode) candidates(*Index) ([]int, bool) {
+	return nil, false
+}
+
+// fieldWeights ranks a hit in the title above one in the artist, and so on.
+var fieldWeights = [numFields]float64{
+	fieldTitle:  3,
+	fieldArtist: 2,
+	fieldAlbum:  1.5,
+	fieldGenre:  1,
+	fieldPath:   0.5,
+}
+
+// defaultFields are searched by a term that doesn't name a field.
+var defaultFields = []field{fieldTitle, fieldArtist, fieldAlbum, fieldGenre}
+
+// textTerm matches when its words appear in a row in any of its fields, the
+// last one possibly cut short, so "miles da" finds "Miles Davis". Words are
+// compared folded, ignoring case and accents. A whole-field match scores
+// above a match at the start of the field, which scores above anything else.
+//
+// A fuzzy term also matches a field holding every one of its words, in any
+// order, with a few typos each; such matches rank below all exact ones.
+type textTerm struct {
+	fields []field
+	tokens []string
+	fuzzy  bool
+}
+
+func (t textTerm) match(doc *document) (result, bool) {
+	if len(t.tokens) == 0 {
+		return exact, true
+	}
+
+	best, matched := result{}, false
+	for _, f := range t.fields {
+		tokens := doc.fieldTokens(f)
+
+		r := exact
+		switch {
+		case slices.Equal(tokens, t.tokens):
+			r.score = 3
+		case tokensAt(tokens, t.tokens, 0):
+			r.score = 2
+		case containsTokens(tokens, t.tokens):
+			r.score = 1
+		default:
+			var ok bool
+			if r, ok = t.fuzzyMatch(tokens); !ok {
+				continue
+			}
+		}
+
+		r.score *= fieldWeights[f]
+		if !matched || r.score > best.score {
+			best, matched = r, true
+		}
+	}
+	return best, matched
+}
+
+func (t textTerm) fuzzyMatch(tokens []string) (result, bool) {
+	if !t.fuzzy {
+		return result{}, false
+	}
+
+	total := 0.0
+	last := len(t.tokens) - 1
+	for i, want := range t.tokens {
+		sim, ok := fuzzyWordMatch(tokens, want, i == last)
+		if !ok {
+			return result{}, false
+		}
+		total += sim
+	}
+
+	similarity := total / float64(len(t.tokens))
+	return result{score: 0.5 * similarity, similarity: similarity}, true
+}
+
+func (t textTerm) candidates(ix *Index) ([]int, bool) {
+	if len(t.tokens) == 0 {
+		return nil, false
+	}
+
+	lookup := ix.lookup
+	if t.fuzzy {
+		lookup = ix.fuzzyLookup
+	}
+
+	var seqs []int
+	for i, token := range t.tokens {
+		if i == 0 {
+			seqs = lookup(token, t.fields)
+		} else {
+			seqs = intersectSeqs(seqs, lookup(token, t.fields))
+		}
+	}
+	return seqs, true
+}
+
+// containsTokens reports whether want appears as a run within tokens, with
+// the last word of want allowed to be a prefix.
+func containsTokens(tokens, want []string) bool {
+	for start := 0; start+len(want) <= len(tokens); start++ {
+		if tokensAt(tokens, want, start) {
+			return true
+		}
+	}
+	return false
+}
+
+func tokensAt(tokens, want []string, start int) bool {
+	if start+len(want) > len(tokens) {
+		return false
+	}
+	last := len(want) - 1
+	for i, w := range want {
+		if i == last {
+			return strings.HasPrefix(tokens[start+i], w)
+		}
+		if tokens[start+i] != w {
+			return false
+		}
+	}
+	return true
+}
+
+// numberTerm matches a numeric field within [min, max]. Durations are
+// compared in nanoseconds.
+type numberTerm struct {
+	field    string
+	min, max int64
+}
+
+func (t numberTerm) match(doc *document) (result, bool) {
+	var value int64
+	switch t.field {
+	case "year":
+		value = int64(doc.song.Year)
+	case "duration":
+		value = int64(doc.song.Duration)
+	}
+	return result{score: 1, similarity: 1}, value >= t.min && value <= t.max
+}
+
+func (numberTerm) candidates(*Index) ([]int, bool) {
+	return nil, false
+}
@@ -0,0 +1,435 @@
+// Package search parses song search queries such as
+//
+//	artist:"Miles Davis" year:1955..1965 -genre:live duration:>5m
+//
+// Terms next to each other must all match, OR between terms matches either
+// side, and a leading - (or NOT) excludes songs matching the term. Terms can
+// be grouped with parentheses. A term without a field is looked up in the
+// title, artist, album and genre. Text is matched word by word, ignoring case
+// and accents, and the last word of a term may be the start of a word.
+package search
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParseError reports where in the query parsing failed. Pos is the 1-based
+// character position.
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos, e.Msg)
+}
+
+var numberFields = map[string]bool{
+	"year":     true,
+	"duration": true,
+}
+
+type tokenKind int
+
+const (
+	tokenTerm tokenKind = iota
+	tokenOr
+	tokenAnd
+	tokenNot
+	tokenLParen
+	tokenRParen
+	tokenEnd
+)
+
+type token struct {
+	kind  tokenKind
+	field string
+	value string
+	pos   int // byte offset into the query
+}
+
+type lexer struct {
+	input string
+	pos   int
+}
+
+func (l *lexer) errorAt(offset int, format string, args ...any) *ParseError {
+	return &ParseError{
+		Pos: utf8.RuneCountInString(l.input[:offset]) + 1,
+		Msg: fmt.Sprintf(format, args...),
+	}
+}
+
+// peekRune returns the rune at the current position and its width in bytes.
+// Invalid UTF-8 decodes as utf8.RuneError one byte wide, so skipping by the
+// width always moves forward within the input.
+func (l *lexer) peekRune() (rune, int) {
+	return utf8.DecodeRuneInString(l.input[l.pos:])
+}
+
+func isTermEnd(r rune) bool {
+	return unicode.IsSpace(r) || r == '(' || r == ')' || r == '"'
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.input) {
+		r, size := l.peekRune()
+		if !unicode.IsSpace(r) {
+			break
+		}
+		l.pos += size
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return token{kind: tokenEnd, pos: start}, nil
+	}
+
+	switch l.input[l.pos] {
+	case '(':
+		l.pos++
+		return token{kind: tokenLParen, pos: start}, nil
+	case ')':
+		l.pos++
+		return token{kind: tokenRParen, pos: start}, nil
+	case '-':
+		l.pos++
+		return token{kind: tokenNot, pos: start}, nil
+	case '"':
+		value, err := l.quoted()
+		if err != nil {
+			return token{}, err
+		}
+		return token{kind: tokenTerm, value: value, pos: start}, nil
+	}
+
+	word := l.word()
+	if l.pos < len(l.input) && l.input[l.pos] == ':' {
+		l.pos++
+		field := strings.ToLower(word)
+		if _, ok := fieldNames[field]; !ok && !numberFields[field] {
+			return token{}, l.errorAt(start, "unknown field %q", word)
+		}
+
+		if l.pos < len(l.input) && l.input[l.pos] == '"' {
+			value, err := l.quoted()
+			if err != nil {
+				return token{}, err
+			}
+			return token{kind: tokenTerm, field: field, value: value, pos: start}, nil
+		}
+
+		value := l.value()
+		if value == "" {
+			return token{}, l.errorAt(l.pos, "missing value for %s", field)
+		}
+		return token{kind: tokenTerm, field: field, value: value, pos: start}, nil
+	}
+
+	switch word {
+	case "OR":
+		return token{kind: tokenOr, pos: start}, nil
+	case "AND":
+		return token{kind: tokenAnd, pos: start}, nil
+	case "NOT":
+		return token{kind: tokenNot, pos: start}, nil
+	}
+	return token{kind: tokenTerm, value: word, pos: start}, nil
+}
+
+// word reads up to the next space, parenthesis, quote or field separator.
+func (l *lexer) word() string {
+	return l.readUntil(func(r rune) bool { return isTermEnd(r) || r == ':' })
+}
+
+// value reads a field's value, which may hold colons as in duration:3:30.
+func (l *lexer) value() string {
+	return l.readUntil(isTermEnd)
+}
+
+func (l *lexer) readUntil(stop func(rune) bool) string {
+	start := l.pos
+	for l.pos < len(l.input) {
+		r, size := l.peekRune()
+		if stop(r) {
+			break
+		}
+		l.pos += size
+	}
+	return l.input[start:l.pos]
+}
+
+func (l *lexer) quoted() (string, error) {
+	start := l.pos
+	end := strings.IndexByte(l.input[start+1:], '"')
+	if end < 0 {
+		return "", l.errorAt(start, "unterminated quote")
+	}
+	l.pos = start + 1 + end + 1
+	return l.input[start+1 : start+1+end], nil
+}
+
+type parser struct {
+	lexer   *lexer
+	current token
+	fuzzy   bool
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.current = tok
+	return nil
+}
+
+// Parse turns a query into something that can be matched against songs. An
+// empty query matches every song.
+func Parse(input string) (*Query, error) {
+	return parse(input, false)
+}
+
+// ParseFuzzy is Parse for a query whose words may also match with typos.
+func ParseFuzzy(input string) (*Query, error) {
+	return parse(input, true)
+}
+
+func parse(input string, fuzzy bool) (*Query, error) {
+	p := &parser{lexer: &lexer{input: input}, fuzzy: fuzzy}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.current.kind == tokenEnd {
+		return &Query{root: matchAll{}}, nil
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.current.kind == tokenRParen {
+		return nil, p.lexer.errorAt(p.current.pos, "unexpected )")
+	}
+	return &Query{root: root}, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	children := []node{left}
+	for p.current.kind == tokenOr {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, right)
+	}
+
+	if len(children) == 1 {
+		return left, nil
+	}
+	return orNode(children), nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	var children []node
+	for {
+		switch p.current.kind {
+		case tokenEnd, tokenOr, tokenRParen:
+			if len(children) == 0 {
+				return nil, p.lexer.errorAt(p.current.pos, "expected a search term")
+			}
+			if len(children) == 1 {
+				return children[0], nil
+			}
+			return andNode(children), nil
+		case tokenAnd:
+			if len(children) == 0 {
+				return nil, p.lexer.errorAt(p.current.pos, "AND needs a term before it")
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.current.kind != tokenNot {
+		return p.parsePrimary()
+	}
+
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	child, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return notNode{child}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.current
+
+	switch tok.kind {
+	case tokenLParen:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.current.kind != tokenRParen {
+			return nil, p.lexer.errorAt(tok.pos, "unclosed (")
+		}
+		return inner, p.advance()
+
+	case tokenTerm:
+		term, err := p.term(tok)
+		if err != nil {
+			return nil, err
+		}
+		return term, p.advance()
+	}
+
+	return nil, p.lexer.errorAt(tok.pos, "expected a search term")
+}
+
+func (p *parser) term(tok token) (node, error) {
+	if !numberFields[tok.field] {
+		fields := defaultFields
+		if tok.field != "" {
+			fields = []field{fieldNames[tok.field]}
+		}
+		return textTerm{fields: fields, tokens: Tokenize(tok.value), fuzzy: p.fuzzy}, nil
+	}
+
+	parse := parseYear
+	if tok.field == "duration" {
+		parse = parseDuration
+	}
+	valuePos := tok.pos + len(tok.field) + 1
+
+	r, err := parseRange(tok.value, parse)
+	if err != nil {
+		return nil, p.lexer.errorAt(valuePos, "%s: %v", tok.field, err)
+	}
+	r.field = tok.field
+	return r, nil
+}
+
+// parseRange reads "x", "a..b" (either side may be left open), or a
+// comparison such as ">x" or "<=x".
+func parseRange(value string, parse func(string) (int64, int64, error)) (numberTerm, error) {
+	r := numberTerm{min: math.MinInt64, max: math.MaxInt64}
+
+	if lo, hi, ok := strings.Cut(value, ".."); ok {
+		if lo == "" && hi == "" {
+			return r, fmt.Errorf("empty range")
+		}
+		if lo != "" {
+			from, _, err := parse(lo)
+			if err != nil {
+				return r, err
+			}
+			r.min = from
+		}
+		if hi != "" {
+			_, to, err := parse(hi)
+			if err != nil {
+				return r, err
+			}
+			r.max = to
+		}
+		if r.min > r.max {
+			return r, fmt.Errorf("range %s is backwards", value)
+		}
+		return r, nil
+	}
+
+	for _, op := range []string{">=", "<=", ">", "<"} {
+		rest, ok := strings.CutPrefix(value, op)
+		if !ok {
+			continue
+		}
+		from, to, err := parse(rest)
+		if err != nil {
+			return r, err
+		}
+		switch op {
+		case ">=":
+			r.min = from
+		case "<=":
+			r.max = to
+		case ">":
+			r.min = to + 1
+		case "<":
+			r.max = from - 1
+		}
+		return r, nil
+	}
+
+	from, to, err := parse(value)
+	if err != nil {
+		return r, err
+	}
+	r.min, r.max = from, to
+	return r, nil
+}
+
+// parseYear returns a single year as the span [year, year].
+func parseYear(value string) (int64, int64, error) {
+	year, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%q is not a year", value)
+	}
+	return year, year, nil
+}
+
+// parseDuration accepts Go durations (5m, 1m30s), clock notation (3:30) and
+// plain seconds. The span covers the whole second the value names, so
+// duration:3:30 matches anything from 3:30.000 up to 3:30.999.
+func parseDuration(value string) (int64, int64, error) {
+	var d time.Duration
+
+	if strings.Contains(value, ":") {
+		var total int64
+		for _, part := range strings.Split(value, ":") {
+			n, err := strconv.ParseInt(part, 10, 64)
+			if err != nil || n < 0 {
+				return 0, 0, fmt.Errorf("%q is not a duration", value)
+			}
+			total = total*60 + n
+		}
+		d = time.Duration(total) * time.Second
+	} else if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if parsed, err := time.ParseDuration(value); err == nil {
+		d = parsed
+	} else {
+		return 0, 0, fmt.Errorf("%q is not a duration", value)
+	}
+
+	return int64(d), int64(d + time.Second - 1), nil
+}
@@ -0,0 +1,60 @@
+package search
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseEdgeCases(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{"\xa8", false},
+		{"blue \xa8\xff river", false},
+		{"artist:\xa8", false},
+		{"\xe2\x82", false}, // a multi-byte rune cut short
+		{`"`, true},
+		{`title:"`, true},
+		{`blue "`, true},
+		{"(", true},
+		{")", true},
+		{"blue (", true},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, want error %v", tt.query, err, tt.wantErr)
+		}
+		var perr *ParseError
+		if err != nil && !errors.As(err, &perr) {
+			t.Errorf("Parse(%q) error = %v, want a *ParseError", tt.query, err)
+		}
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		`artist:"Miles Davis" year:1955..1965 -genre:live duration:>5m`,
+		"blue OR (red NOT green)",
+		"\xa8",
+		"title:\xff\xfe",
+		"\xe2\x82",
+		`"`,
+		`artist:"`,
+		"(",
+		")",
+		"blue -",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, query string) {
+		// Any input either parses or fails with a position, it never panics
+		if _, err := Parse(query); err != nil {
+			var perr *ParseError
+			if !errors.As(err, &perr) {
+				t.Errorf("Parse(%q) error = %v, want a *ParseError", query, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// The steps writeFileAtomic takes to put data in place, which tests replace to
+// make each of them fail.
+var (
+	writeTemp  = (*os.File).Write
+	syncTemp   = (*os.File).Sync
+	renameFile = os.Rename
+)
+
+// writeFileAtomic replaces the file at path with data so that a crash or a
+// full disk leaves either the old file or the new one, never a mix. The data
+// goes to a temporary file in the same directory, which is synced and then
+// renamed over the old file. An existing file keeps its mode; a new one gets
+// perm.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) (err error) {
+	// Write through a symlink rather than replacing it
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err = tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if _, err = writeTemp(tmp, data); err != nil {
+		return err
+	}
+	if err = syncTemp(tmp); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = renameFile(tmp.Name(), path); err != nil {
+		return err
+	}
+	// The rename itself is only durable once the directory is synced
+	return syncDir(dir)
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"musicplaylist/models"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFailedSaveKeepsOldFile(t *testing.T) {
+	errStep := errors.New("step failed")
+	steps := []struct {
+		name string
+		fail func() (restore func())
+	}{
+		{"write", func() func() {
+			saved := writeTemp
+			// Get part of the data out before failing, as a full disk would
+			writeTemp = func(f *os.File, data []byte) (int, error) {
+				n, _ := saved(f, data[:len(data)/2])
+				return n, errStep
+			}
+			return func() { writeTemp = saved }
+		}},
+		{"sync", func() func() {
+			saved := syncTemp
+			syncTemp = func(*os.File) error { return errStep }
+			return func() { syncTemp = saved }
+		}},
+		{"rename", func() func() {
+			saved := renameFile
+			renameFile = func(string, string) error { return errStep }
+			return func() { renameFile = saved }
+		}},
+	}
+
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "playlists.json")
+			store := NewJSONStorage(path)
+			if err := store.SavePlaylists([]*models.Playlist{models.NewPlaylist("Old", "")}); err != nil {
+				t.Fatal(err)
+			}
+			old, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			restore := step.fail()
+			err = store.SavePlaylists([]*models.Playlist{models.NewPlaylist("New", "")})
+			restore()
+			if !errors.Is(err, errStep) {
+				t.Fatalf("save error = %v, want the %s failure", err, step.name)
+			}
+
+			if now, err := os.ReadFile(path); err != nil || !bytes.Equal(now, old) {
+				t.Errorf("playlists.json changed by a failed save: %s", now)
+			}
+			playlists, err := store.LoadPlaylists()
+			if err != nil || len(playlists) != 1 || playlists[0].Name != "Old" {
+				t.Errorf("loaded %v, %v after a failed save, want the old playlist", playlists, err)
+			}
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				if strings.HasSuffix(entry.Name(), ".tmp") {
+					t.Errorf("temporary file %s left behind", entry.Name())
+				}
+			}
+		})
+	}
+}
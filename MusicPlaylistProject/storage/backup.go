@@ -0,0 +1,335 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"musicplaylist/models"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Snapshot is the whole collection as it was saved at one moment.
+type Snapshot struct {
+	CreatedAt time.Time          `json:"createdAt"`
+	Playlists []*models.Playlist `json:"playlists"`
+	Library   []*models.Song     `json:"library"`
+	ScanRoots []*models.ScanRoot `json:"scanRoots"`
+}
+
+type BackupInfo struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Size      int64     `json:"size"`
+}
+
+// BackupStore is implemented by storage that keeps snapshots of the
+// collection to go back to.
+type BackupStore interface {
+	// Backup snapshots the stored collection now, whatever the policy says
+	Backup() error
+	ListBackups() ([]BackupInfo, error)
+	ReadBackup(name string) (*Snapshot, error)
+}
+
+// BackupPolicy says how often snapshots are taken and which are kept. A
+// snapshot is kept if it is one of the Last newest, the newest of its day
+// within the last Daily days, or the newest of its week within the last
+// Weekly weeks.
+type BackupPolicy struct {
+	// MinInterval is the least time between snapshots, 0 takes one on every
+	// save
+	MinInterval time.Duration
+	Last        int
+	Daily       int
+	Weekly      int
+}
+
+var DefaultBackupPolicy = BackupPolicy{
+	MinInterval: 5 * time.Minute,
+	Last:        10,
+	Daily:       7,
+	Weekly:      4,
+}
+
+const backupTimeFormat = "20060102-150405.000"
+
+// BackupStorage wraps another storage and, before a save overwrites the
+// collection, copies what was there into a snapshot file in dir. The newest
+// state is always the one in the wrapped storage, so the snapshots hold every
+// earlier save the policy keeps.
+//
+// A save is taken to start with SavePlaylists or Begin, which is where the
+// snapshot is made.
+type BackupStorage struct {
+	inner  IncrementalStorage
+	dir    string
+	policy BackupPolicy
+
+	mu   sync.Mutex
+	last time.Time
+	// fresh is set when the stored collection was backed up by Backup and
+	// hasn't been saved over since
+	fresh bool
+	// lastSum identifies the contents of the last snapshot taken
+	lastSum [sha256.Size]byte
+
+	// Warn is told when a snapshot can't be taken, which doesn't stop the
+	// save. Failures are dropped when it's nil.
+	Warn func(err error)
+}
+
+func NewBackupStorage(inner Storage, dir string, policy BackupPolicy) *BackupStorage {
+	bs := &BackupStorage{inner: Incremental(inner), dir: dir, policy: policy}
+	if backups, err := bs.ListBackups(); err == nil && len(backups) > 0 {
+		bs.last = backups[0].CreatedAt
+	}
+	return bs
+}
+
+func (bs *BackupStorage) SavePlaylists(playlists []*models.Playlist) error {
+	if err := bs.snapshot(false); err != nil {
+		// A failed backup must not stop the save it was protecting
+		bs.warn(err)
+	}
+	return bs.inner.SavePlaylists(playlists)
+}
+
+func (bs *BackupStorage) warn(err error) {
+	if bs.Warn != nil {
+		bs.Warn(fmt.Errorf("could not back up playlists: %w", err))
+	}
+}
+
+func (bs *BackupStorage) LoadPlaylists() ([]*models.Playlist, error) {
+	return bs.inner.LoadPlaylists()
+}
+
+func (bs *BackupStorage) SaveLibrary(songs []*models.Song) error {
+	return bs.inner.SaveLibrary(songs)
+}
+
+func (bs *BackupStorage) LoadLibrary() ([]*models.Song, error) {
+	return bs.inner.LoadLibrary()
+}
+
+func (bs *BackupStorage) SaveScanRoots(roots []*models.ScanRoot) error {
+	return bs.inner.SaveScanRoots(roots)
+}
+
+func (bs *BackupStorage) LoadScanRoots() ([]*models.ScanRoot, error) {
+	return bs.inner.LoadScanRoots()
+}
+
+// Begin snapshots the stored collection, as SavePlaylists does, before the
+// batch changes it.
+func (bs *BackupStorage) Begin() (Batch, error) {
+	if err := bs.snapshot(false); err != nil {
+		bs.warn(err)
+	}
+	return bs.inner.Begin()
+}
+
+func (bs *BackupStorage) Lock() (func(), error) {
+	if shared, ok := bs.inner.(SharedStorage); ok {
+		return shared.Lock()
+	}
+	return func() {}, nil
+}
+
+func (bs *BackupStorage) Stamp() (string, error) {
+	if shared, ok := bs.inner.(SharedStorage); ok {
+		return shared.Stamp()
+	}
+	return "", nil
+}
+
+func (bs *BackupStorage) Compact() error {
+	if journaled, ok := bs.inner.(JournaledStorage); ok {
+		return journaled.Compact()
+	}
+	return nil
+}
+
+func (bs *BackupStorage) History() ([]JournalEntry, error) {
+	if journaled, ok := bs.inner.(JournaledStorage); ok {
+		return journaled.History()
+	}
+	return make([]JournalEntry, 0), nil
+}
+
+func (bs *BackupStorage) Annotate(entries ...JournalEntry) error {
+	if journaled, ok := bs.inner.(JournaledStorage); ok {
+		return journaled.Annotate(entries...)
+	}
+	return errors.New("changes are not journaled")
+}
+
+func (bs *BackupStorage) Backup() error {
+	return bs.snapshot(true)
+}
+
+// snapshot copies the stored collection into a new backup, unless nothing
+// has been saved yet or, without force, one was taken too recently.
+func (bs *BackupStorage) snapshot(force bool) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	now := time.Now().UTC()
+	if !force {
+		fresh := bs.fresh
+		bs.fresh = false
+		if fresh || (bs.policy.MinInterval > 0 && now.Sub(bs.last) < bs.policy.MinInterval) {
+			return nil
+		}
+	}
+
+	snap := &Snapshot{}
+	var err error
+	if snap.Playlists, err = bs.inner.LoadPlaylists(); err != nil {
+		return err
+	}
+	if snap.Library, err = bs.LoadLibrary(); err != nil {
+		return err
+	}
+	if snap.ScanRoots, err = bs.LoadScanRoots(); err != nil {
+		return err
+	}
+	if len(snap.Playlists) == 0 && len(snap.Library) == 0 && len(snap.ScanRoots) == 0 {
+		return nil
+	}
+
+	// Saves that changed nothing don't need a snapshot each
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("failed to marshal backup: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	if sum == bs.lastSum {
+		bs.fresh = force
+		return nil
+	}
+
+	snap.CreatedAt = now
+	if data, err = json.MarshalIndent(snap, "", "  "); err != nil {
+		return fmt.Errorf("failed to marshal backup: %w", err)
+	}
+	if err := os.MkdirAll(bs.dir, 0755); err != nil {
+		return err
+	}
+	name := "backup-" + now.Format(backupTimeFormat) + ".json"
+	if err := writeFileAtomic(filepath.Join(bs.dir, name), data, 0644); err != nil {
+		return fmt.Errorf("failed to write backup: %w", err)
+	}
+	bs.last = now
+	bs.lastSum = sum
+	bs.fresh = force
+
+	return bs.prune(now)
+}
+
+// ListBackups lists the snapshots, newest first.
+func (bs *BackupStorage) ListBackups() ([]BackupInfo, error) {
+	entries, err := os.ReadDir(bs.dir)
+	if os.IsNotExist(err) {
+		return make([]BackupInfo, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backups := make([]BackupInfo, 0, len(entries))
+	for _, entry := range entries {
+		created, ok := backupTime(entry.Name())
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupInfo{Name: entry.Name(), CreatedAt: created, Size: info.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].CreatedAt.After(backups[j].CreatedAt)
+	})
+	return backups, nil
+}
+
+func (bs *BackupStorage) ReadBackup(name string) (*Snapshot, error) {
+	if _, ok := backupTime(name); !ok || filepath.Base(name) != name {
+		return nil, errors.New("backup not found")
+	}
+	data, err := os.ReadFile(filepath.Join(bs.dir, name))
+	if os.IsNotExist(err) {
+		return nil, errors.New("backup not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal backup: %w", err)
+	}
+	return &snap, nil
+}
+
+func backupTime(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, "backup-")
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, ok = strings.CutSuffix(stamp, ".json")
+	if !ok {
+		return time.Time{}, false
+	}
+	created, err := time.Parse(backupTimeFormat, stamp)
+	return created, err == nil
+}
+
+// prune deletes the snapshots the policy no longer keeps.
+func (bs *BackupStorage) prune(now time.Time) error {
+	backups, err := bs.ListBackups()
+	if err != nil {
+		return err
+	}
+
+	keep := make(map[string]bool)
+	for i := 0; i < len(backups) && i < bs.policy.Last; i++ {
+		keep[backups[i].Name] = true
+	}
+
+	// Newest first, so the first snapshot seen in a day or week is kept
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	dailyFrom := now.AddDate(0, 0, -bs.policy.Daily)
+	weeklyFrom := now.AddDate(0, 0, -7*bs.policy.Weekly)
+	for _, backup := range backups {
+		day := backup.CreatedAt.Format("2006-01-02")
+		if backup.CreatedAt.After(dailyFrom) && !days[day] {
+			days[day] = true
+			keep[backup.Name] = true
+		}
+		year, week := backup.CreatedAt.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%d", year, week)
+		if backup.CreatedAt.After(weeklyFrom) && !weeks[weekKey] {
+			weeks[weekKey] = true
+			keep[backup.Name] = true
+		}
+	}
+
+	for _, backup := range backups {
+		if !keep[backup.Name] {
+			if err := os.Remove(filepath.Join(bs.dir, backup.Name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,598 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"musicplaylist/models"
+	"slices"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Buckets of the database. Songs and playlists are stored once each by ID;
+// the songs of a playlist are a bucket per playlist mapping positions to
+// song IDs, so reordering or adding a song touches only that playlist. The
+// library keeps its order in a bucket mapping sequence numbers, given out as
+// songs are first stored, to song IDs, and one mapping the IDs back.
+var (
+	bucketMeta          = []byte("meta")
+	bucketSongs         = []byte("songs")
+	bucketSongOrder     = []byte("song_order")
+	bucketSongSeqs      = []byte("song_seqs")
+	bucketPlaylists     = []byte("playlists")
+	bucketPlaylistSongs = []byte("playlist_songs")
+	bucketScanRoots     = []byte("scan_roots")
+
+	keySchemaVersion = []byte("schema_version")
+	keyPlaylistOrder = []byte("playlist_order")
+)
+
+const boltSchemaVersion = 1
+
+// BoltStorage keeps the collection in a single bbolt database file. Saves
+// only write the records that changed since the last save, so a large
+// library costs little to save after a small edit.
+type BoltStorage struct {
+	db *bolt.DB
+}
+
+// storedPlaylist is a playlist without its songs, which are kept apart.
+type storedPlaylist struct {
+	ID          string                `json:"id"`
+	Name        string                `json:"name"`
+	Description string                `json:"description"`
+	CreatedAt   time.Time             `json:"created_at"`
+	UpdatedAt   time.Time             `json:"updated_at"`
+	Smart       *models.SmartCriteria `json:"smart,omitempty"`
+}
+
+// OpenBoltStorage opens the database at path, creating it if needed. Only
+// one process can have it open at a time.
+func OpenBoltStorage(path string) (*BoltStorage, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is in use by another process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		ordered := tx.Bucket(bucketSongOrder) != nil
+		for _, name := range [][]byte{bucketMeta, bucketSongs, bucketSongOrder, bucketSongSeqs, bucketPlaylists, bucketPlaylistSongs, bucketScanRoots} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		// Databases from before the library kept its order get the order of
+		// the song IDs
+		if !ordered {
+			err := tx.Bucket(bucketSongs).ForEach(func(id, _ []byte) error {
+				return orderSong(tx, id)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		meta := tx.Bucket(bucketMeta)
+		version := meta.Get(keySchemaVersion)
+		if version == nil {
+			return meta.Put(keySchemaVersion, itob(boltSchemaVersion))
+		}
+		if v := binary.BigEndian.Uint64(version); v != boltSchemaVersion {
+			return fmt.Errorf("database schema version %d is not supported", v)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStorage{db: db}, nil
+}
+
+func (bs *BoltStorage) Close() error {
+	return bs.db.Close()
+}
+
+func (bs *BoltStorage) SavePlaylists(playlists []*models.Playlist) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		stored := tx.Bucket(bucketPlaylists)
+		members := tx.Bucket(bucketPlaylistSongs)
+
+		order := make([]string, len(playlists))
+		keep := make(map[string]bool, len(playlists))
+		for i, playlist := range playlists {
+			order[i] = playlist.ID
+			keep[playlist.ID] = true
+
+			header := storedPlaylist{
+				ID:          playlist.ID,
+				Name:        playlist.Name,
+				Description: playlist.Description,
+				CreatedAt:   playlist.CreatedAt,
+				UpdatedAt:   playlist.UpdatedAt,
+				Smart:       playlist.Smart,
+			}
+			if err := putJSON(stored, []byte(playlist.ID), header); err != nil {
+				return err
+			}
+
+			// Smart playlists are filled in again from their rules on load
+			var songIDs []string
+			if !playlist.IsSmart() {
+				songIDs = make([]string, len(playlist.Songs))
+				for j, song := range playlist.Songs {
+					songIDs[j] = song.ID
+					if err := putSong(tx, song); err != nil {
+						return err
+					}
+				}
+			}
+			if err := putMembers(members, playlist.ID, songIDs); err != nil {
+				return err
+			}
+		}
+
+		if err := deleteMissing(stored, keep); err != nil {
+			return err
+		}
+		if err := deleteMissingBuckets(members, keep); err != nil {
+			return err
+		}
+		return putJSON(tx.Bucket(bucketMeta), keyPlaylistOrder, order)
+	})
+}
+
+func (bs *BoltStorage) LoadPlaylists() ([]*models.Playlist, error) {
+	playlists := make([]*models.Playlist, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		stored := tx.Bucket(bucketPlaylists)
+		members := tx.Bucket(bucketPlaylistSongs)
+		songs := tx.Bucket(bucketSongs)
+
+		var order []string
+		if data := tx.Bucket(bucketMeta).Get(keyPlaylistOrder); data != nil {
+			if err := json.Unmarshal(data, &order); err != nil {
+				return fmt.Errorf("failed to unmarshal playlist order: %w", err)
+			}
+		}
+
+		for _, id := range order {
+			data := stored.Get([]byte(id))
+			if data == nil {
+				continue
+			}
+			var header storedPlaylist
+			if err := json.Unmarshal(data, &header); err != nil {
+				return fmt.Errorf("failed to unmarshal playlist %s: %w", id, err)
+			}
+
+			playlist := &models.Playlist{
+				ID:          header.ID,
+				Name:        header.Name,
+				Description: header.Description,
+				Songs:       make([]*models.Song, 0),
+				CreatedAt:   header.CreatedAt,
+				UpdatedAt:   header.UpdatedAt,
+				Smart:       header.Smart,
+			}
+			if list := members.Bucket([]byte(id)); list != nil {
+				err := list.ForEach(func(_, songID []byte) error {
+					data := songs.Get(songID)
+					if data == nil {
+						return nil
+					}
+					var song models.Song
+					if err := json.Unmarshal(data, &song); err != nil {
+						return fmt.Errorf("failed to unmarshal song %s: %w", songID, err)
+					}
+					playlist.Songs = append(playlist.Songs, &song)
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			playlists = append(playlists, playlist)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return playlists, nil
+}
+
+// SaveLibrary stores songs, new ones after those already stored, and drops
+// the songs it isn't given.
+func (bs *BoltStorage) SaveLibrary(songs []*models.Song) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		keep := make(map[string]bool, len(songs))
+		for _, song := range songs {
+			keep[song.ID] = true
+			if err := putSong(tx, song); err != nil {
+				return err
+			}
+		}
+
+		var stale []string
+		err := tx.Bucket(bucketSongs).ForEach(func(id, _ []byte) error {
+			if !keep[string(id)] {
+				stale = append(stale, string(id))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, id := range stale {
+			if err := deleteSong(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LoadLibrary returns every stored song, in the order they were first
+// stored.
+func (bs *BoltStorage) LoadLibrary() ([]*models.Song, error) {
+	songs := make([]*models.Song, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		stored := tx.Bucket(bucketSongs)
+		return tx.Bucket(bucketSongOrder).ForEach(func(_, id []byte) error {
+			data := stored.Get(id)
+			if data == nil {
+				return nil
+			}
+			var song models.Song
+			if err := json.Unmarshal(data, &song); err != nil {
+				return fmt.Errorf("failed to unmarshal song %s: %w", id, err)
+			}
+			songs = append(songs, &song)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return songs, nil
+}
+
+func (bs *BoltStorage) SaveScanRoots(roots []*models.ScanRoot) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketScanRoots)
+		keep := make(map[string]bool, len(roots))
+		for _, root := range roots {
+			keep[root.Path] = true
+			if err := putJSON(bucket, []byte(root.Path), root); err != nil {
+				return err
+			}
+		}
+		return deleteMissing(bucket, keep)
+	})
+}
+
+func (bs *BoltStorage) LoadScanRoots() ([]*models.ScanRoot, error) {
+	roots := make([]*models.ScanRoot, 0)
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketScanRoots).ForEach(func(path, data []byte) error {
+			var root models.ScanRoot
+			if err := json.Unmarshal(data, &root); err != nil {
+				return fmt.Errorf("failed to unmarshal scan root %s: %w", path, err)
+			}
+			roots = append(roots, &root)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roots, nil
+}
+
+// Begin starts a write transaction, which holds off every other write to
+// the database until it is committed or rolled back.
+func (bs *BoltStorage) Begin() (Batch, error) {
+	tx, err := bs.db.Begin(true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return &boltBatch{tx: tx}, nil
+}
+
+type boltBatch struct {
+	tx *bolt.Tx
+}
+
+func (bb *boltBatch) playlistOrder() ([]string, error) {
+	var order []string
+	if data := bb.tx.Bucket(bucketMeta).Get(keyPlaylistOrder); data != nil {
+		if err := json.Unmarshal(data, &order); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal playlist order: %w", err)
+		}
+	}
+	return order, nil
+}
+
+func (bb *boltBatch) UpsertPlaylist(playlist *models.Playlist) error {
+	stored := bb.tx.Bucket(bucketPlaylists)
+	isNew := stored.Get([]byte(playlist.ID)) == nil
+
+	header := storedPlaylist{
+		ID:          playlist.ID,
+		Name:        playlist.Name,
+		Description: playlist.Description,
+		CreatedAt:   playlist.CreatedAt,
+		UpdatedAt:   playlist.UpdatedAt,
+		Smart:       playlist.Smart,
+	}
+	if err := putJSON(stored, []byte(playlist.ID), header); err != nil {
+		return err
+	}
+	if !isNew {
+		return nil
+	}
+
+	order, err := bb.playlistOrder()
+	if err != nil {
+		return err
+	}
+	return putJSON(bb.tx.Bucket(bucketMeta), keyPlaylistOrder, append(order, playlist.ID))
+}
+
+func (bb *boltBatch) DeletePlaylist(id string) error {
+	if err := bb.tx.Bucket(bucketPlaylists).Delete([]byte(id)); err != nil {
+		return err
+	}
+	members := bb.tx.Bucket(bucketPlaylistSongs)
+	if members.Bucket([]byte(id)) != nil {
+		if err := members.DeleteBucket([]byte(id)); err != nil {
+			return err
+		}
+	}
+
+	order, err := bb.playlistOrder()
+	if err != nil {
+		return err
+	}
+	order = slices.DeleteFunc(order, func(stored string) bool {
+		return stored == id
+	})
+	return putJSON(bb.tx.Bucket(bucketMeta), keyPlaylistOrder, order)
+}
+
+// members returns the bucket holding the songs of a stored playlist.
+func (bb *boltBatch) members(playlistID string) (*bolt.Bucket, error) {
+	if bb.tx.Bucket(bucketPlaylists).Get([]byte(playlistID)) == nil {
+		return nil, errors.New("playlist not found")
+	}
+	return bb.tx.Bucket(bucketPlaylistSongs).CreateBucketIfNotExists([]byte(playlistID))
+}
+
+func (bb *boltBatch) InsertSongs(playlistID string, index int, songIDs []string) error {
+	list, err := bb.members(playlistID)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index > memberCount(list) {
+		return fmt.Errorf("song index %d out of range", index)
+	}
+	tail := membersFrom(list, index)
+	return setMembers(list, index, append(slices.Clip(songIDs), tail...))
+}
+
+func (bb *boltBatch) RemoveSongs(playlistID string, index, count int) error {
+	list, err := bb.members(playlistID)
+	if err != nil {
+		return err
+	}
+	if index < 0 || count < 0 || index+count > memberCount(list) {
+		return fmt.Errorf("song range %d+%d out of range", index, count)
+	}
+	return setMembers(list, index, membersFrom(list, index+count))
+}
+
+func (bb *boltBatch) ReorderSongs(playlistID string, songIDs []string) error {
+	list, err := bb.members(playlistID)
+	if err != nil {
+		return err
+	}
+	return setMembers(list, 0, songIDs)
+}
+
+func (bb *boltBatch) UpsertSong(song *models.Song) error {
+	return putSong(bb.tx, song)
+}
+
+func (bb *boltBatch) DeleteSong(id string) error {
+	return deleteSong(bb.tx, id)
+}
+
+func (bb *boltBatch) UpsertScanRoot(root *models.ScanRoot) error {
+	return putJSON(bb.tx.Bucket(bucketScanRoots), []byte(root.Path), root)
+}
+
+func (bb *boltBatch) DeleteScanRoot(path string) error {
+	return bb.tx.Bucket(bucketScanRoots).Delete([]byte(path))
+}
+
+func (bb *boltBatch) Commit() error {
+	return bb.tx.Commit()
+}
+
+func (bb *boltBatch) Rollback() error {
+	return bb.tx.Rollback()
+}
+
+// putJSON stores value under key unless it is stored there already, so
+// unchanged records don't dirty any pages.
+func putJSON(bucket *bolt.Bucket, key []byte, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", key, err)
+	}
+	if bytes.Equal(bucket.Get(key), data) {
+		return nil
+	}
+	return bucket.Put(key, data)
+}
+
+// putSong stores a song, giving it the next place in the library's order if
+// it is new.
+func putSong(tx *bolt.Tx, song *models.Song) error {
+	if err := putJSON(tx.Bucket(bucketSongs), []byte(song.ID), song); err != nil {
+		return err
+	}
+	return orderSong(tx, []byte(song.ID))
+}
+
+// orderSong puts a song at the end of the library's order, unless it has a
+// place in it already.
+func orderSong(tx *bolt.Tx, id []byte) error {
+	seqs := tx.Bucket(bucketSongSeqs)
+	if seqs.Get(id) != nil {
+		return nil
+	}
+	order := tx.Bucket(bucketSongOrder)
+	seq, err := order.NextSequence()
+	if err != nil {
+		return err
+	}
+	id = append([]byte(nil), id...)
+	if err := order.Put(itob(seq), id); err != nil {
+		return err
+	}
+	return seqs.Put(id, itob(seq))
+}
+
+func deleteSong(tx *bolt.Tx, id string) error {
+	if err := tx.Bucket(bucketSongs).Delete([]byte(id)); err != nil {
+		return err
+	}
+	seqs := tx.Bucket(bucketSongSeqs)
+	seq := append([]byte(nil), seqs.Get([]byte(id))...)
+	if len(seq) == 0 {
+		return nil
+	}
+	if err := tx.Bucket(bucketSongOrder).Delete(seq); err != nil {
+		return err
+	}
+	return seqs.Delete([]byte(id))
+}
+
+// putMembers stores the song IDs of a playlist by position, rewriting the
+// playlist's bucket only if they changed.
+func putMembers(members *bolt.Bucket, playlistID string, songIDs []string) error {
+	list, err := members.CreateBucketIfNotExists([]byte(playlistID))
+	if err != nil {
+		return err
+	}
+	if sameMembers(list, songIDs) {
+		return nil
+	}
+	return setMembers(list, 0, songIDs)
+}
+
+func sameMembers(list *bolt.Bucket, songIDs []string) bool {
+	i := 0
+	c := list.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if i >= len(songIDs) || string(v) != songIDs[i] {
+			return false
+		}
+		i++
+	}
+	return i == len(songIDs)
+}
+
+// membersFrom returns the song IDs of a playlist from position index on.
+func membersFrom(list *bolt.Bucket, index int) []string {
+	songIDs := make([]string, 0)
+	c := list.Cursor()
+	for k, v := c.Seek(itob(uint64(index))); k != nil; k, v = c.Next() {
+		songIDs = append(songIDs, string(v))
+	}
+	return songIDs
+}
+
+func memberCount(list *bolt.Bucket) int {
+	last, _ := list.Cursor().Last()
+	if last == nil {
+		return 0
+	}
+	return int(binary.BigEndian.Uint64(last)) + 1
+}
+
+// setMembers stores songIDs from position index on, and drops the positions
+// after them.
+func setMembers(list *bolt.Bucket, index int, songIDs []string) error {
+	for i, id := range songIDs {
+		if err := list.Put(itob(uint64(index+i)), []byte(id)); err != nil {
+			return err
+		}
+	}
+
+	var stale [][]byte
+	c := list.Cursor()
+	for k, _ := c.Seek(itob(uint64(index + len(songIDs)))); k != nil; k, _ = c.Next() {
+		stale = append(stale, append([]byte(nil), k...))
+	}
+	for _, key := range stale {
+		if err := list.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteMissing(bucket *bolt.Bucket, keep map[string]bool) error {
+	var stale [][]byte
+	err := bucket.ForEach(func(key, _ []byte) error {
+		if !keep[string(key)] {
+			stale = append(stale, append([]byte(nil), key...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range stale {
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteMissingBuckets(bucket *bolt.Bucket, keep map[string]bool) error {
+	var stale [][]byte
+	err := bucket.ForEachBucket(func(key []byte) error {
+		if !keep[string(key)] {
+			stale = append(stale, append([]byte(nil), key...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range stale {
+		if err := bucket.DeleteBucket(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// itob encodes n so that keys sort in numeric order.
+func itob(n uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, n)
+	return b
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"musicplaylist/models"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func openTestBolt(t *testing.T) *BoltStorage {
+	t.Helper()
+	db, err := OpenBoltStorage(filepath.Join(t.TempDir(), "playlists.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestBoltLibraryKeepsInsertionOrder(t *testing.T) {
+	db := openTestBolt(t)
+	song := func(id string) *models.Song {
+		return &models.Song{ID: id, Title: id, FilePath: "/music/" + id + ".mp3"}
+	}
+	libraryIDs := func() []string {
+		t.Helper()
+		songs, err := db.LoadLibrary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids := make([]string, len(songs))
+		for i, song := range songs {
+			ids[i] = song.ID
+		}
+		return ids
+	}
+
+	if err := db.SaveLibrary([]*models.Song{song("c"), song("a"), song("b")}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := libraryIDs(), []string{"c", "a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("library order = %v, want %v", got, want)
+	}
+
+	batch, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []error{
+		batch.DeleteSong("c"),
+		batch.UpsertSong(song("0")),
+		batch.UpsertSong(song("c")),
+		// Saving a song again keeps its place
+		batch.UpsertSong(song("a")),
+	} {
+		if step != nil {
+			t.Fatal(step)
+		}
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := libraryIDs(), []string{"a", "b", "0", "c"}; !slices.Equal(got, want) {
+		t.Errorf("library order = %v, want %v", got, want)
+	}
+}
+
+func TestBoltRemoveSongsChecksRange(t *testing.T) {
+	db := openTestBolt(t)
+	playlist := models.NewPlaylist("Mix", "")
+	for _, id := range []string{"a", "b", "c"} {
+		playlist.Songs = append(playlist.Songs, &models.Song{ID: id, FilePath: "/music/" + id + ".mp3"})
+	}
+	if err := db.SavePlaylists([]*models.Playlist{playlist}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		index, count int
+		wantErr      bool
+	}{
+		{-1, 1, true},
+		{0, -1, true},
+		{2, 2, true},
+		{3, 1, true},
+		{3, 0, false},
+		{1, 2, false},
+	}
+	for _, tt := range tests {
+		batch, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = batch.RemoveSongs(playlist.ID, tt.index, tt.count)
+		batch.Rollback()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("RemoveSongs(%d, %d) error = %v, want error %v", tt.index, tt.count, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"musicplaylist/models"
+	"slices"
+)
+
+// collection is a stored collection held in memory, for storage that applies
+// batches itself. Songs holds every stored song, including ones only found
+// inside playlists of older files; library orders the ones in the library,
+// and may still list songs since deleted from it.
+type collection struct {
+	playlists []*storedMembers
+	songs     map[string]*models.Song
+	library   []string
+	inLibrary map[string]bool
+	roots     []*models.ScanRoot
+}
+
+// storedMembers is a playlist without songs, and the IDs of its songs.
+type storedMembers struct {
+	playlist *models.Playlist
+	songIDs  []string
+}
+
+// loadCollection loads everything store holds.
+func loadCollection(store Storage) (*collection, error) {
+	playlists, err := store.LoadPlaylists()
+	if err != nil {
+		return nil, err
+	}
+	library := make([]*models.Song, 0)
+	roots := make([]*models.ScanRoot, 0)
+	if libraryStore, ok := store.(LibraryStorage); ok {
+		if library, err = libraryStore.LoadLibrary(); err != nil {
+			return nil, err
+		}
+		if roots, err = libraryStore.LoadScanRoots(); err != nil {
+			return nil, err
+		}
+	}
+
+	state := &collection{
+		playlists: make([]*storedMembers, len(playlists)),
+		songs:     make(map[string]*models.Song, len(library)),
+		library:   make([]string, len(library)),
+		inLibrary: make(map[string]bool, len(library)),
+		roots:     roots,
+	}
+	for i, song := range library {
+		state.songs[song.ID] = song
+		state.library[i] = song.ID
+		state.inLibrary[song.ID] = true
+	}
+	for i, playlist := range playlists {
+		members := &storedMembers{playlist: playlist, songIDs: make([]string, len(playlist.Songs))}
+		for j, song := range playlist.Songs {
+			if _, ok := state.songs[song.ID]; !ok {
+				state.songs[song.ID] = song
+			}
+			members.songIDs[j] = song.ID
+		}
+		playlist.Songs = nil
+		state.playlists[i] = members
+	}
+	return state, nil
+}
+
+// The changes below follow the Batch methods of the same names. They keep
+// what they are given, so callers pass copies.
+
+func (state *collection) upsertPlaylist(playlist *models.Playlist) {
+	if members := state.findPlaylist(playlist.ID); members != nil {
+		members.playlist = playlist
+		return
+	}
+	state.playlists = append(state.playlists, &storedMembers{playlist: playlist, songIDs: make([]string, 0)})
+}
+
+func (state *collection) deletePlaylist(id string) {
+	state.playlists = slices.DeleteFunc(state.playlists, func(members *storedMembers) bool {
+		return members.playlist.ID == id
+	})
+}
+
+func (state *collection) insertSongs(playlistID string, index int, songIDs []string) error {
+	members, err := state.editPlaylist(playlistID)
+	if err != nil {
+		return err
+	}
+	if index < 0 || index > len(members.songIDs) {
+		return fmt.Errorf("song index %d out of range", index)
+	}
+	members.songIDs = slices.Insert(members.songIDs, index, songIDs...)
+	return nil
+}
+
+func (state *collection) removeSongs(playlistID string, index, count int) error {
+	members, err := state.editPlaylist(playlistID)
+	if err != nil {
+		return err
+	}
+	if index < 0 || count < 0 || index+count > len(members.songIDs) {
+		return fmt.Errorf("song range %d+%d out of range", index, count)
+	}
+	members.songIDs = slices.Delete(members.songIDs, index, index+count)
+	return nil
+}
+
+func (state *collection) reorderSongs(playlistID string, songIDs []string) error {
+	members, err := state.editPlaylist(playlistID)
+	if err != nil {
+		return err
+	}
+	members.songIDs = songIDs
+	return nil
+}
+
+func (state *collection) upsertSong(song *models.Song) {
+	if !state.inLibrary[song.ID] {
+		state.library = append(state.library, song.ID)
+		state.inLibrary[song.ID] = true
+	}
+	state.songs[song.ID] = song
+}
+
+func (state *collection) deleteSong(id string) {
+	delete(state.songs, id)
+	delete(state.inLibrary, id)
+}
+
+func (state *collection) upsertScanRoot(root *models.ScanRoot) {
+	for i, existing := range state.roots {
+		if existing.Path == root.Path {
+			state.roots[i] = root
+			return
+		}
+	}
+	state.roots = append(state.roots, root)
+}
+
+func (state *collection) deleteScanRoot(path string) {
+	state.roots = slices.DeleteFunc(state.roots, func(root *models.ScanRoot) bool {
+		return root.Path == path
+	})
+}
+
+func (state *collection) findPlaylist(id string) *storedMembers {
+	for _, members := range state.playlists {
+		if members.playlist.ID == id {
+			return members
+		}
+	}
+	return nil
+}
+
+func (state *collection) editPlaylist(id string) (*storedMembers, error) {
+	members := state.findPlaylist(id)
+	if members == nil {
+		return nil, errors.New("playlist not found")
+	}
+	return members, nil
+}
+
+// holdsAny reports whether a playlist holds one of the songs. Playlists are
+// saved with their songs in full, so they have to be saved again when one of
+// their songs changes.
+func (state *collection) holdsAny(songIDs map[string]bool) bool {
+	if len(songIDs) == 0 {
+		return false
+	}
+	for _, members := range state.playlists {
+		for _, id := range members.songIDs {
+			if songIDs[id] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compactLibrary drops deleted songs from the library order, and the second
+// entry of songs deleted and added again.
+func (state *collection) compactLibrary() {
+	seen := make(map[string]bool, len(state.library))
+	state.library = slices.DeleteFunc(state.library, func(id string) bool {
+		if !state.inLibrary[id] || seen[id] {
+			return true
+		}
+		seen[id] = true
+		return false
+	})
+}
+
+// librarySongs lists the songs in the library, in order.
+func (state *collection) librarySongs() []*models.Song {
+	state.compactLibrary()
+	songs := make([]*models.Song, len(state.library))
+	for i, id := range state.library {
+		songs[i] = state.songs[id]
+	}
+	return songs
+}
+
+// playlistsWithSongs puts the songs back into the playlists to save them.
+// Songs that were deleted are left out.
+func (state *collection) playlistsWithSongs() []*models.Playlist {
+	playlists := make([]*models.Playlist, len(state.playlists))
+	for i, members := range state.playlists {
+		playlist := *members.playlist
+		playlist.Songs = make([]*models.Song, 0, len(members.songIDs))
+		for _, id := range members.songIDs {
+			if song, ok := state.songs[id]; ok {
+				playlist.Songs = append(playlist.Songs, song)
+			}
+		}
+		playlists[i] = &playlist
+	}
+	return playlists
+}
+
+func cloneScanRoot(root *models.ScanRoot) *models.ScanRoot {
+	copied := *root
+	copied.Files = make(map[string]*models.FileState, len(root.Files))
+	for path, state := range root.Files {
+		file := *state
+		copied.Files[path] = &file
+	}
+	return &copied
+}
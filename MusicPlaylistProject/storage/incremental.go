@@ -0,0 +1,257 @@
+package storage
+
+import (
+	"errors"
+	"musicplaylist/models"
+	"slices"
+	"sync"
+)
+
+// Incremental returns store as an IncrementalStorage. Backends that can only
+// save the whole collection are wrapped so that a batch rewrites just the
+// parts it changed: a batch that only edits playlists never saves the
+// library, and the other way round.
+func Incremental(store Storage) IncrementalStorage {
+	if incremental, ok := store.(IncrementalStorage); ok {
+		return incremental
+	}
+	return &rewriteStorage{inner: store}
+}
+
+// rewriteStorage keeps a copy of the stored collection to apply batches to,
+// loaded from the wrapped storage by the first batch.
+type rewriteStorage struct {
+	inner Storage
+
+	mu    sync.Mutex
+	state *collection
+}
+
+func (rs *rewriteStorage) SavePlaylists(playlists []*models.Playlist) error {
+	rs.invalidate()
+	return rs.inner.SavePlaylists(playlists)
+}
+
+// Loads drop the copy too, as they may find what another process saved.
+func (rs *rewriteStorage) LoadPlaylists() ([]*models.Playlist, error) {
+	rs.invalidate()
+	return rs.inner.LoadPlaylists()
+}
+
+func (rs *rewriteStorage) SaveLibrary(songs []*models.Song) error {
+	rs.invalidate()
+	if libraryStore, ok := rs.inner.(LibraryStorage); ok {
+		return libraryStore.SaveLibrary(songs)
+	}
+	return nil
+}
+
+func (rs *rewriteStorage) LoadLibrary() ([]*models.Song, error) {
+	rs.invalidate()
+	if libraryStore, ok := rs.inner.(LibraryStorage); ok {
+		return libraryStore.LoadLibrary()
+	}
+	return make([]*models.Song, 0), nil
+}
+
+func (rs *rewriteStorage) SaveScanRoots(roots []*models.ScanRoot) error {
+	rs.invalidate()
+	if libraryStore, ok := rs.inner.(LibraryStorage); ok {
+		return libraryStore.SaveScanRoots(roots)
+	}
+	return nil
+}
+
+func (rs *rewriteStorage) LoadScanRoots() ([]*models.ScanRoot, error) {
+	rs.invalidate()
+	if libraryStore, ok := rs.inner.(LibraryStorage); ok {
+		return libraryStore.LoadScanRoots()
+	}
+	return make([]*models.ScanRoot, 0), nil
+}
+
+func (rs *rewriteStorage) Lock() (func(), error) {
+	if shared, ok := rs.inner.(SharedStorage); ok {
+		return shared.Lock()
+	}
+	return func() {}, nil
+}
+
+func (rs *rewriteStorage) Stamp() (string, error) {
+	if shared, ok := rs.inner.(SharedStorage); ok {
+		return shared.Stamp()
+	}
+	return "", nil
+}
+
+func (rs *rewriteStorage) invalidate() {
+	rs.mu.Lock()
+	rs.state = nil
+	rs.mu.Unlock()
+}
+
+func (rs *rewriteStorage) Begin() (Batch, error) {
+	return &rewriteBatch{store: rs}, nil
+}
+
+// rewriteBatch queues its changes and applies them all on Commit.
+type rewriteBatch struct {
+	store   *rewriteStorage
+	changes []func(state *collection) error
+	// What the changes touch, so only those parts are saved
+	playlists, library, roots bool
+	upserted                  map[string]bool
+	done                      bool
+}
+
+func (rb *rewriteBatch) queue(change func(state *collection) error) error {
+	if rb.done {
+		return errors.New("batch already committed or rolled back")
+	}
+	rb.changes = append(rb.changes, change)
+	return nil
+}
+
+func (rb *rewriteBatch) UpsertPlaylist(playlist *models.Playlist) error {
+	rb.playlists = true
+	copied := *playlist
+	copied.Songs = nil
+	return rb.queue(func(state *collection) error {
+		state.upsertPlaylist(&copied)
+		return nil
+	})
+}
+
+func (rb *rewriteBatch) DeletePlaylist(id string) error {
+	rb.playlists = true
+	return rb.queue(func(state *collection) error {
+		state.deletePlaylist(id)
+		return nil
+	})
+}
+
+func (rb *rewriteBatch) InsertSongs(playlistID string, index int, songIDs []string) error {
+	rb.playlists = true
+	songIDs = slices.Clone(songIDs)
+	return rb.queue(func(state *collection) error {
+		return state.insertSongs(playlistID, index, songIDs)
+	})
+}
+
+func (rb *rewriteBatch) RemoveSongs(playlistID string, index, count int) error {
+	rb.playlists = true
+	return rb.queue(func(state *collection) error {
+		return state.removeSongs(playlistID, index, count)
+	})
+}
+
+func (rb *rewriteBatch) ReorderSongs(playlistID string, songIDs []string) error {
+	rb.playlists = true
+	songIDs = slices.Clone(songIDs)
+	return rb.queue(func(state *collection) error {
+		return state.reorderSongs(playlistID, songIDs)
+	})
+}
+
+func (rb *rewriteBatch) UpsertSong(song *models.Song) error {
+	rb.library = true
+	if rb.upserted == nil {
+		rb.upserted = make(map[string]bool)
+	}
+	rb.upserted[song.ID] = true
+	copied := *song
+	return rb.queue(func(state *collection) error {
+		state.upsertSong(&copied)
+		return nil
+	})
+}
+
+func (rb *rewriteBatch) DeleteSong(id string) error {
+	rb.library = true
+	return rb.queue(func(state *collection) error {
+		state.deleteSong(id)
+		return nil
+	})
+}
+
+func (rb *rewriteBatch) UpsertScanRoot(root *models.ScanRoot) error {
+	rb.roots = true
+	copied := cloneScanRoot(root)
+	return rb.queue(func(state *collection) error {
+		state.upsertScanRoot(copied)
+		return nil
+	})
+}
+
+func (rb *rewriteBatch) DeleteScanRoot(path string) error {
+	rb.roots = true
+	return rb.queue(func(state *collection) error {
+		state.deleteScanRoot(path)
+		return nil
+	})
+}
+
+func (rb *rewriteBatch) Rollback() error {
+	rb.done = true
+	rb.changes = nil
+	return nil
+}
+
+// Commit applies the changes to the collection and saves the parts they
+// touched, the library first, then the scanned folders, then the playlists.
+// Each part is replaced whole, but one after the other: a failure part way
+// leaves the parts saved before it in place, so the playlists are never
+// saved holding songs the library doesn't have. The copy is dropped, to be
+// loaded again from whatever the wrapped storage was left holding. Wrap the
+// storage in a JournalStorage where a batch has to be all or nothing.
+func (rb *rewriteBatch) Commit() error {
+	if rb.done {
+		return errors.New("batch already committed or rolled back")
+	}
+	rb.done = true
+	if len(rb.changes) == 0 {
+		return nil
+	}
+
+	rs := rb.store
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	if rs.state == nil {
+		state, err := loadCollection(rs.inner)
+		if err != nil {
+			return err
+		}
+		rs.state = state
+	}
+	if err := rb.save(rs.state); err != nil {
+		rs.state = nil
+		return err
+	}
+	return nil
+}
+
+func (rb *rewriteBatch) save(state *collection) error {
+	for _, change := range rb.changes {
+		if err := change(state); err != nil {
+			return err
+		}
+	}
+
+	if libraryStore, ok := rb.store.inner.(LibraryStorage); ok {
+		if rb.library {
+			if err := libraryStore.SaveLibrary(state.librarySongs()); err != nil {
+				return err
+			}
+		}
+		if rb.roots {
+			if err := libraryStore.SaveScanRoots(state.roots); err != nil {
+				return err
+			}
+		}
+	}
+	if rb.playlists || state.holdsAny(rb.upserted) {
+		return rb.store.inner.SavePlaylists(state.playlistsWithSongs())
+	}
+	return nil
+}
@@ -0,0 +1,753 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"musicplaylist/models"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Journal operations, named after the Batch methods that make them
+const (
+	OpUpsertPlaylist = "upsertPlaylist"
+	OpDeletePlaylist = "deletePlaylist"
+	OpInsertSongs    = "insertSongs"
+	OpRemoveSongs    = "removeSongs"
+	OpReorderSongs   = "reorderSongs"
+	OpUpsertSong     = "upsertSong"
+	OpDeleteSong     = "deleteSong"
+	OpUpsertScanRoot = "upsertScanRoot"
+	OpDeleteScanRoot = "deleteScanRoot"
+	// OpNameRevision names a revision of a playlist and changes nothing
+	// stored; Index is the revision's number
+	OpNameRevision = "nameRevision"
+	// The whole-collection saves only show up in the history, as they are
+	// stored straight away
+	OpSavePlaylists = "savePlaylists"
+	OpSaveLibrary   = "saveLibrary"
+	OpSaveScanRoots = "saveScanRoots"
+
+	// opCommit ends the entries of a batch and counts them; a batch cut
+	// short by a crash has none and is ignored
+	opCommit = "commit"
+)
+
+// JournalCompactSize is how large the journal grows before a commit folds it
+// into the stored collection.
+const JournalCompactSize = 1 << 20
+
+// JournalEntry is one change recorded in the journal. The fields an
+// operation doesn't use are left empty.
+type JournalEntry struct {
+	Time  time.Time `json:"time"`
+	Actor string    `json:"actor"`
+	Op    string    `json:"op"`
+	// Seq numbers the entries in the order they were recorded, carrying on
+	// across compactions; a commit marker has its batch's last. It's 0 in
+	// entries recorded before there were numbers.
+	Seq int64 `json:"seq,omitempty"`
+
+	PlaylistID string           `json:"playlistId,omitempty"`
+	Playlist   *models.Playlist `json:"playlist,omitempty"`
+	Index      int              `json:"index,omitempty"`
+	Count      int              `json:"count,omitempty"`
+	SongIDs    []string         `json:"songIds,omitempty"`
+	// After lists the playlist's songs once an insert, remove or reorder is
+	// done, so replaying it doesn't depend on what came before
+	After    []string         `json:"after,omitempty"`
+	SongID   string           `json:"songId,omitempty"`
+	Song     *models.Song     `json:"song,omitempty"`
+	ScanRoot *models.ScanRoot `json:"scanRoot,omitempty"`
+	Path     string           `json:"path,omitempty"`
+	// Name is the playlist's or song's name when the change was made, for
+	// the history
+	Name string `json:"name,omitempty"`
+}
+
+func (e *JournalEntry) String() string {
+	return fmt.Sprintf("%s %s: %s", e.Time.Local().Format("2006-01-02 15:04:05"), e.Actor, e.Change())
+}
+
+// Change describes the change in words.
+func (e *JournalEntry) Change() string {
+	var change string
+	switch e.Op {
+	case OpUpsertPlaylist:
+		change = fmt.Sprintf("saved playlist %q", e.Name)
+	case OpDeletePlaylist:
+		change = fmt.Sprintf("deleted playlist %q", e.Name)
+	case OpInsertSongs:
+		change = fmt.Sprintf("added %d songs to %q", len(e.SongIDs), e.Name)
+	case OpRemoveSongs:
+		change = fmt.Sprintf("removed %d songs from %q", e.Count, e.Name)
+	case OpReorderSongs:
+		change = fmt.Sprintf("reordered %q", e.Name)
+	case OpUpsertSong:
+		change = fmt.Sprintf("saved song %q", e.Name)
+	case OpDeleteSong:
+		change = fmt.Sprintf("deleted song %q", e.Name)
+	case OpUpsertScanRoot:
+		change = "saved folder " + e.Path
+	case OpDeleteScanRoot:
+		change = "removed folder " + e.Path
+	case OpNameRevision:
+		change = fmt.Sprintf("named revision %d %q", e.Index, e.Name)
+	case OpSavePlaylists:
+		change = fmt.Sprintf("saved all %d playlists", e.Count)
+	case OpSaveLibrary:
+		change = fmt.Sprintf("saved all %d songs", e.Count)
+	case OpSaveScanRoots:
+		change = fmt.Sprintf("saved all %d folders", e.Count)
+	default:
+		change = e.Op
+	}
+	return change
+}
+
+// apply makes the change to state. While recording, it also fills in what
+// replaying and the history need from the state it is applied to.
+func (e *JournalEntry) apply(state *collection, recording bool) error {
+	if recording {
+		switch e.Op {
+		case OpUpsertPlaylist:
+			e.Name = e.Playlist.Name
+		case OpDeletePlaylist, OpInsertSongs, OpRemoveSongs, OpReorderSongs:
+			if members := state.findPlaylist(e.PlaylistID); members != nil {
+				e.Name = members.playlist.Name
+			}
+		case OpUpsertSong:
+			e.Name = e.Song.Title
+		case OpDeleteSong:
+			if song := state.songs[e.SongID]; song != nil {
+				e.Name = song.Title
+			}
+		}
+	}
+
+	switch e.Op {
+	case OpUpsertPlaylist:
+		playlist := *e.Playlist
+		state.upsertPlaylist(&playlist)
+	case OpDeletePlaylist:
+		state.deletePlaylist(e.PlaylistID)
+	case OpInsertSongs, OpRemoveSongs, OpReorderSongs:
+		if !recording {
+			return state.reorderSongs(e.PlaylistID, slices.Clone(e.After))
+		}
+		var err error
+		switch e.Op {
+		case OpInsertSongs:
+			err = state.insertSongs(e.PlaylistID, e.Index, slices.Clone(e.SongIDs))
+		case OpRemoveSongs:
+			err = state.removeSongs(e.PlaylistID, e.Index, e.Count)
+		default:
+			err = state.reorderSongs(e.PlaylistID, slices.Clone(e.SongIDs))
+		}
+		if err != nil {
+			return err
+		}
+		e.After = slices.Clone(state.findPlaylist(e.PlaylistID).songIDs)
+	case OpUpsertSong:
+		song := *e.Song
+		state.upsertSong(&song)
+	case OpDeleteSong:
+		state.deleteSong(e.SongID)
+	case OpUpsertScanRoot:
+		state.upsertScanRoot(cloneScanRoot(e.ScanRoot))
+	case OpDeleteScanRoot:
+		state.deleteScanRoot(e.Path)
+	case OpNameRevision:
+	default:
+		return fmt.Errorf("unknown journal operation %q", e.Op)
+	}
+	return nil
+}
+
+// fold makes the change to a batch of the wrapped storage.
+func (e *JournalEntry) fold(batch Batch) error {
+	switch e.Op {
+	case OpUpsertPlaylist:
+		return batch.UpsertPlaylist(e.Playlist)
+	case OpDeletePlaylist:
+		return batch.DeletePlaylist(e.PlaylistID)
+	case OpInsertSongs, OpRemoveSongs, OpReorderSongs:
+		after := e.After
+		if after == nil {
+			after = make([]string, 0)
+		}
+		return batch.ReorderSongs(e.PlaylistID, after)
+	case OpUpsertSong:
+		return batch.UpsertSong(e.Song)
+	case OpDeleteSong:
+		return batch.DeleteSong(e.SongID)
+	case OpUpsertScanRoot:
+		return batch.UpsertScanRoot(e.ScanRoot)
+	case OpDeleteScanRoot:
+		return batch.DeleteScanRoot(e.Path)
+	case OpNameRevision:
+		return nil
+	}
+	return fmt.Errorf("unknown journal operation %q", e.Op)
+}
+
+// JournalStorage wraps another storage and, instead of storing batches in
+// it, appends them to a journal file. Loading replays the journal over what
+// the wrapped storage holds, so changes survive a crash between saves. Once
+// the journal grows past JournalCompactSize, or on Compact, it is folded into
+// the wrapped storage and its entries move to a history file, which keeps
+// who changed what for good.
+type JournalStorage struct {
+	inner       IncrementalStorage
+	path        string
+	historyPath string
+	actor       string
+
+	mu sync.Mutex
+	// state is the collection with the journal replayed, for commits to
+	// apply to, and stamp what Stamp returned when it was loaded
+	state *collection
+	stamp string
+
+	// Warn is told when the journal can't be compacted after a commit, which
+	// leaves the commit stored. Failures are dropped when it's nil.
+	Warn func(err error)
+}
+
+// NewJournalStorage journals to path, and keeps the history next to it.
+// Every entry recorded here is put down to actor.
+func NewJournalStorage(inner Storage, path, actor string) *JournalStorage {
+	return &JournalStorage{
+		inner:       Incremental(inner),
+		path:        path,
+		historyPath: strings.TrimSuffix(path, ".journal") + ".history",
+		actor:       actor,
+	}
+}
+
+// Full saves go straight to the wrapped storage, after folding the journal
+// into it so replaying it doesn't undo them.
+func (js *JournalStorage) SavePlaylists(playlists []*models.Playlist) error {
+	return js.saveAll(OpSavePlaylists, len(playlists), func() error {
+		return js.inner.SavePlaylists(playlists)
+	})
+}
+
+func (js *JournalStorage) SaveLibrary(songs []*models.Song) error {
+	return js.saveAll(OpSaveLibrary, len(songs), func() error {
+		return js.inner.SaveLibrary(songs)
+	})
+}
+
+func (js *JournalStorage) SaveScanRoots(roots []*models.ScanRoot) error {
+	return js.saveAll(OpSaveScanRoots, len(roots), func() error {
+		return js.inner.SaveScanRoots(roots)
+	})
+}
+
+func (js *JournalStorage) saveAll(op string, count int, save func() error) error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if err := js.compact(); err != nil {
+		return err
+	}
+	js.state = nil
+	if err := save(); err != nil {
+		return err
+	}
+	entries := []JournalEntry{{Time: time.Now().UTC(), Actor: js.actor, Op: op, Count: count}}
+	if err := js.number(entries); err != nil {
+		return err
+	}
+	return appendEntries(js.historyPath, entries)
+}
+
+func (js *JournalStorage) LoadPlaylists() ([]*models.Playlist, error) {
+	state, err := js.replayed()
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return js.inner.LoadPlaylists()
+	}
+	return state.playlistsWithSongs(), nil
+}
+
+func (js *JournalStorage) LoadLibrary() ([]*models.Song, error) {
+	state, err := js.replayed()
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return js.inner.LoadLibrary()
+	}
+	return state.librarySongs(), nil
+}
+
+func (js *JournalStorage) LoadScanRoots() ([]*models.ScanRoot, error) {
+	state, err := js.replayed()
+	if err != nil {
+		return nil, err
+	}
+	if state == nil {
+		return js.inner.LoadScanRoots()
+	}
+	return state.roots, nil
+}
+
+// replayed loads a fresh copy of the collection with the journal replayed
+// over it, for a load to return. When the journal is empty it returns nil,
+// and the load goes to the wrapped storage.
+func (js *JournalStorage) replayed() (*collection, error) {
+	entries, err := readEntries(js.path)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+	state, err := loadCollection(js.inner)
+	if err != nil {
+		return nil, err
+	}
+	if err := replay(state, entries); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func replay(state *collection, entries []JournalEntry) error {
+	for i := range entries {
+		if err := entries[i].apply(state, false); err != nil {
+			return fmt.Errorf("failed to replay journal: %w", err)
+		}
+	}
+	return nil
+}
+
+func (js *JournalStorage) Lock() (func(), error) {
+	if shared, ok := js.inner.(SharedStorage); ok {
+		return shared.Lock()
+	}
+	return func() {}, nil
+}
+
+// Stamp adds the size and modification time of the journal to the wrapped
+// storage's stamp, as every commit appends to it.
+func (js *JournalStorage) Stamp() (string, error) {
+	stamp := ""
+	if shared, ok := js.inner.(SharedStorage); ok {
+		var err error
+		if stamp, err = shared.Stamp(); err != nil {
+			return "", err
+		}
+	}
+	info, err := os.Stat(js.path)
+	if os.IsNotExist(err) {
+		return stamp + " -", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s %d@%d", stamp, info.Size(), info.ModTime().UnixNano()), nil
+}
+
+func (js *JournalStorage) Begin() (Batch, error) {
+	return &journalBatch{store: js}, nil
+}
+
+// Compact folds the journal into the wrapped storage now.
+func (js *JournalStorage) Compact() error {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	return js.compact()
+}
+
+// compact stores the journal's changes in one batch of the wrapped storage,
+// moves them to the history and empties the journal. Every entry sets what
+// it changes outright, so a compaction cut short is simply done again; the
+// entries a earlier attempt already moved to the history, going by their
+// sequence numbers, aren't moved again.
+func (js *JournalStorage) compact() error {
+	entries, err := readEntries(js.path)
+	if err != nil {
+		return err
+	}
+	moved, err := lastSeq(js.historyPath)
+	if err != nil {
+		return err
+	}
+	if len(entries) > 0 {
+		batch, err := js.inner.Begin()
+		if err != nil {
+			return err
+		}
+		for i := range entries {
+			if err := entries[i].fold(batch); err != nil {
+				batch.Rollback()
+				return fmt.Errorf("failed to compact journal: %w", err)
+			}
+		}
+		if err := batch.Commit(); err != nil {
+			return fmt.Errorf("failed to compact journal: %w", err)
+		}
+		unmoved := slices.DeleteFunc(entries, func(e JournalEntry) bool {
+			return e.Seq != 0 && e.Seq <= moved
+		})
+		if len(unmoved) > 0 {
+			if err := appendEntries(js.historyPath, unmoved); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Truncate(js.path, 0); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to empty journal: %w", err)
+	}
+	// The collection is the same, only kept elsewhere
+	if js.state != nil {
+		stamp, err := js.Stamp()
+		if err != nil {
+			js.state = nil
+			return err
+		}
+		js.stamp = stamp
+	}
+	return nil
+}
+
+// Annotate appends entries that change nothing stored, like names given to
+// revisions, to the journal. Entries without an actor are put down to the
+// storage's.
+func (js *JournalStorage) Annotate(entries ...JournalEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	if err := js.number(entries); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for i := range entries {
+		entries[i].Time = now
+		if entries[i].Actor == "" {
+			entries[i].Actor = js.actor
+		}
+	}
+	if err := appendEntries(js.path, entries); err != nil {
+		return err
+	}
+	if js.state != nil {
+		stamp, err := js.Stamp()
+		if err != nil {
+			js.state = nil
+			return err
+		}
+		js.stamp = stamp
+	}
+	return nil
+}
+
+// History lists every change recorded, the ones already compacted first.
+func (js *JournalStorage) History() ([]JournalEntry, error) {
+	history, err := readEntries(js.historyPath)
+	if err != nil {
+		return nil, err
+	}
+	pending, err := readEntries(js.path)
+	if err != nil {
+		return nil, err
+	}
+	return append(history, pending...), nil
+}
+
+// current returns the collection commits apply to, loading it again when
+// something else saved since.
+func (js *JournalStorage) current() (*collection, error) {
+	stamp, err := js.Stamp()
+	if err != nil {
+		return nil, err
+	}
+	if js.state != nil && stamp == js.stamp {
+		return js.state, nil
+	}
+
+	entries, err := readEntries(js.path)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadCollection(js.inner)
+	if err != nil {
+		return nil, err
+	}
+	if err := replay(state, entries); err != nil {
+		return nil, err
+	}
+	js.state, js.stamp = state, stamp
+	return state, nil
+}
+
+// journalBatch queues its changes as entries and appends them on Commit.
+type journalBatch struct {
+	store   *JournalStorage
+	entries []JournalEntry
+	actor   string
+	done    bool
+}
+
+func (jb *journalBatch) SetActor(actor string) {
+	jb.actor = actor
+}
+
+func (jb *journalBatch) add(entry JournalEntry) error {
+	if jb.done {
+		return errors.New("batch already committed or rolled back")
+	}
+	jb.entries = append(jb.entries, entry)
+	return nil
+}
+
+func (jb *journalBatch) UpsertPlaylist(playlist *models.Playlist) error {
+	copied := *playlist
+	copied.Songs = nil
+	return jb.add(JournalEntry{Op: OpUpsertPlaylist, PlaylistID: copied.ID, Playlist: &copied})
+}
+
+func (jb *journalBatch) DeletePlaylist(id string) error {
+	return jb.add(JournalEntry{Op: OpDeletePlaylist, PlaylistID: id})
+}
+
+func (jb *journalBatch) InsertSongs(playlistID string, index int, songIDs []string) error {
+	return jb.add(JournalEntry{Op: OpInsertSongs, PlaylistID: playlistID, Index: index, SongIDs: slices.Clone(songIDs)})
+}
+
+func (jb *journalBatch) RemoveSongs(playlistID string, index, count int) error {
+	return jb.add(JournalEntry{Op: OpRemoveSongs, PlaylistID: playlistID, Index: index, Count: count})
+}
+
+func (jb *journalBatch) ReorderSongs(playlistID string, songIDs []string) error {
+	return jb.add(JournalEntry{Op: OpReorderSongs, PlaylistID: playlistID, SongIDs: slices.Clone(songIDs)})
+}
+
+func (jb *journalBatch) UpsertSong(song *models.Song) error {
+	copied := *song
+	return jb.add(JournalEntry{Op: OpUpsertSong, SongID: copied.ID, Song: &copied})
+}
+
+func (jb *journalBatch) DeleteSong(id string) error {
+	return jb.add(JournalEntry{Op: OpDeleteSong, SongID: id})
+}
+
+func (jb *journalBatch) UpsertScanRoot(root *models.ScanRoot) error {
+	return jb.add(JournalEntry{Op: OpUpsertScanRoot, Path: root.Path, ScanRoot: cloneScanRoot(root)})
+}
+
+func (jb *journalBatch) DeleteScanRoot(path string) error {
+	return jb.add(JournalEntry{Op: OpDeleteScanRoot, Path: path})
+}
+
+func (jb *journalBatch) Rollback() error {
+	jb.done = true
+	jb.entries = nil
+	return nil
+}
+
+// Commit checks the changes against the collection and appends them to the
+// journal in one write. If anything fails the collection is dropped, to be
+// loaded again from what was stored.
+func (jb *journalBatch) Commit() error {
+	if jb.done {
+		return errors.New("batch already committed or rolled back")
+	}
+	jb.done = true
+	if len(jb.entries) == 0 {
+		return nil
+	}
+
+	js := jb.store
+	js.mu.Lock()
+	defer js.mu.Unlock()
+
+	state, err := js.current()
+	if err != nil {
+		return err
+	}
+	if err := js.number(jb.entries); err != nil {
+		return err
+	}
+	actor := js.actor
+	if jb.actor != "" {
+		actor = jb.actor
+	}
+	now := time.Now().UTC()
+	for i := range jb.entries {
+		jb.entries[i].Time = now
+		jb.entries[i].Actor = actor
+		if err := jb.entries[i].apply(state, true); err != nil {
+			js.state = nil
+			return err
+		}
+	}
+	if err := appendEntries(js.path, jb.entries); err != nil {
+		js.state = nil
+		return err
+	}
+
+	// The batch is stored now, whatever fails below
+	if js.stamp, err = js.Stamp(); err != nil {
+		js.state = nil
+	}
+	if info, err := os.Stat(js.path); err == nil && info.Size() > JournalCompactSize {
+		if err := js.compact(); err != nil && js.Warn != nil {
+			js.Warn(fmt.Errorf("could not compact journal: %w", err))
+		}
+	}
+	return nil
+}
+
+// appendEntries appends a batch of entries to a journal file, and the commit
+// marker that ends it, then syncs the file.
+func appendEntries(path string, entries []JournalEntry) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	// A crash may have left half a line, which must not swallow the first
+	// entry of this batch
+	if info, err := file.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			buf.WriteByte('\n')
+		}
+	}
+	encoder := json.NewEncoder(&buf)
+	for i := range entries {
+		if err := encoder.Encode(&entries[i]); err != nil {
+			return fmt.Errorf("failed to marshal journal entry: %w", err)
+		}
+	}
+	last := entries[len(entries)-1]
+	marker := JournalEntry{Time: last.Time, Actor: last.Actor, Op: opCommit, Seq: last.Seq, Count: len(entries)}
+	if err := encoder.Encode(&marker); err != nil {
+		return fmt.Errorf("failed to marshal journal entry: %w", err)
+	}
+
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
+
+// number gives entries the sequence numbers that follow the last one
+// recorded, in the journal or, when it has none, in the history.
+func (js *JournalStorage) number(entries []JournalEntry) error {
+	seq, err := lastSeq(js.path)
+	if err != nil {
+		return err
+	}
+	if seq == 0 {
+		if seq, err = lastSeq(js.historyPath); err != nil {
+			return err
+		}
+	}
+	for i := range entries {
+		seq++
+		entries[i].Seq = seq
+	}
+	return nil
+}
+
+// seqTailSize is how much of the end of a journal file lastSeq reads first,
+// which holds the last commit marker unless a large batch was cut short.
+const seqTailSize = 64 << 10
+
+// lastSeq returns the sequence number of the last committed entry of a
+// journal file, from the commit marker that ends its batch, or 0 if there is
+// none.
+func lastSeq(path string) (int64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	offset := max(info.Size()-seqTailSize, 0)
+	for {
+		tail := make([]byte, info.Size()-offset)
+		if _, err := file.ReadAt(tail, offset); err != nil && !errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("failed to read journal: %w", err)
+		}
+		lines := bytes.Split(tail, []byte("\n"))
+		for i := len(lines) - 1; i >= 0; i-- {
+			var entry JournalEntry
+			if json.Unmarshal(lines[i], &entry) == nil && entry.Op == opCommit {
+				return entry.Seq, nil
+			}
+		}
+		if offset == 0 {
+			return 0, nil
+		}
+		offset = 0
+	}
+}
+
+// readEntries reads the committed entries of a journal file. A batch without
+// its commit marker, which may end in half a line, was cut short and is
+// skipped.
+func readEntries(path string) ([]JournalEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	entries := make([]JournalEntry, 0)
+	pending := make([]JournalEntry, 0)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			pending = pending[:0]
+			continue
+		}
+		if entry.Op == opCommit {
+			// Entries before the batch's own are left from one cut short
+			if entry.Count <= len(pending) {
+				entries = append(entries, pending[len(pending)-entry.Count:]...)
+			}
+			pending = pending[:0]
+			continue
+		}
+		pending = append(pending, entry)
+	}
+	return entries, nil
+}
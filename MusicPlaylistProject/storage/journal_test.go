@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"musicplaylist/models"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestLoadReportsJournalThatFailsToReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "playlists.json")
+	inner := NewJSONStorage(path)
+	js := NewJournalStorage(inner, path+".journal", "test")
+
+	song := &models.Song{ID: "S1", Title: "Song", FilePath: "/music/song.mp3"}
+	playlist := models.NewPlaylist("Mix", "")
+	batch, err := js.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.UpsertSong(song); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.UpsertPlaylist(playlist); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := js.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Journal a change to the playlist, then take the playlist away from
+	// under the journal
+	batch, err = js.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.InsertSongs(playlist.ID, 0, []string{song.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := batch.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := inner.SavePlaylists([]*models.Playlist{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if playlists, err := js.LoadPlaylists(); err == nil {
+		t.Errorf("LoadPlaylists = %v, want the replay error", playlists)
+	}
+	if songs, err := js.LoadLibrary(); err == nil {
+		t.Errorf("LoadLibrary = %v, want the replay error", songs)
+	}
+	if roots, err := js.LoadScanRoots(); err == nil {
+		t.Errorf("LoadScanRoots = %v, want the replay error", roots)
+	}
+}
+
+func TestCompactionCutShortDoesNotRepeatHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "playlists.json")
+	js := NewJournalStorage(NewJSONStorage(path), path+".journal", "test")
+
+	commit := func(name string) {
+		t.Helper()
+		batch, err := js.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.UpsertPlaylist(models.NewPlaylist(name, "")); err != nil {
+			t.Fatal(err)
+		}
+		if err := batch.Commit(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	commit("First")
+	if err := js.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	commit("Second")
+	commit("Third")
+
+	// A compaction that stopped after moving the journal to the history,
+	// before emptying the journal
+	entries, err := readEntries(js.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := appendEntries(js.historyPath, entries); err != nil {
+		t.Fatal(err)
+	}
+	commit("Fourth")
+	if err := js.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := js.History()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for i, entry := range history {
+		names = append(names, entry.Name)
+		if entry.Seq != int64(i+1) {
+			t.Errorf("entry %d (%s) has sequence number %d", i, entry.Name, entry.Seq)
+		}
+	}
+	if want := []string{"First", "Second", "Third", "Fourth"}; !slices.Equal(names, want) {
+		t.Errorf("history = %v, want %v", names, want)
+	}
+}
@@ -6,19 +6,78 @@ import (
 	"fmt"
 	"musicplaylist/models"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 type JSONStorage struct {
-	filepath string
+	filepath    string
+	libraryPath string
+	rootsPath   string
+
+	// Warn is told about what a load did besides loading, such as upgrading
+	// a file from an older schema version. It's not told anything when nil.
+	Warn func(err error)
 }
 
 func (js *JSONStorage) SavePlaylists(playlists []*models.Playlist) error {
-	data, err := json.MarshalIndent(playlists, "", "  ")
+	return js.writeFile(js.filepath, "playlists", storedPlaylists(playlists))
+}
+
+// storedPlaylists leaves out the songs of smart playlists, they are worked out
+// again from the rules when the playlists are loaded.
+func storedPlaylists(playlists []*models.Playlist) []*models.Playlist {
+	stored := make([]*models.Playlist, len(playlists))
+	for i, playlist := range playlists {
+		if playlist.IsSmart() {
+			copied := *playlist
+			copied.Songs = make([]*models.Song, 0)
+			playlist = &copied
+		}
+		stored[i] = playlist
+	}
+	return stored
+}
+
+func (js *JSONStorage) LoadPlaylists() ([]*models.Playlist, error) {
+	playlists := make([]*models.Playlist, 0)
+	if err := js.readFile(js.filepath, "playlists", &playlists); err != nil {
+		return nil, err
+	}
+	return playlists, nil
+}
+
+func (js *JSONStorage) SaveLibrary(songs []*models.Song) error {
+	return js.writeFile(js.libraryPath, "library", songs)
+}
+
+func (js *JSONStorage) LoadLibrary() ([]*models.Song, error) {
+	songs := make([]*models.Song, 0)
+	if err := js.readFile(js.libraryPath, "library", &songs); err != nil {
+		return nil, err
+	}
+	return songs, nil
+}
+
+func (js *JSONStorage) SaveScanRoots(roots []*models.ScanRoot) error {
+	return js.writeFile(js.rootsPath, "scan roots", roots)
+}
+
+func (js *JSONStorage) LoadScanRoots() ([]*models.ScanRoot, error) {
+	roots := make([]*models.ScanRoot, 0)
+	if err := js.readFile(js.rootsPath, "scan roots", &roots); err != nil {
+		return nil, err
+	}
+	return roots, nil
+}
+
+func (js *JSONStorage) writeFile(path, kind string, value any) error {
+	data, err := encodeDataFile(kind, value)
 	if err != nil {
-		return fmt.Errorf("failed to marshal playlists: %w", err)
+		return fmt.Errorf("failed to marshal %s: %w", kind, err)
 	}
 
-	err = os.WriteFile(js.filepath, data, 0644)
+	err = writeFileAtomic(path, data, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
@@ -26,27 +85,97 @@ func (js *JSONStorage) SavePlaylists(playlists []*models.Playlist) error {
 	return nil
 }
 
-func (js *JSONStorage) LoadPlaylists() ([]*models.Playlist, error) {
-	if _, err := os.Stat(js.filepath); os.IsNotExist(err) {
-		return make([]*models.Playlist, 0), nil
+// readFile decodes the data file at path into value, leaving value alone if
+// there is no file. A file of an older schema version is upgraded in place,
+// and the original kept next to it.
+func (js *JSONStorage) readFile(path, kind string, value any) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
 	}
 
-	data, err := os.ReadFile(js.filepath)
+	data, version, err := decodeDataFile(content, kind)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, value); err != nil {
+		return fmt.Errorf("failed to unmarshal %s: %w", kind, err)
 	}
 
-	var playlists []*models.Playlist
-	err = json.Unmarshal(data, &playlists)
+	if version < SchemaVersion {
+		if err := js.upgradeFile(path, kind, version, content, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (js *JSONStorage) upgradeFile(path, kind string, version int, original []byte, value any) error {
+	backup := fmt.Sprintf("%s.v%d.bak", path, version)
+	if _, err := os.Stat(backup); os.IsNotExist(err) {
+		if err := writeFileAtomic(backup, original, 0644); err != nil {
+			return fmt.Errorf("failed to back up %s before upgrading it: %w", path, err)
+		}
+	}
+
+	data, err := encodeDataFile(kind, value)
 	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal playlists: %w", err)
+		return fmt.Errorf("failed to marshal %s: %w", kind, err)
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
 	}
+	if js.Warn != nil {
+		js.Warn(fmt.Errorf("upgraded %s from schema version %d to %d, the old file is kept as %s", path, version, SchemaVersion, backup))
+	}
+	return nil
+}
 
-	return playlists, nil
+// Lock takes the lock file next to the playlists, which every process
+// using them takes while it loads or saves.
+func (js *JSONStorage) Lock() (func(), error) {
+	file, err := os.OpenFile(js.filepath+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", js.filepath, err)
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
+
+// Stamp is made of the size and modification time of each file, which every
+// save changes as it replaces the file.
+func (js *JSONStorage) Stamp() (string, error) {
+	parts := make([]string, 0, 3)
+	for _, path := range []string{js.filepath, js.libraryPath, js.rootsPath} {
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			parts = append(parts, "-")
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, fmt.Sprintf("%d@%d", info.Size(), info.ModTime().UnixNano()))
+	}
+	return strings.Join(parts, " "), nil
 }
 
-func NewJSONStorage(filepath string) *JSONStorage {
+// NewJSONStorage keeps playlists in the given file, and the song library and
+// scanned folders in library.json and scan_roots.json next to it.
+func NewJSONStorage(path string) *JSONStorage {
+	dir := filepath.Dir(path)
 	return &JSONStorage{
-		filepath: filepath,
+		filepath:    path,
+		libraryPath: filepath.Join(dir, "library.json"),
+		rootsPath:   filepath.Join(dir, "scan_roots.json"),
 	}
 }
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"fmt"
+	"musicplaylist/models"
+)
+
+// Copy writes everything src holds into dst: the playlists and, when both
+// keep one, the library and scan roots.
+func Copy(dst, src Storage) error {
+	playlists, err := src.LoadPlaylists()
+	if err != nil {
+		return fmt.Errorf("failed to load playlists: %w", err)
+	}
+
+	songs := make([]*models.Song, 0)
+	roots := make([]*models.ScanRoot, 0)
+	if from, ok := src.(LibraryStorage); ok {
+		if songs, err = from.LoadLibrary(); err != nil {
+			return fmt.Errorf("failed to load library: %w", err)
+		}
+		if roots, err = from.LoadScanRoots(); err != nil {
+			return fmt.Errorf("failed to load scan roots: %w", err)
+		}
+	}
+
+	if err := dst.SavePlaylists(playlists); err != nil {
+		return fmt.Errorf("failed to save playlists: %w", err)
+	}
+	if to, ok := dst.(LibraryStorage); ok {
+		// Songs kept only inside playlists by older files join the library
+		seen := make(map[string]bool, len(songs))
+		for _, song := range songs {
+			seen[song.ID] = true
+		}
+		for _, playlist := range playlists {
+			if playlist.IsSmart() {
+				continue
+			}
+			for _, song := range playlist.Songs {
+				if !seen[song.ID] {
+					seen[song.ID] = true
+					songs = append(songs, song)
+				}
+			}
+		}
+		if err := to.SaveLibrary(songs); err != nil {
+			return fmt.Errorf("failed to save library: %w", err)
+		}
+		if err := to.SaveScanRoots(roots); err != nil {
+			return fmt.Errorf("failed to save scan roots: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion is the version of the data files this build writes. Older
+// files are upgraded when they are loaded.
+const SchemaVersion = 2
+
+// ErrUnsupportedVersion is returned for data files written by a newer build,
+// which this one can't read without losing what it doesn't know about.
+var ErrUnsupportedVersion = errors.New("unsupported schema version")
+
+// dataFile is the envelope every data file is written in. Kind says which
+// file it is; files of version 1 are the bare array, with no envelope.
+type dataFile struct {
+	Version int             `json:"version"`
+	Kind    string          `json:"kind"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// A migration upgrades the data of one kind of file to the next version.
+type migration func(kind string, data json.RawMessage) (json.RawMessage, error)
+
+// migrations holds the upgrade from each version to the one after it. A
+// change to the stored models that older builds can't read bumps
+// SchemaVersion and adds its migration here.
+var migrations = map[int]migration{
+	// Version 2 only put the data in an envelope
+	1: func(kind string, data json.RawMessage) (json.RawMessage, error) {
+		return data, nil
+	},
+}
+
+func encodeDataFile(kind string, data any) ([]byte, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(dataFile{Version: SchemaVersion, Kind: kind, Data: raw}, "", "  ")
+}
+
+// decodeDataFile returns the data in a file of the given kind, upgraded to
+// SchemaVersion, and the version the file was written in.
+func decodeDataFile(content []byte, kind string) (json.RawMessage, int, error) {
+	content = bytes.TrimSpace(content)
+	file := dataFile{Version: 1, Kind: kind, Data: content}
+	if bytes.HasPrefix(content, []byte("{")) {
+		if err := json.Unmarshal(content, &file); err != nil {
+			return nil, 0, err
+		}
+		if file.Kind != kind {
+			return nil, 0, fmt.Errorf("expected %s data but found %q", kind, file.Kind)
+		}
+	}
+
+	if file.Version > SchemaVersion {
+		return nil, file.Version, fmt.Errorf("%w: the file is version %d but this program only reads up to version %d, please update it",
+			ErrUnsupportedVersion, file.Version, SchemaVersion)
+	}
+	if file.Version < 1 {
+		return nil, file.Version, fmt.Errorf("%w: version %d", ErrUnsupportedVersion, file.Version)
+	}
+
+	data := file.Data
+	for version := file.Version; version < SchemaVersion; version++ {
+		migrate, ok := migrations[version]
+		if !ok {
+			return nil, file.Version, fmt.Errorf("no migration from schema version %d", version)
+		}
+		var err error
+		if data, err = migrate(kind, data); err != nil {
+			return nil, file.Version, fmt.Errorf("failed to migrate from schema version %d: %w", version, err)
+		}
+	}
+	return data, file.Version, nil
+}
@@ -6,3 +6,79 @@ type Storage interface {
 	SavePlaylists(playlists []*models.Playlist) error
 	LoadPlaylists() ([]*models.Playlist, error)
 }
+
+// LibraryStorage is implemented by backends that can also persist the song
+// library, including songs that are not in any playlist, and the folders the
+// library was scanned from.
+type LibraryStorage interface {
+	SaveLibrary(songs []*models.Song) error
+	LoadLibrary() ([]*models.Song, error)
+	SaveScanRoots(roots []*models.ScanRoot) error
+	LoadScanRoots() ([]*models.ScanRoot, error)
+}
+
+// SharedStorage is implemented by storage that other processes may be
+// saving to at the same time.
+type SharedStorage interface {
+	// Lock holds off the other processes until the returned function is
+	// called
+	Lock() (func(), error)
+	// Stamp identifies the stored collection as it is now, any save changes
+	// it
+	Stamp() (string, error)
+}
+
+// JournaledStorage is implemented by storage that records each batch in a
+// journal before folding it into the stored collection.
+type JournaledStorage interface {
+	// Compact folds the journal into the stored collection now
+	Compact() error
+	// History lists every change recorded, oldest first
+	History() ([]JournalEntry, error)
+	// Annotate records entries that change nothing stored, like names given
+	// to revisions
+	Annotate(entries ...JournalEntry) error
+}
+
+// IncrementalStorage is implemented by backends that can store single changes
+// to the collection instead of rewriting all of it. Incremental adapts the
+// backends that can't.
+type IncrementalStorage interface {
+	Storage
+	LibraryStorage
+	// Begin starts a batch of changes, which are stored on Commit or not at
+	// all. Backends that can't store them in one step, like those wrapped by
+	// Incremental, say on their Commit what a failure part way leaves behind.
+	Begin() (Batch, error)
+}
+
+// Batch is a set of changes to the stored collection. Songs are referred to
+// by ID, so they have to be upserted before a playlist can hold them.
+type Batch interface {
+	// UpsertPlaylist stores everything about a playlist but its songs. A new
+	// playlist goes after the others.
+	UpsertPlaylist(playlist *models.Playlist) error
+	DeletePlaylist(id string) error
+	// InsertSongs inserts songs into a playlist before the song at index
+	InsertSongs(playlistID string, index int, songIDs []string) error
+	// RemoveSongs removes count songs from a playlist, starting at index
+	RemoveSongs(playlistID string, index, count int) error
+	// ReorderSongs puts the songs of a playlist in a new order
+	ReorderSongs(playlistID string, songIDs []string) error
+
+	UpsertSong(song *models.Song) error
+	DeleteSong(id string) error
+	UpsertScanRoot(root *models.ScanRoot) error
+	DeleteScanRoot(path string) error
+
+	Commit() error
+	Rollback() error
+}
+
+// ActorBatch is implemented by batches that record who made their changes.
+type ActorBatch interface {
+	Batch
+	// SetActor puts the batch's changes down to actor instead of to whoever
+	// the storage records by default
+	SetActor(actor string)
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package storage
+
+// syncDir does nothing on Windows, where directories can't be synced and
+// NTFS journals renames itself.
+func syncDir(dir string) error {
+	return nil
+}
@@ -2,12 +2,22 @@
 package web
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"mime"
 	"musicplaylist/manager"
 	"musicplaylist/models"
+	"musicplaylist/playlistio"
 	"musicplaylist/scanner"
+	"musicplaylist/search"
+	"net"
 	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -31,18 +41,75 @@ func (s *WebServer) Start() error {
 	http.HandleFunc("/api/playlists", s.handlePlaylists)
 	http.HandleFunc("/api/playlists/create", s.handleCreatePlaylist)
 	http.HandleFunc("/api/playlists/delete", s.handleDeletePlaylist)
+	http.HandleFunc("/api/playlists/smart/create", s.handleCreateSmartPlaylist)
+	http.HandleFunc("/api/playlists/smart/update", s.handleUpdateSmartPlaylist)
 	http.HandleFunc("/api/songs/add", s.handleAddSong)
 
 	http.HandleFunc("/api/songs/scan", s.handleScanFolder)
 	http.HandleFunc("/api/songs/remove", s.handleRemoveSong)
 	http.HandleFunc("/api/songs/search", s.handleSearchSongs)
+	http.HandleFunc("/api/songs/suggest", s.handleSuggest)
+	http.HandleFunc("/api/songs/update", s.handleUpdateSong)
+	http.HandleFunc("/api/songs/delete", s.handleDeleteSong)
+	http.HandleFunc("/api/songs/export", s.handleExportTable)
+	http.HandleFunc("/api/songs/import", s.handleImportTable)
+	http.HandleFunc("/api/library", s.handleLibrary)
+	http.HandleFunc("/api/duplicates", s.handleDuplicates)
+	http.HandleFunc("/api/duplicates/dedupe", s.handleDedupe)
+	http.HandleFunc("/api/rescan/roots", s.handleScanRoots)
+	http.HandleFunc("/api/rescan/plan", s.handlePlanRescan)
+	http.HandleFunc("/api/rescan/apply", s.handleApplyRescan)
 	http.HandleFunc("/api/playlists/shuffle", s.handleShufflePlaylist)
+	http.HandleFunc("/api/playlists/reorder", s.handleReorderPlaylist)
+	http.HandleFunc("/api/playlists/sort", s.handleSortPlaylist)
+	http.HandleFunc("/api/playlists/sorted", s.handleSortedSongs)
+	http.HandleFunc("/api/playlists/export", s.handleExportPlaylist)
+	http.HandleFunc("/api/playlists/import", s.handleImportPlaylist)
+	http.HandleFunc("/api/playlists/bundle", s.handleExportBundle)
 	http.HandleFunc("/api/statistics", s.handleStatistics)
+	http.HandleFunc("/api/backups", s.handleBackups)
+	http.HandleFunc("/api/backups/diff", s.handleBackupDiff)
+	http.HandleFunc("/api/backups/restore", s.handleRestoreBackup)
+	http.HandleFunc("/api/conflicts/resolve", s.handleResolveConflicts)
+	http.HandleFunc("/api/history", s.handleHistory)
+	http.HandleFunc("/api/undo", s.handleUndo)
+	http.HandleFunc("/api/redo", s.handleRedo)
+	http.HandleFunc("/api/playlists/revisions", s.handleRevisions)
+	http.HandleFunc("/api/playlists/revisions/diff", s.handleRevisionDiff)
+	http.HandleFunc("/api/playlists/revisions/restore", s.handleRestoreRevision)
+	http.HandleFunc("/api/playlists/revisions/name", s.handleNameRevision)
 
 	fmt.Printf("Web server starting at http://localhost%s\n", s.port)
 	fmt.Println("Press Ctrl+C to stop the server")
 
-	return http.ListenAndServe(s.port, nil)
+	return http.ListenAndServe(s.port, s.actingClient(http.DefaultServeMux))
+}
+
+// actingClient puts the changes a request makes down to the client that made
+// it, in the journal. Only reads are made with GET.
+func (s *WebServer) actingClient(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		s.manager.ActAs(clientActor(r), func() {
+			next.ServeHTTP(w, r)
+		})
+	})
+}
+
+// clientActor names a client by the user it authenticated as, if any, and
+// the address it connected from.
+func clientActor(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		host = user + "@" + host
+	}
+	return host + " (web)"
 }
 
 func (s *WebServer) handlePlaylists(w http.ResponseWriter, r *http.Request) {
@@ -74,7 +141,68 @@ func (s *WebServer) handleCreatePlaylist(w http.ResponseWriter, r *http.Request)
 	playlist := s.manager.CreatePlaylist(req.Name, req.Description)
 
 	if err := s.manager.Save(); err != nil {
-		http.Error(w, "Failed to save: "+err.Error(), http.StatusInternalServerError)
+		respondSaveError(w, err)
+		return
+	}
+
+	respondJSON(w, playlist)
+}
+
+func (s *WebServer) handleCreateSmartPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name        string                `json:"name"`
+		Description string                `json:"description"`
+		Criteria    *models.SmartCriteria `json:"criteria"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	playlist, err := s.manager.CreateSmartPlaylist(req.Name, req.Description, req.Criteria)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.manager.Save(); err != nil {
+		respondSaveError(w, err)
+		return
+	}
+
+	respondJSON(w, playlist)
+}
+
+func (s *WebServer) handleUpdateSmartPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID       string                `json:"id"`
+		Criteria *models.SmartCriteria `json:"criteria"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	playlist, err := s.manager.UpdateSmartCriteria(req.ID, req.Criteria)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.manager.Save(); err != nil {
+		respondSaveError(w, err)
 		return
 	}
 
@@ -102,7 +230,7 @@ func (s *WebServer) handleDeletePlaylist(w http.ResponseWriter, r *http.Request)
 	}
 
 	if err := s.manager.Save(); err != nil {
-		http.Error(w, "Failed to save: "+err.Error(), http.StatusInternalServerError)
+		respondSaveError(w, err)
 		return
 	}
 
@@ -126,14 +254,14 @@ func (s *WebServer) handleScanFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	playlist, err := s.manager.GetPlaylist(req.PlaylistID)
+	_, err := s.manager.GetPlaylist(req.PlaylistID)
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	songs, err := scanner.ScanMusicFolder(req.FilePath)
+	diff, err := s.manager.AddFolderToPlaylist(req.PlaylistID, req.FilePath)
 
 	if err != nil {
 		http.Error(w, "Error Scanning Folder: "+err.Error(), http.StatusInternalServerError)
@@ -141,15 +269,13 @@ func (s *WebServer) handleScanFolder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	playlist.AddSongs(songs)
-
 	// Save after adding
 	if err := s.manager.Save(); err != nil {
-		http.Error(w, "Failed to save: "+err.Error(), http.StatusInternalServerError)
+		respondSaveError(w, err)
 		return
 	}
 
-	respondJSON(w, songs)
+	respondJSON(w, diff)
 }
 
 func (s *WebServer) handleAddSong(w http.ResponseWriter, r *http.Request) {
@@ -161,7 +287,9 @@ func (s *WebServer) handleAddSong(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		FilePath   string `json:"file_path"`
 		PlaylistID string `json:"playlist_id"`
-		Duration   int    `json:"duration"` // in seconds
+		// Position inserts the song there, counting from 0, instead of at
+		// the end
+		Position *int `json:"position"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -169,25 +297,34 @@ func (s *WebServer) handleAddSong(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	playlist, err := s.manager.GetPlaylist(req.PlaylistID)
-	if err != nil {
+	if _, err := s.manager.GetPlaylist(req.PlaylistID); err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	song, err := models.NewSongFromPath(req.FilePath,
-		time.Duration(req.Duration)*time.Second,
-	)
+	song, err := scanner.ScanFile(req.FilePath)
 	if err != nil {
 		http.Error(w, "Failed Add Song: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	playlist.AddSong(song)
+	if req.Position != nil {
+		var added []*models.Song
+		added, err = s.manager.InsertSongsIntoPlaylist(req.PlaylistID, *req.Position, []*models.Song{song})
+		if err == nil {
+			song = added[0]
+		}
+	} else {
+		song, err = s.manager.AddSongToPlaylist(req.PlaylistID, song)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Save after adding
 	if err := s.manager.Save(); err != nil {
-		http.Error(w, "Failed to save: "+err.Error(), http.StatusInternalServerError)
+		respondSaveError(w, err)
 		return
 	}
 
@@ -210,20 +347,14 @@ func (s *WebServer) handleRemoveSong(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	playlist, err := s.manager.GetPlaylist(req.PlaylistID)
-	if err != nil {
+	if err := s.manager.RemoveSongFromPlaylist(req.PlaylistID, req.SongID); err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	if !playlist.RemoveSong(req.SongID) {
-		http.Error(w, "Song not found", http.StatusNotFound)
-		return
-	}
-
 	// Save after removing
 	if err := s.manager.Save(); err != nil {
-		http.Error(w, "Failed to save: "+err.Error(), http.StatusInternalServerError)
+		respondSaveError(w, err)
 		return
 	}
 
@@ -242,10 +373,241 @@ func (s *WebServer) handleSearchSongs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	results := s.manager.SearchSongs(query)
+	fuzzy := r.URL.Query().Get("fuzzy") == "true"
+	results, err := s.manager.SearchSongs(query, fuzzy)
+	var parseErr *search.ParseError
+	if errors.As(err, &parseErr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":    parseErr.Msg,
+			"position": parseErr.Pos,
+		})
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 	respondJSON(w, results)
 }
 
+// handleSuggest completes the word being typed into the search box, the q
+// parameter being that last word.
+func (s *WebServer) handleSuggest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	suggestions := s.manager.CompleteSearch(r.URL.Query().Get("q"), 10)
+	respondJSON(w, suggestions)
+}
+
+func (s *WebServer) handleLibrary(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondJSON(w, s.manager.ListLibrary())
+}
+
+func (s *WebServer) handleUpdateSong(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Omitted fields keep their current value
+	var req struct {
+		ID     string  `json:"id"`
+		Title  *string `json:"title"`
+		Artist *string `json:"artist"`
+		Album  *string `json:"album"`
+		Genre  *string `json:"genre"`
+		Year   *int    `json:"year"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	song, err := s.manager.UpdateSong(req.ID, func(song *models.Song) {
+		if req.Title != nil {
+			song.Title = *req.Title
+		}
+		if req.Artist != nil {
+			song.Artist = *req.Artist
+		}
+		if req.Album != nil {
+			song.Album = *req.Album
+		}
+		if req.Genre != nil {
+			song.Genre = *req.Genre
+		}
+		if req.Year != nil {
+			song.Year = *req.Year
+		}
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := s.manager.Save(); err != nil {
+		respondSaveError(w, err)
+		return
+	}
+
+	respondJSON(w, song)
+}
+
+func (s *WebServer) handleDeleteSong(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.manager.DeleteSong(req.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := s.manager.Save(); err != nil {
+		respondSaveError(w, err)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "success"})
+}
+
+func (s *WebServer) handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	groups, err := s.manager.FindDuplicates(r.URL.Query().Get("playlist_id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	respondJSON(w, groups)
+}
+
+func (s *WebServer) handleDedupe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// An empty playlist ID dedupes the whole library, no kinds means all kinds
+	var req struct {
+		PlaylistID string                  `json:"playlist_id"`
+		Kinds      []manager.DuplicateKind `json:"kinds"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(req.Kinds) == 0 {
+		req.Kinds = manager.AllDuplicateKinds
+	}
+
+	var removed int
+	var err error
+	if req.PlaylistID == "" {
+		removed, err = s.manager.DedupeLibrary(req.Kinds)
+	} else {
+		removed, err = s.manager.DedupePlaylist(req.PlaylistID, req.Kinds)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := s.manager.Save(); err != nil {
+		respondSaveError(w, err)
+		return
+	}
+
+	respondJSON(w, map[string]int{"removed": removed})
+}
+
+func (s *WebServer) handleScanRoots(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondJSON(w, s.manager.ListScanRoots())
+}
+
+func (s *WebServer) handlePlanRescan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Path       string `json:"path"`
+		PlaylistID string `json:"playlist_id"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	diff, err := s.manager.PlanRescan(req.Path, req.PlaylistID)
+	if err != nil {
+		http.Error(w, "Error Scanning Folder: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	respondJSON(w, diff)
+}
+
+// handleApplyRescan takes back a diff from /api/rescan/plan once the user has
+// confirmed it.
+func (s *WebServer) handleApplyRescan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var diff scanner.RescanDiff
+	if err := json.NewDecoder(r.Body).Decode(&diff); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.manager.ApplyRescan(&diff); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.manager.Save(); err != nil {
+		respondSaveError(w, err)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "success"})
+}
+
 func (s *WebServer) handleShufflePlaylist(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -261,30 +623,693 @@ func (s *WebServer) handleShufflePlaylist(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	playlist, err := s.manager.GetPlaylist(req.ID)
+	playlist, err := s.manager.ShufflePlaylist(req.ID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
 
-	playlist.Shuffle()
+	if err := s.manager.Save(); err != nil {
+		respondSaveError(w, err)
+		return
+	}
+
+	respondJSON(w, playlist)
+}
+
+// handleReorderPlaylist moves songs around a playlist, by position counting
+// from 0. It moves the songs at positions to start at to, moves the single
+// song at positions by offset, or swaps the two songs at swap.
+func (s *WebServer) handleReorderPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID        string `json:"id"`
+		Positions []int  `json:"positions"`
+		To        *int   `json:"to"`
+		Offset    *int   `json:"offset"`
+		Swap      []int  `json:"swap"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var playlist *models.Playlist
+	var err error
+	switch {
+	case len(req.Swap) == 2:
+		playlist, err = s.manager.SwapSongs(req.ID, req.Swap[0], req.Swap[1])
+	case req.To != nil:
+		playlist, err = s.manager.MoveSongs(req.ID, req.Positions, *req.To)
+	case req.Offset != nil && len(req.Positions) == 1:
+		playlist, err = s.manager.MoveSongBy(req.ID, req.Positions[0], *req.Offset)
+	default:
+		http.Error(w, "give positions and to, one position and offset, or two positions to swap", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	if err := s.manager.Save(); err != nil {
-		http.Error(w, "Failed to save: "+err.Error(), http.StatusInternalServerError)
+		respondSaveError(w, err)
 		return
 	}
 
 	respondJSON(w, playlist)
 }
 
-func (s *WebServer) handleStatistics(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
+// handleSortPlaylist puts the songs of a playlist in the order of keys for
+// good.
+func (s *WebServer) handleSortPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	stats := s.manager.GetStatistics()
-	respondJSON(w, stats)
+	var req struct {
+		ID   string           `json:"id"`
+		Keys []models.SortKey `json:"keys"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	playlist, err := s.manager.SortPlaylist(req.ID, req.Keys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.manager.Save(); err != nil {
+		respondSaveError(w, err)
+		return
+	}
+
+	respondJSON(w, playlist)
+}
+
+// handleSortedSongs lists the songs of a playlist sorted, without changing
+// it. by lists the fields, like artist,-year where "-" sorts descending.
+func (s *WebServer) handleSortedSongs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys, err := models.ParseSortKeys(r.URL.Query().Get("by"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	songs, err := s.manager.SortedSongs(r.URL.Query().Get("id"), keys)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	respondJSON(w, songs)
+}
+
+// handleExportPlaylist downloads a playlist as .m3u8, or in the format named
+// by format: m3u for a legacy .m3u, xspf or pls. paths=relative writes paths relative to base, the folder
+// the file will be saved in.
+func (s *WebServer) handleExportPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	playlist, err := s.manager.GetPlaylist(query.Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	format := playlistio.FormatM3U8
+	if name := query.Get("format"); name != "" {
+		if format, err = playlistio.ParseFormat(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	opts := playlistio.Options{BaseDir: query.Get("base")}
+	if query.Get("paths") == "relative" {
+		if opts.BaseDir == "" {
+			http.Error(w, "relative paths need a base folder", http.StatusBadRequest)
+			return
+		}
+		opts.Paths = playlistio.RelativePaths
+	}
+
+	var buf bytes.Buffer
+	if err := playlistio.Write(&buf, format, playlist, opts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": playlist.Name + format.Extension(),
+	}))
+	w.Write(buf.Bytes())
+}
+
+// handleImportPlaylist creates a playlist from an uploaded playlist file, its
+// format taken from the file name. Relative entries are resolved against
+// base, since the upload carries no folder.
+func (s *WebServer) handleImportPlaylist(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	format, err := playlistio.FormatForPath(header.Filename)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := playlistio.Import(file, format, r.FormValue("base"), scanner.ScanFile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(result.Songs) == 0 {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":      "no songs could be loaded from the playlist",
+			"unresolved": result.Unresolved,
+		})
+		return
+	}
+
+	name := r.FormValue("name")
+	if name == "" {
+		name = result.Name
+	}
+	if name == "" {
+		name = strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename))
+	}
+
+	playlist := s.manager.ImportPlaylist(name, "Imported from "+header.Filename, result.Songs)
+
+	if err := s.manager.Save(); err != nil {
+		respondSaveError(w, err)
+		return
+	}
+
+	respondJSON(w, map[string]any{
+		"playlist":   playlist,
+		"unresolved": result.Unresolved,
+	})
+}
+
+// handleExportBundle copies a playlist's songs into a folder or .zip on the
+// server's machine, such as a mounted memory card. Progress is streamed as
+// one JSON object per line, the last one holding the result or an error.
+func (s *WebServer) handleExportBundle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID       string `json:"id"`
+		Target   string `json:"target"`
+		Template string `json:"template"`
+		Hardlink bool   `json:"hardlink"`
+		MaxBytes int64  `json:"maxBytes"`
+		Format   string `json:"format"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Target == "" {
+		http.Error(w, "target is required", http.StatusBadRequest)
+		return
+	}
+
+	playlist, err := s.manager.GetPlaylist(req.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	opts := playlistio.BundleOptions{
+		Template: req.Template,
+		Hardlink: req.Hardlink,
+		MaxBytes: req.MaxBytes,
+	}
+	if req.Format != "" {
+		if opts.PlaylistFormat, err = playlistio.ParseFormat(req.Format); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	send := func(v any) {
+		enc.Encode(v)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	opts.Progress = func(p playlistio.BundleProgress) {
+		send(map[string]any{"progress": p})
+	}
+	result, err := playlistio.ExportBundle(playlist, req.Target, opts)
+	if err != nil {
+		send(map[string]string{"error": err.Error()})
+		return
+	}
+	send(map[string]any{"result": result})
+}
+
+// handleExportTable downloads the songs of playlist id, or of the whole
+// library without one, as format=csv or tsv. columns is a comma-separated
+// list of column names.
+func (s *WebServer) handleExportTable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	delimiter, err := playlistio.TableDelimiter(format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	columns, err := playlistio.ParseColumns(query.Get("columns"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name := "library"
+	var rows []playlistio.TableRow
+	if id := query.Get("id"); id != "" {
+		playlist, err := s.manager.GetPlaylist(id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		name = playlist.Name
+		if rows, err = s.manager.PlaylistTable(id); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+	} else {
+		rows = s.manager.LibraryTable()
+	}
+
+	var buf bytes.Buffer
+	if err := playlistio.WriteTable(&buf, rows, columns, delimiter); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if delimiter == playlistio.TSV {
+		contentType = "text/tab-separated-values; charset=utf-8"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": name + "." + strings.ToLower(format),
+	}))
+	w.Write(buf.Bytes())
+}
+
+// handleImportTable updates songs from an uploaded CSV or TSV file. Bad rows
+// are skipped and reported; with dryRun=true nothing is changed.
+func (s *WebServer) handleImportTable(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	delimiter, err := playlistio.TableDelimiter(filepath.Ext(header.Filename))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	edits, rowErrors, err := playlistio.ReadTable(file, delimiter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	dryRun := r.FormValue("dryRun") == "true"
+	updated, unknown := s.manager.ApplySongEdits(edits, dryRun)
+	rowErrors = append(rowErrors, unknown...)
+	sort.Slice(rowErrors, func(i, j int) bool { return rowErrors[i].Line < rowErrors[j].Line })
+
+	if !dryRun && updated > 0 {
+		if err := s.manager.Save(); err != nil {
+			respondSaveError(w, err)
+			return
+		}
+	}
+
+	respondJSON(w, map[string]any{
+		"updated": updated,
+		"dryRun":  dryRun,
+		"errors":  rowErrors,
+	})
+}
+
+func (s *WebServer) handleStatistics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := s.manager.GetStatistics()
+	respondJSON(w, stats)
+}
+
+func (s *WebServer) handleBackups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	backups, err := s.manager.ListBackups()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	respondJSON(w, backups)
+}
+
+func (s *WebServer) handleBackupDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	diff, err := s.manager.DiffBackup(r.URL.Query().Get("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	respondJSON(w, diff)
+}
+
+func (s *WebServer) handleRestoreBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.manager.RestoreBackup(req.Name); err != nil {
+		var conflict *manager.ConflictError
+		if errors.As(err, &conflict) {
+			respondSaveError(w, err)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "success"})
+}
+
+// handleHistory lists the changes recorded in the journal, newest first.
+// limit caps how many, 50 by default and 0 for all.
+func (s *WebServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "limit must be a number", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	entries, err := s.manager.History(limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type historyEntry struct {
+		Time       time.Time `json:"time"`
+		Actor      string    `json:"actor"`
+		Op         string    `json:"op"`
+		Change     string    `json:"change"`
+		PlaylistID string    `json:"playlistId,omitempty"`
+	}
+	history := make([]historyEntry, len(entries))
+	for i, entry := range entries {
+		history[i] = historyEntry{
+			Time:       entry.Time,
+			Actor:      entry.Actor,
+			Op:         entry.Op,
+			Change:     entry.Change(),
+			PlaylistID: entry.PlaylistID,
+		}
+	}
+	respondJSON(w, history)
+}
+
+// handleUndo describes what undo and redo would act on next when read, and
+// undoes the last change when posted to.
+func (s *WebServer) handleUndo(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		undo, redo := s.manager.UndoState()
+		respondJSON(w, map[string]string{"undo": undo, "redo": redo})
+	case http.MethodPost:
+		s.undoOrRedo(w, s.manager.Undo)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *WebServer) handleRedo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.undoOrRedo(w, s.manager.Redo)
+}
+
+func (s *WebServer) undoOrRedo(w http.ResponseWriter, step func() (string, error)) {
+	description, err := step()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.manager.Save(); err != nil {
+		respondSaveError(w, err)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "success", "change": description})
+}
+
+// handleRevisions lists the revisions of a playlist, oldest first.
+func (s *WebServer) handleRevisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	revisions, err := s.manager.Revisions(r.URL.Query().Get("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	respondJSON(w, revisions)
+}
+
+func (s *WebServer) handleRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	from, err := strconv.Atoi(query.Get("from"))
+	if err != nil {
+		http.Error(w, "from must be a revision number", http.StatusBadRequest)
+		return
+	}
+	to, err := strconv.Atoi(query.Get("to"))
+	if err != nil {
+		http.Error(w, "to must be a revision number", http.StatusBadRequest)
+		return
+	}
+
+	diff, err := s.manager.DiffRevisions(query.Get("id"), from, to)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	respondJSON(w, diff)
+}
+
+// handleRestoreRevision brings back a revision of a playlist, over it or as
+// a new playlist when asNew is set.
+func (s *WebServer) handleRestoreRevision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID       string `json:"id"`
+		Revision int    `json:"revision"`
+		AsNew    bool   `json:"asNew"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	playlist, err := s.manager.RestoreRevision(req.ID, req.Revision, req.AsNew)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if err := s.manager.Save(); err != nil {
+		respondSaveError(w, err)
+		return
+	}
+
+	respondJSON(w, playlist)
+}
+
+func (s *WebServer) handleNameRevision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID       string `json:"id"`
+		Revision int    `json:"revision"`
+		Name     string `json:"name"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := s.manager.NameRevision(req.ID, req.Revision, req.Name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "success"})
+}
+
+// handleResolveConflicts saves after a save was refused over conflicts,
+// keeping either this server's changes or the other program's.
+func (s *WebServer) handleResolveConflicts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Keep string `json:"keep"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var resolution manager.Resolution
+	switch req.Keep {
+	case "mine":
+		resolution = manager.KeepMine
+	case "theirs":
+		resolution = manager.KeepTheirs
+	default:
+		http.Error(w, "keep must be mine or theirs", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.manager.SaveResolving(resolution); err != nil {
+		respondSaveError(w, err)
+		return
+	}
+
+	respondJSON(w, map[string]string{"status": "success"})
+}
+
+// respondSaveError reports a failed save. A save refused because another
+// program changed the same data is a 409 listing the conflicts, to be settled
+// through /api/conflicts/resolve.
+func respondSaveError(w http.ResponseWriter, err error) {
+	var conflict *manager.ConflictError
+	if errors.As(err, &conflict) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]any{
+			"error":    err.Error(),
+			"conflict": conflict,
+		})
+		return
+	}
+	http.Error(w, "Failed to save: "+err.Error(), http.StatusInternalServerError)
 }
 
 func respondJSON(w http.ResponseWriter, data any) {
@@ -1,6 +1,9 @@
 // Global state
 let currentPlaylistId = null;
 let playlists = [];
+// Positions of the songs picked to move together, and of the song dragged
+let selectedPositions = new Set();
+let draggedPosition = null;
 
 document.addEventListener('DOMContentLoaded', function() {
     loadPlaylists();
@@ -13,6 +16,31 @@ document.addEventListener('DOMContentLoaded', function() {
     }, 5000);
 });
 
+// apiFetch is fetch for requests that save. When the server refuses to save
+// because another program changed the same data meanwhile, it asks which
+// changes to keep and settles the conflict before returning.
+async function apiFetch(url, options) {
+    const response = await fetch(url, options);
+    if (response.status !== 409) {
+        return response;
+    }
+
+    const report = await response.json();
+    const keepMine = confirm(report.error +
+        '\n\nOK keeps the changes made here, Cancel keeps the other program\'s.');
+    const resolved = await fetch('/api/conflicts/resolve', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ keep: keepMine ? 'mine' : 'theirs' })
+    });
+    if (!resolved.ok) {
+        alert('Error saving: ' + await resolved.text());
+    }
+    loadPlaylists();
+    loadStatistics();
+    return resolved;
+}
+
 async function loadPlaylists() {
     try {
         const response = await fetch('/api/playlists');
@@ -26,11 +54,42 @@ async function loadPlaylists() {
                 renderSongs(playlist);
             }
         }
+        loadUndoState();
     } catch (error) {
         console.error('Error loading playlists:', error);
     }
 }
 
+async function loadUndoState() {
+    try {
+        const response = await fetch('/api/undo');
+        const state = await response.json();
+        setStepButton('undoButton', 'Undo', state.undo);
+        setStepButton('redoButton', 'Redo', state.redo);
+    } catch (error) {
+        console.error('Error loading undo state:', error);
+    }
+}
+
+function setStepButton(id, label, description) {
+    const button = document.getElementById(id);
+    button.disabled = !description;
+    button.title = description ? `${label} ${description}` : `Nothing to ${label.toLowerCase()}`;
+}
+
+async function undoOrRedo(action) {
+    try {
+        const response = await apiFetch(`/api/${action}`, { method: 'POST' });
+        if (!response.ok) {
+            alert(await response.text());
+        }
+        loadPlaylists();
+        loadStatistics();
+    } catch (error) {
+        alert(`Error trying to ${action}: ` + error.message);
+    }
+}
+
 async function loadStatistics() {
     try {
         const response = await fetch('/api/statistics');
@@ -60,7 +119,7 @@ function renderPlaylists() {
              onclick="selectPlaylist('${playlist.id}')">
             <div class="playlist-name">${escapeHtml(playlist.name)}</div>
             <div class="playlist-info">
-                ${playlist.songs ? playlist.songs.length : 0} songs • 
+                ${playlist.smart ? 'Smart • ' : ''}${playlist.songs ? playlist.songs.length : 0} songs • 
                 ${formatDuration(calculateTotalDuration(playlist.songs))}
             </div>
         </div>
@@ -77,35 +136,206 @@ function selectPlaylist(playlistId) {
     }
 }
 
-function renderSongs(playlist) {
+// renderSongs shows the songs of a playlist. A preview shows them in an
+// order that isn't stored, so they can't be edited there.
+function renderSongs(playlist, preview = false) {
     const container = document.getElementById('songsList');
     const titleElement = document.getElementById('playlistTitle');
     const actionsElement = document.getElementById('playlistActions');
     
-    titleElement.textContent = `🎵 ${playlist.name}`;
+    titleElement.textContent = `🎵 ${playlist.name}${preview ? ' (sorted view)' : ''}`;
+    // Positions change with the songs, so picks don't outlive them
+    selectedPositions.clear();
     actionsElement.style.display = 'flex';
+
+    // Smart playlists are filled by their rules, so hide the manual editing
+    actionsElement.querySelectorAll('.manual-only').forEach(button => {
+        button.style.display = playlist.smart ? 'none' : '';
+    });
     
     if (!playlist.songs || playlist.songs.length === 0) {
-        container.innerHTML = '<div class="empty-state"><p>No songs in this playlist yet</p></div>';
+        const message = playlist.smart ? 'No songs match the rules of this playlist' : 'No songs in this playlist yet';
+        container.innerHTML = `<div class="empty-state"><p>${message}</p></div>`;
         return;
     }
     
-    container.innerHTML = playlist.songs.map(song => `
-        <div class="song-item">
+    const editable = !playlist.smart && !preview;
+    container.innerHTML = playlist.songs.map((song, index) => `
+        <div class="song-item" ${!editable ? '' : `draggable="true"
+            onclick="toggleSongSelection(event, ${index})" ondragstart="dragSong(event, ${index})"
+            ondragover="dragOverSong(event)" ondragleave="dragLeaveSong(event)" ondrop="dropSong(event, ${index})"`}>
             <div class="song-details">
-                <div class="song-title">${escapeHtml(song.title)}</div>
+                <div class="song-title">${escapeHtml(song.title)}${song.missing ? ' <span class="song-missing">(file missing)</span>' : ''}</div>
                 <div class="song-meta">
                     ${escapeHtml(song.artist)} • ${escapeHtml(song.album)} • 
                     ${escapeHtml(song.genre)} • ${formatDuration(song.duration)}
                 </div>
             </div>
             <div class="song-actions">
-                <button onclick="removeSong('${song.id}')">Remove</button>
+                ${!editable ? '' : `
+                    <button class="move-button" onclick="moveSongBy(${index}, -1)" title="Move up">↑</button>
+                    <button class="move-button" onclick="moveSongBy(${index}, 1)" title="Move down">↓</button>
+                    <button onclick="removeSong('${song.id}')">Remove</button>`}
             </div>
         </div>
     `).join('');
 }
 
+const sortFieldLabels = {
+    artist: 'Artist', album: 'Album', year: 'Year', track: 'Track number',
+    title: 'Title', duration: 'Duration', genre: 'Genre', added: 'Date added',
+};
+
+function showSortModal() {
+    if (!currentPlaylistId) return;
+    const playlist = playlists.find(p => p.id === currentPlaylistId);
+    const options = '<option value="">—</option>' + Object.entries(sortFieldLabels)
+        .map(([field, label]) => `<option value="${field}">${label}</option>`).join('');
+
+    document.getElementById('sortKeys').innerHTML = [1, 2, 3].map(n => `
+        <div class="form-group">
+            <label>${n === 1 ? 'Sort by' : 'Then by'}</label>
+            <select class="sort-field">${options}</select>
+            <label class="checkbox-label"><input type="checkbox" class="sort-descending"> Descending</label>
+        </div>
+    `).join('');
+    // Smart playlists keep the order of their rules, so they can only be viewed sorted
+    document.getElementById('saveSortButton').style.display = playlist && playlist.smart ? 'none' : '';
+    document.getElementById('sortModal').style.display = 'block';
+}
+
+function readSortKeys() {
+    const fields = document.querySelectorAll('#sortKeys .sort-field');
+    const descending = document.querySelectorAll('#sortKeys .sort-descending');
+    const keys = [];
+    fields.forEach((select, i) => {
+        if (select.value) {
+            keys.push({ field: select.value, descending: descending[i].checked });
+        }
+    });
+    return keys;
+}
+
+async function previewSort() {
+    const keys = readSortKeys();
+    if (keys.length === 0) {
+        alert('Pick a field to sort by');
+        return;
+    }
+
+    try {
+        const by = keys.map(key => (key.descending ? '-' : '') + key.field).join(',');
+        const params = new URLSearchParams({ id: currentPlaylistId, by: by });
+        const response = await fetch('/api/playlists/sorted?' + params);
+        if (!response.ok) {
+            alert('Error sorting playlist: ' + await response.text());
+            return;
+        }
+        const playlist = playlists.find(p => p.id === currentPlaylistId);
+        closeModal('sortModal');
+        renderSongs({ ...playlist, songs: await response.json() }, true);
+    } catch (error) {
+        alert('Error sorting playlist: ' + error.message);
+    }
+}
+
+async function sortPlaylist(event) {
+    event.preventDefault();
+    const keys = readSortKeys();
+    if (keys.length === 0) {
+        alert('Pick a field to sort by');
+        return;
+    }
+
+    try {
+        const response = await apiFetch('/api/playlists/sort', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ id: currentPlaylistId, keys: keys })
+        });
+
+        if (response.ok) {
+            closeModal('sortModal');
+            loadPlaylists();
+        } else {
+            alert('Error sorting playlist: ' + await response.text());
+        }
+    } catch (error) {
+        alert('Error sorting playlist: ' + error.message);
+    }
+}
+
+// Clicking songs picks them to drag together; clicking one again drops it
+// from the pick.
+function toggleSongSelection(event, index) {
+    if (event.target.closest('button')) return;
+    if (selectedPositions.has(index)) {
+        selectedPositions.delete(index);
+    } else {
+        selectedPositions.add(index);
+    }
+    event.currentTarget.classList.toggle('selected');
+}
+
+function dragSong(event, index) {
+    draggedPosition = index;
+    event.dataTransfer.effectAllowed = 'move';
+}
+
+function dragOverSong(event) {
+    if (draggedPosition === null) return;
+    event.preventDefault();
+    event.currentTarget.classList.add('drag-over');
+}
+
+function dragLeaveSong(event) {
+    event.currentTarget.classList.remove('drag-over');
+}
+
+// dropSong moves the dragged song, or all the picked ones when it is one of
+// them, to where they were dropped: below the song dropped on when dragged
+// down, above it when dragged up.
+async function dropSong(event, target) {
+    event.preventDefault();
+    event.currentTarget.classList.remove('drag-over');
+    if (draggedPosition === null) return;
+
+    const positions = selectedPositions.has(draggedPosition)
+        ? [...selectedPositions].sort((a, b) => a - b)
+        : [draggedPosition];
+    draggedPosition = null;
+
+    const playlist = playlists.find(p => p.id === currentPlaylistId);
+    const above = positions.filter(position => position < target).length;
+    let to = above === positions.length ? target - above + 1 : target - above;
+    to = Math.max(0, Math.min(to, playlist.songs.length - positions.length));
+    await reorderSongs({ positions: positions, to: to });
+}
+
+async function moveSongBy(index, offset) {
+    await reorderSongs({ positions: [index], offset: offset });
+}
+
+async function reorderSongs(change) {
+    if (!currentPlaylistId) return;
+
+    try {
+        const response = await apiFetch('/api/playlists/reorder', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ id: currentPlaylistId, ...change })
+        });
+
+        if (response.ok) {
+            loadPlaylists();
+        } else {
+            alert('Error moving songs: ' + await response.text());
+        }
+    } catch (error) {
+        alert('Error moving songs: ' + error.message);
+    }
+}
+
 async function createPlaylist(event) {
     event.preventDefault();
     
@@ -113,7 +343,7 @@ async function createPlaylist(event) {
     const description = document.getElementById('playlistDescription').value;
     
     try {
-        const response = await fetch('/api/playlists/create', {
+        const response = await apiFetch('/api/playlists/create', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({ name, description })
@@ -131,6 +361,73 @@ async function createPlaylist(event) {
     }
 }
 
+async function createSmartPlaylist(event) {
+    event.preventDefault();
+
+    const value = id => document.getElementById(id).value.trim();
+    const rules = [];
+
+    if (value('smartGenre')) {
+        rules.push({ op: 'genre_equals', value: value('smartGenre') });
+    }
+    if (value('smartNotGenre')) {
+        rules.push({ op: 'not', rules: [{ op: 'genre_equals', value: value('smartNotGenre') }] });
+    }
+    if (value('smartYearFrom') || value('smartYearTo')) {
+        rules.push({
+            op: 'year_between',
+            min: parseInt(value('smartYearFrom') || '0', 10),
+            max: parseInt(value('smartYearTo') || '9999', 10)
+        });
+    }
+    if (value('smartArtists')) {
+        const artists = value('smartArtists').split(',').map(a => a.trim()).filter(a => a);
+        rules.push({ op: 'artist_in', values: artists });
+    }
+    if (value('smartMaxMinutes')) {
+        rules.push({ op: 'duration_under', duration: parseInt(value('smartMaxMinutes'), 10) * 60000000000 });
+    }
+    if (value('smartAddedDays')) {
+        rules.push({ op: 'added_within_days', days: parseInt(value('smartAddedDays'), 10) });
+    }
+
+    if (rules.length === 0) {
+        alert('A smart playlist needs at least one rule');
+        return;
+    }
+
+    const sort = value('smartSort');
+    const criteria = {
+        match: { op: value('smartMatch'), rules },
+        limit: parseInt(value('smartLimit') || '0', 10),
+        sort_by: sort === 'random' ? '' : sort,
+        descending: document.getElementById('smartDescending').checked,
+        random: sort === 'random'
+    };
+
+    try {
+        const response = await apiFetch('/api/playlists/smart/create', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                name: value('smartName'),
+                description: value('smartDescription'),
+                criteria
+            })
+        });
+
+        if (response.ok) {
+            closeModal('smartPlaylistModal');
+            event.target.reset();
+            loadPlaylists();
+        } else {
+            alert('Error creating smart playlist: ' + await response.text());
+        }
+    } catch (error) {
+        alert('Error creating smart playlist: ' + error.message);
+    }
+}
+
 async function addSong(event) {
     event.preventDefault();
     
@@ -142,11 +439,14 @@ async function addSong(event) {
     const song = {
         playlist_id: currentPlaylistId,
         file_path: document.getElementById('filePath').value,
-        duration: parseInt(document.getElementById('songDuration').value),
     };
+    const position = document.getElementById('songPosition').value;
+    if (position) {
+        song.position = parseInt(position, 10) - 1;
+    }
     
     try {
-        const response = await fetch('/api/songs/add', {
+        const response = await apiFetch('/api/songs/add', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify(song)
@@ -156,6 +456,7 @@ async function addSong(event) {
             closeModal('addSongModal');
 
             document.getElementById('filePath').value = '';
+            document.getElementById('songPosition').value = '';
             loadPlaylists();
             loadStatistics();
         }else{
@@ -179,7 +480,7 @@ async function scanFolder(event) {
     };
     
     try {
-        const response = await fetch('/api/songs/scan', {
+        const response = await apiFetch('/api/songs/scan', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify(folder)
@@ -198,11 +499,59 @@ async function scanFolder(event) {
     }
 }
 
+async function rescanPlaylist() {
+    if (!currentPlaylistId) return;
+
+    try {
+        const rootsResponse = await fetch('/api/rescan/roots');
+        const roots = (await rootsResponse.json()) || [];
+        const bound = roots.filter(root => root.playlist_id === currentPlaylistId);
+
+        if (bound.length === 0) {
+            alert('No folders have been scanned into this playlist yet');
+            return;
+        }
+
+        for (const root of bound) {
+            const planResponse = await apiFetch('/api/rescan/plan', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify({ path: root.path, playlist_id: currentPlaylistId })
+            });
+            if (!planResponse.ok) {
+                alert('Error scanning ' + root.path + ': ' + await planResponse.text());
+                continue;
+            }
+
+            const diff = await planResponse.json();
+            const summary = `${diff.added.length} added, ${diff.changed.length} changed, ` +
+                `${diff.moved.length} moved, ${diff.removed.length} removed`;
+
+            if (diff.added.length + diff.changed.length + diff.moved.length + diff.removed.length === 0) {
+                alert(root.path + ': nothing to update');
+                continue;
+            }
+            if (!confirm(root.path + '\n' + summary + '\n\nApply these changes?')) continue;
+
+            await apiFetch('/api/rescan/apply', {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(diff)
+            });
+        }
+
+        loadPlaylists();
+        loadStatistics();
+    } catch (error) {
+        alert('Error rescanning: ' + error.message);
+    }
+}
+
 async function removeSong(songId) {
     if (!confirm('Remove this song?')) return;
     
     try {
-        const response = await fetch('/api/songs/remove', {
+        const response = await apiFetch('/api/songs/remove', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({
@@ -226,7 +575,7 @@ async function deletePlaylist() {
     if (!confirm('Delete this entire playlist? This cannot be undone!')) return;
     
     try {
-        const response = await fetch('/api/playlists/delete', {
+        const response = await apiFetch('/api/playlists/delete', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({ id: currentPlaylistId })
@@ -249,7 +598,7 @@ async function shufflePlaylist() {
     if (!currentPlaylistId) return;
     
     try {
-        const response = await fetch('/api/playlists/shuffle', {
+        const response = await apiFetch('/api/playlists/shuffle', {
             method: 'POST',
             headers: { 'Content-Type': 'application/json' },
             body: JSON.stringify({ id: currentPlaylistId })
@@ -263,15 +612,88 @@ async function shufflePlaylist() {
     }
 }
 
+function exportPlaylist(event) {
+    event.preventDefault();
+    if (!currentPlaylistId) return;
+
+    const params = new URLSearchParams({
+        id: currentPlaylistId,
+        format: document.getElementById('exportFormat').value,
+    });
+    if (document.getElementById('exportRelative').checked) {
+        params.set('paths', 'relative');
+        params.set('base', document.getElementById('exportBase').value);
+    }
+
+    closeModal('exportPlaylistModal');
+    window.location = '/api/playlists/export?' + params;
+}
+
+function toggleExportBase() {
+    const relative = document.getElementById('exportRelative').checked;
+    document.getElementById('exportBaseGroup').style.display = relative ? 'block' : 'none';
+    document.getElementById('exportBase').required = relative;
+}
+
+async function importPlaylist(event) {
+    event.preventDefault();
+
+    const form = new FormData();
+    form.append('file', document.getElementById('importFile').files[0]);
+    form.append('name', document.getElementById('importName').value);
+    form.append('base', document.getElementById('importBase').value);
+
+    try {
+        const response = await apiFetch('/api/playlists/import', {
+            method: 'POST',
+            body: form
+        });
+
+        const isJSON = (response.headers.get('Content-Type') || '').includes('application/json');
+        const data = isJSON ? await response.json() : { error: await response.text() };
+        const skipped = (data.unresolved || []).map(entry =>
+            `Line ${entry.line}: ${entry.path} (${entry.reason})`).join('\n');
+
+        if (!response.ok) {
+            alert('Error importing playlist: ' + data.error + (skipped ? '\n\n' + skipped : ''));
+            return;
+        }
+
+        closeModal('importPlaylistModal');
+        document.getElementById('importFile').value = '';
+        document.getElementById('importName').value = '';
+        if (skipped) {
+            alert(`Imported ${data.playlist.songs.length} songs. These entries could not be loaded:\n\n` + skipped);
+        }
+        await loadPlaylists();
+        selectPlaylist(data.playlist.id);
+        loadStatistics();
+    } catch (error) {
+        alert('Error importing playlist: ' + error.message);
+    }
+}
+
 async function searchSongs() {
     const query = document.getElementById('searchInput').value.trim();
     
     if (query.length < 2) {
         return;
     }
+
+    suggestWords(document.getElementById('searchInput').value);
+    
+    const errorElement = document.getElementById('searchError');
     
     try {
-        const response = await fetch(`/api/songs/search?q=${encodeURIComponent(query)}`);
+        const fuzzy = document.getElementById('fuzzySearch').checked;
+        const response = await fetch(`/api/songs/search?q=${encodeURIComponent(query)}&fuzzy=${fuzzy}`);
+        if (response.status === 400) {
+            // Keep typing quietly, only say what is wrong with the query
+            const problem = await response.json();
+            errorElement.textContent = `Position ${problem.position}: ${problem.error}`;
+            return;
+        }
+        errorElement.textContent = '';
         const results = await response.json();
         
         if (results && results.length > 0) {
@@ -282,6 +704,32 @@ async function searchSongs() {
     }
 }
 
+// Offer completions for the word being typed, keeping the rest of the query
+async function suggestWords(input) {
+    const match = input.match(/^(.*?)([^\s()"]*)$/);
+    const head = match[1];
+    const negation = match[2].startsWith('-') ? '-' : '';
+    const word = match[2].slice(negation.length);
+    const field = word.includes(':') ? word.slice(0, word.indexOf(':') + 1) : '';
+    const list = document.getElementById('searchSuggestions');
+
+    if (word.length === field.length) {
+        list.innerHTML = '';
+        return;
+    }
+
+    try {
+        const response = await fetch(`/api/songs/suggest?q=${encodeURIComponent(word)}`);
+        const suggestions = (await response.json()) || [];
+
+        list.innerHTML = suggestions.map(suggestion =>
+            `<option value="${escapeHtml(head + negation + field + suggestion)}"></option>`
+        ).join('');
+    } catch (error) {
+        console.error('Error loading suggestions:', error);
+    }
+}
+
 function showSearchResults(results) {
     const modal = document.getElementById('searchResultsModal');
     const container = document.getElementById('searchResults');
@@ -290,9 +738,10 @@ function showSearchResults(results) {
         <div class="search-result-item">
             <div class="search-result-song">
                 ${escapeHtml(result.Song.title)} - ${escapeHtml(result.Song.artist)}
+                ${result.Similarity < 1 ? `<span class="search-similarity">${Math.round(result.Similarity * 100)}% similar</span>` : ''}
             </div>
             <div class="search-result-playlist">
-                In playlist: ${escapeHtml(result.PlaylistName)}
+                ${result.PlaylistID ? 'In playlist: ' + escapeHtml(result.PlaylistName) : 'In library only'}
             </div>
         </div>
     `).join('');
@@ -300,11 +749,243 @@ function showSearchResults(results) {
     modal.style.display = 'block';
 }
 
+async function showBackupsModal() {
+    const container = document.getElementById('backupsList');
+    container.innerHTML = '<div class="loading">Loading backups...</div>';
+    document.getElementById('backupsModal').style.display = 'block';
+
+    try {
+        const response = await fetch('/api/backups');
+        if (!response.ok) {
+            container.innerHTML = `<div class="empty-state"><p>${escapeHtml(await response.text())}</p></div>`;
+            return;
+        }
+        const backups = await response.json();
+        if (backups.length === 0) {
+            container.innerHTML = '<div class="empty-state"><p>No backups yet</p></div>';
+            return;
+        }
+
+        container.innerHTML = backups.map(backup => `
+            <div class="search-result-item">
+                <div class="search-result-song">${escapeHtml(new Date(backup.createdAt).toLocaleString())}</div>
+                <div class="search-result-playlist backup-diff" id="diff-${backup.name}"></div>
+                <button class="btn btn-secondary" onclick="showBackupDiff('${backup.name}')">Compare</button>
+                <button class="btn btn-danger" onclick="restoreBackup('${backup.name}')">Restore</button>
+            </div>
+        `).join('');
+    } catch (error) {
+        alert('Error loading backups: ' + error.message);
+    }
+}
+
+async function showHistoryModal() {
+    const container = document.getElementById('historyList');
+    container.innerHTML = '<div class="loading">Loading history...</div>';
+    document.getElementById('historyModal').style.display = 'block';
+
+    try {
+        const response = await fetch('/api/history?limit=100');
+        if (!response.ok) {
+            container.innerHTML = `<div class="empty-state"><p>${escapeHtml(await response.text())}</p></div>`;
+            return;
+        }
+        const entries = await response.json();
+        if (entries.length === 0) {
+            container.innerHTML = '<div class="empty-state"><p>No changes recorded yet</p></div>';
+            return;
+        }
+
+        container.innerHTML = entries.map(entry => `
+            <div class="search-result-item">
+                <div class="search-result-song">${escapeHtml(entry.change)}</div>
+                <div class="search-result-playlist">${escapeHtml(new Date(entry.time).toLocaleString())} by ${escapeHtml(entry.actor)}</div>
+            </div>
+        `).join('');
+    } catch (error) {
+        alert('Error loading history: ' + error.message);
+    }
+}
+
+async function showRevisionsModal() {
+    if (!currentPlaylistId) return;
+    const container = document.getElementById('revisionsList');
+    container.innerHTML = '<div class="loading">Loading revisions...</div>';
+    document.getElementById('revisionsModal').style.display = 'block';
+
+    try {
+        const response = await fetch('/api/playlists/revisions?id=' + encodeURIComponent(currentPlaylistId));
+        if (!response.ok) {
+            container.innerHTML = `<div class="empty-state"><p>${escapeHtml(await response.text())}</p></div>`;
+            return;
+        }
+        const revisions = await response.json();
+        if (revisions.length === 0) {
+            container.innerHTML = '<div class="empty-state"><p>No revisions recorded yet</p></div>';
+            return;
+        }
+
+        const latest = revisions.length;
+        container.innerHTML = revisions.slice().reverse().map(revision => `
+            <div class="search-result-item">
+                <div class="search-result-song">${revision.number}.${revision.name ? ' ' + escapeHtml(revision.name) : ''} ${escapeHtml(revision.summary)}</div>
+                <div class="search-result-playlist">${escapeHtml(new Date(revision.time).toLocaleString())} by ${escapeHtml(revision.author)}</div>
+                <div class="search-result-playlist backup-diff" id="revision-diff-${revision.number}"></div>
+                ${revision.number < latest ? `<button class="btn btn-secondary" onclick="showRevisionDiff(${revision.number}, ${latest})">Compare with latest</button>` : ''}
+                <button class="btn btn-secondary" onclick="nameRevision(${revision.number})">Name</button>
+                <button class="btn btn-secondary" onclick="restoreRevision(${revision.number}, true)">Restore as New</button>
+                <button class="btn btn-danger" onclick="restoreRevision(${revision.number}, false)">Restore</button>
+            </div>
+        `).join('');
+    } catch (error) {
+        alert('Error loading revisions: ' + error.message);
+    }
+}
+
+async function showRevisionDiff(from, to) {
+    try {
+        const params = new URLSearchParams({ id: currentPlaylistId, from: from, to: to });
+        const response = await fetch('/api/playlists/revisions/diff?' + params);
+        if (!response.ok) {
+            throw new Error(await response.text());
+        }
+        const diff = await response.json();
+        const lines = [...diff.details];
+        diff.added.forEach(song => lines.push(`+ ${song.title} - ${song.artist}`));
+        diff.removed.forEach(song => lines.push(`- ${song.title} - ${song.artist}`));
+        diff.moved.forEach(move => lines.push(`~ ${move.song.title} - ${move.song.artist} (${move.from + 1} → ${move.to + 1})`));
+        document.getElementById('revision-diff-' + from).textContent = lines.length ? lines.join('\n') : 'No changes';
+    } catch (error) {
+        alert('Error comparing revisions: ' + error.message);
+    }
+}
+
+async function restoreRevision(number, asNew) {
+    if (!asNew && !confirm(`Replace the playlist with revision ${number}? This can be undone.`)) return;
+
+    try {
+        const response = await apiFetch('/api/playlists/revisions/restore', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ id: currentPlaylistId, revision: number, asNew: asNew })
+        });
+
+        if (response.ok) {
+            const playlist = await response.json();
+            closeModal('revisionsModal');
+            currentPlaylistId = playlist.id;
+            loadPlaylists();
+            loadStatistics();
+        } else {
+            alert('Error restoring revision: ' + await response.text());
+        }
+    } catch (error) {
+        alert('Error restoring revision: ' + error.message);
+    }
+}
+
+async function nameRevision(number) {
+    const name = prompt(`Name for revision ${number}:`);
+    if (name === null) return;
+
+    try {
+        const response = await apiFetch('/api/playlists/revisions/name', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ id: currentPlaylistId, revision: number, name: name })
+        });
+
+        if (response.ok) {
+            showRevisionsModal();
+        } else {
+            alert('Error naming revision: ' + await response.text());
+        }
+    } catch (error) {
+        alert('Error naming revision: ' + error.message);
+    }
+}
+
+function describeBackupDiff(diff) {
+    const parts = [
+        `${diff.playlistsAdded.length} playlists and ${diff.songsAdded.length} songs brought back`,
+        `${diff.playlistsRemoved.length} playlists and ${diff.songsRemoved.length} songs removed`,
+        `${diff.playlistsChanged.length} playlists changed`,
+    ];
+    const names = (label, list) => list.length ? `\n${label}: ${list.join(', ')}` : '';
+    return parts.join(', ') +
+        names('Brought back', diff.playlistsAdded) +
+        names('Removed', diff.playlistsRemoved) +
+        names('Changed', diff.playlistsChanged);
+}
+
+async function fetchBackupDiff(name) {
+    const response = await fetch('/api/backups/diff?name=' + encodeURIComponent(name));
+    if (!response.ok) {
+        throw new Error(await response.text());
+    }
+    return response.json();
+}
+
+async function showBackupDiff(name) {
+    try {
+        const diff = await fetchBackupDiff(name);
+        document.getElementById('diff-' + name).textContent = describeBackupDiff(diff);
+    } catch (error) {
+        alert('Error comparing backup: ' + error.message);
+    }
+}
+
+async function restoreBackup(name) {
+    try {
+        const diff = await fetchBackupDiff(name);
+        if (!confirm('Restoring this backup means:\n' + describeBackupDiff(diff) +
+                '\n\nThe current collection is backed up first. Restore?')) {
+            return;
+        }
+
+        const response = await apiFetch('/api/backups/restore', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ name: name })
+        });
+
+        if (response.ok) {
+            closeModal('backupsModal');
+            currentPlaylistId = null;
+            document.getElementById('songsList').innerHTML = '<div class="empty-state"><p>Select a playlist to view songs</p></div>';
+            document.getElementById('playlistTitle').textContent = 'Select a playlist';
+            document.getElementById('playlistActions').style.display = 'none';
+            loadPlaylists();
+            loadStatistics();
+        } else {
+            alert('Error restoring backup: ' + await response.text());
+        }
+    } catch (error) {
+        alert('Error restoring backup: ' + error.message);
+    }
+}
+
 // Modal functions
 function showCreatePlaylistModal() {
     document.getElementById('createPlaylistModal').style.display = 'block';
 }
 
+function showSmartPlaylistModal() {
+    document.getElementById('smartPlaylistModal').style.display = 'block';
+}
+
+function showExportPlaylistModal() {
+    if (!currentPlaylistId) {
+        alert('Please select a playlist first');
+        return;
+    }
+    document.getElementById('exportPlaylistModal').style.display = 'block';
+}
+
+function showImportPlaylistModal() {
+    document.getElementById('importPlaylistModal').style.display = 'block';
+}
+
 function showAddSongModal() {
     if (!currentPlaylistId) {
         alert('Please select a playlist first');